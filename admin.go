@@ -0,0 +1,366 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Admin API of the plugin, served on `--admin-address`.
+// Operations which are not part of the Docker volume plugin protocol are exposed here.
+// The admin API is not authenticated, it should only be served on a trusted address (ex: 127.0.0.1:9101).
+
+// serves the admin API on `address`, a TCP address or the path of a unix socket prefixed with `unix://`.
+func serveAdmin(address string, h http.Handler) error {
+	network := "tcp"
+	if strings.HasPrefix(address, "unix://") {
+		network, address = "unix", strings.TrimPrefix(address, "unix://")
+		// remove the socket left behind by a previous instance.
+		os.Remove(address)
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	if network == "unix" {
+		// the admin API is not authenticated, restrict the socket to root.
+		if err := os.Chmod(address, 0600); err != nil {
+			return err
+		}
+	}
+	return http.Serve(l, h)
+}
+
+// returns the handler serving the admin API, with the profiles of the plugin under `/debug/pprof/`
+// if `profiling` is set (`--pprof`).
+func (d *minfsDriver) adminHandler(profiling bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/snapshots", d.serveSnapshots)
+	mux.HandleFunc("/state", d.serveState)
+	mux.HandleFunc("/volumes", d.serveVolumes)
+	mux.HandleFunc("/volumes/", d.serveVolume)
+	mux.HandleFunc("/drain", d.serveDrain)
+	mux.HandleFunc("/options", serveOptions)
+	mux.Handle("/debug/vars", expvar.Handler())
+	if profiling {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
+
+// writes `v` as the JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writes the error as the JSON response.
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	logrus.WithField("status", status).Error(err)
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// snapshotsResponse - snapshots of a volume.
+type snapshotsResponse struct {
+	Volume    string   `json:"volume"`
+	Snapshots []string `json:"snapshots"`
+}
+
+// serves `/snapshots?volume=<volume>`.
+// GET lists the snapshots taken by the plugin, POST takes a new snapshot.
+func (d *minfsDriver) serveSnapshots(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("volume")
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("volume parameter cannot be empty"))
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	v := d.lockVolume(name)
+	if v == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("volume %s not found", name))
+		return
+	}
+	defer v.ops.Unlock()
+	d.Lock()
+	defer d.Unlock()
+
+	status := http.StatusOK
+	if r.Method == "POST" {
+		t, err := d.takeSnapshot(v)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		// the snapshots are kept in the state file.
+		d.markDirty()
+		logrus.WithFields(logrus.Fields{
+			"volume":   name,
+			"snapshot": t.Format(time.RFC3339),
+		}).Info("Snapshot taken.")
+		status = http.StatusCreated
+	}
+	res := snapshotsResponse{Volume: name, Snapshots: []string{}}
+	for _, t := range v.snapshots {
+		res.Snapshots = append(res.Snapshots, t.Format(time.RFC3339))
+	}
+	writeJSON(w, status, res)
+}
+
+// serves `/state`.
+// GET exports the definitions of all the volumes as a state bundle, POST imports a state bundle.
+func (d *minfsDriver) serveState(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		d.RLock()
+		bundle, err := d.exportState(false)
+		d.RUnlock()
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, bundle)
+	case "POST":
+		var bundle stateBundle
+		if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid state bundle: %v", err))
+			return
+		}
+		d.Lock()
+		res, err := d.importState(bundle)
+		d.Unlock()
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// volumeDetail - volume as reported by the admin API.
+type volumeDetail struct {
+	Name       string                 `json:"name"`
+	Driver     string                 `json:"driver,omitempty"`
+	Mountpoint string                 `json:"mountpoint"`
+	Endpoint   string                 `json:"endpoint"`
+	Bucket     string                 `json:"bucket"`
+	Status     map[string]interface{} `json:"status"`
+}
+
+// returns the details of the volume, the credentials are not included.
+func newVolumeDetail(v *mountInfo) volumeDetail {
+	return volumeDetail{
+		Name:       v.name,
+		Driver:     v.driver,
+		Mountpoint: v.mountPoint,
+		Endpoint:   v.config.endpoint,
+		Bucket:     v.config.bucket,
+		Status:     v.status(),
+	}
+}
+
+// serves `/volumes`, lists all the volumes of all the driver aliases with their details.
+func (d *minfsDriver) serveVolumes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	d.RLock()
+	vols := make([]volumeDetail, 0, len(d.mounts))
+	for _, v := range d.mounts {
+		vols = append(vols, newVolumeDetail(v))
+	}
+	d.RUnlock()
+	sort.Slice(vols, func(i, j int) bool { return vols[i].Name < vols[j].Name })
+	writeJSON(w, http.StatusOK, vols)
+}
+
+// serves `/volumes/<volume>[/<action>]`.
+// GET /volumes/<volume> returns the details of the volume.
+// POST /volumes/<volume>/unmount unmounts the volume even if it's in use by containers.
+// POST /volumes/<volume>/credentials sets the credentials of the volume, the body is
+// `{"accessKey": "...", "secretKey": "..."}`, the volume is remounted if it's mounted.
+// POST /volumes/<volume>/remount restarts minfs serving the volume.
+// POST /volumes/<volume>/check verifies the bucket and the mount of the volume.
+func (d *minfsDriver) serveVolume(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/volumes/"), "/", 2)
+	name, action := parts[0], ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	if (action == "" && r.Method != "GET") || (action != "" && r.Method != "POST") {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	var creds volumeCredentials
+	if action == "credentials" {
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil || creds.AccessKey == "" || creds.SecretKey == "" {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("accessKey and secretKey are required"))
+			return
+		}
+	}
+
+	v := d.lockVolume(name)
+	if v == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("volume %s not found", name))
+		return
+	}
+	defer v.ops.Unlock()
+	d.Lock()
+	defer d.Unlock()
+
+	switch action {
+	case "":
+	case "unmount":
+		if err := d.releaseVolume(v); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		logrus.WithFields(logrus.Fields{
+			"volume":      name,
+			"connections": v.connections,
+		}).Warn("Volume force unmounted.")
+		v.connections = 0
+		d.untrackMount(v, "")
+		d.notify(eventUnmounted, v, "force unmounted")
+	case "remount":
+		// snapshots and volumes of missing buckets are not served by minfs.
+		if v.connections == 0 || !v.config.snapshot.IsZero() || v.bucketMissing {
+			writeJSONError(w, http.StatusConflict, fmt.Errorf("volume %s is not mounted by minfs", name))
+			return
+		}
+		if err := d.remountVolume(v, "remount requested"); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		logrus.WithField("volume", name).Info("Volume remounted.")
+	case "credentials":
+		if err := d.updateCredentials(v, creds.AccessKey, creds.SecretKey); err != nil {
+			status := http.StatusInternalServerError
+			if errorCodeOf(err) == errBadOption {
+				status = http.StatusBadRequest
+			}
+			writeJSONError(w, status, err)
+			return
+		}
+	case "check":
+		res := map[string]interface{}{"volume": name, "healthy": true}
+		if err := d.checkVolume(v); err != nil {
+			res["healthy"] = false
+			res["error"] = err.Error()
+			res["code"] = errorCodeOf(err)
+		}
+		writeJSON(w, http.StatusOK, res)
+		return
+	default:
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown action %s", action))
+		return
+	}
+	writeJSON(w, http.StatusOK, newVolumeDetail(v))
+}
+
+// verifies that the bucket of the volume is reachable and that its mount responds.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) checkVolume(v *mountInfo) error {
+	mounted := v.mounted()
+	return d.unlocked(func(ctx context.Context) error {
+		if err := d.checkBucket(ctx, v.config); err != nil {
+			return err
+		}
+		if !mounted {
+			return nil
+		}
+		// the mount of a crashed minfs fails with ENOTCONN.
+		if _, err := os.Stat(v.mountPoint); err != nil {
+			return newCodedError(errInternal, "mount of volume %s is not responding: %v", v.name, err)
+		}
+		return nil
+	})
+}
+
+// enters or leaves drain mode.
+// Must not be called with the driver lock held.
+func (d *minfsDriver) setDraining(draining bool) {
+	d.Lock()
+	d.draining = draining
+	d.Unlock()
+	if draining {
+		logrus.Warn("Draining, new volumes and mounts are refused.")
+		// the mounts kept while the volumes are idle would keep the host busy.
+		d.releaseIdleVolumes(0)
+	} else {
+		logrus.Info("Drain mode left.")
+	}
+}
+
+// serves `/options`.
+// GET returns the schema of the `-o` options supported by the plugin, see `volumeOptions`.
+func serveOptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, volumeOptions)
+}
+
+// serves `/drain`.
+// POST puts the plugin in drain mode, new volumes and mounts are refused while the mounted volumes
+// keep being served. DELETE leaves drain mode, GET returns the current mode.
+func (d *minfsDriver) serveDrain(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		d.setDraining(true)
+	case "DELETE":
+		d.setDraining(false)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	d.RLock()
+	defer d.RUnlock()
+
+	mounted := 0
+	for _, v := range d.mounts {
+		if v.connections > 0 {
+			mounted++
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"draining": d.draining, "mounted": mounted})
+}
@@ -0,0 +1,163 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// valid names of driver aliases, used as the name of their socket.
+var aliasNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// driverAlias - A named volume driver served on its own socket (`/run/docker/plugins/<name>.sock`).
+// All the aliases share the mounts of the driver, each alias only sees the volumes created with it.
+// The default options of the alias (ex: endpoint and credentials) are applied to the volumes
+// created with it unless they're set in the request, see `--alias`.
+// The main driver is the alias with an empty name.
+type driverAlias struct {
+	name     string
+	defaults map[string]string
+	*minfsDriver
+}
+
+// parses an alias of the form `<name>:<option>=<value>,<option>=<value>`.
+// ex: minfs-prod:endpoint=https://minio.prod:9000,access-key-file=prod-access-key
+func parseDriverAlias(spec string) (*driverAlias, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	a := &driverAlias{name: strings.TrimSpace(parts[0]), defaults: make(map[string]string)}
+	if !aliasNameRegexp.MatchString(a.name) {
+		return nil, fmt.Errorf("invalid alias name %q", a.name)
+	}
+	if len(parts) == 1 || strings.TrimSpace(parts[1]) == "" {
+		return a, nil
+	}
+	defaults, err := parseOptionList(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%v of alias %s", err, a.name)
+	}
+	a.defaults = defaults
+	return a, nil
+}
+
+// parses a list of options of the form `<option>=<value>,<option>=<value>`.
+func parseOptionList(list string) (map[string]string, error) {
+	options := make(map[string]string)
+	for _, opt := range strings.Split(list, ",") {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid option %q, must be <option>=<value>", opt)
+		}
+		options[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return options, nil
+}
+
+// aliasFlags - values of the repeatable `--alias` flag.
+type aliasFlags []string
+
+func (f *aliasFlags) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *aliasFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// returns true if the volume doesn't exist or was created with the alias.
+func (a *driverAlias) owns(name string) bool {
+	a.RLock()
+	defer a.RUnlock()
+
+	v, ok := a.mounts[name]
+	return !ok || v.driver == a.name
+}
+
+// response to requests for the volumes of other aliases.
+func (a *driverAlias) notOwned(name string) volume.Response {
+	return errorResponse(errNotFound, fmt.Sprintf("volume %s not found", name))
+}
+
+// Create - creates the volume with the default options of the alias.
+func (a *driverAlias) Create(r volume.Request) volume.Response {
+	if !a.owns(r.Name) {
+		return errorResponse(errBadOption, fmt.Sprintf("volume %s already exists on another driver", r.Name))
+	}
+	if len(a.defaults) > 0 {
+		options := make(map[string]string, len(r.Options)+len(a.defaults))
+		for k, v := range a.defaults {
+			options[k] = v
+		}
+		for k, v := range r.Options {
+			options[k] = v
+		}
+		r.Options = options
+	}
+	return a.createVolume(r, a.name)
+}
+
+// List - lists the volumes created with the alias.
+func (a *driverAlias) List(r volume.Request) volume.Response {
+	res := a.minfsDriver.List(r)
+	var vols []*volume.Volume
+	for _, vol := range res.Volumes {
+		if a.owns(vol.Name) {
+			vols = append(vols, vol)
+		}
+	}
+	res.Volumes = vols
+	return res
+}
+
+func (a *driverAlias) Get(r volume.Request) volume.Response {
+	if !a.owns(r.Name) {
+		return a.notOwned(r.Name)
+	}
+	return a.minfsDriver.Get(r)
+}
+
+func (a *driverAlias) Remove(r volume.Request) volume.Response {
+	if !a.owns(r.Name) {
+		return a.notOwned(r.Name)
+	}
+	return a.minfsDriver.Remove(r)
+}
+
+func (a *driverAlias) Path(r volume.Request) volume.Response {
+	if !a.owns(r.Name) {
+		return a.notOwned(r.Name)
+	}
+	return a.minfsDriver.Path(r)
+}
+
+func (a *driverAlias) Mount(r volume.MountRequest) volume.Response {
+	if !a.owns(r.Name) {
+		return a.notOwned(r.Name)
+	}
+	return a.minfsDriver.Mount(r)
+}
+
+func (a *driverAlias) Unmount(r volume.UnmountRequest) volume.Response {
+	if !a.owns(r.Name) {
+		return a.notOwned(r.Name)
+	}
+	return a.minfsDriver.Unmount(r)
+}
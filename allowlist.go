@@ -0,0 +1,123 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// endpointAllowlist - Set of Minio server endpoints volumes are allowed to point to, set with `--allowed-endpoints`.
+// Entries are either CIDRs (ex: 10.0.0.0/8) matched against the addresses of the endpoint host,
+// or glob patterns (ex: *.minio.internal, minio.internal:9000, https://*.corp.com) matched against
+// the host, the host:port or the full URL of the endpoint.
+type endpointAllowlist struct {
+	patterns []string
+	networks []*net.IPNet
+}
+
+// parses the comma separated list of `--allowed-endpoints`, returns nil if the list is empty (all endpoints allowed).
+func parseEndpointAllowlist(list string) (*endpointAllowlist, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	a := &endpointAllowlist{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			a.networks = append(a.networks, network)
+			continue
+		}
+		// validate the glob pattern.
+		if _, err := path.Match(entry, ""); err != nil {
+			return nil, fmt.Errorf("invalid allowed endpoint %q: %v", entry, err)
+		}
+		a.patterns = append(a.patterns, strings.ToLower(entry))
+	}
+	return a, nil
+}
+
+// verifies that the endpoint is allowed, all endpoints are allowed by a nil allowlist.
+// A host overridden by the volume (`-o host-override`) is matched by its address rather than its name,
+// so that an override can't point an allowed name outside of the allowed endpoints.
+func (a *endpointAllowlist) verify(endpoint string, overrides map[string]string) error {
+	if a == nil {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	host := strings.ToLower(u.Host)
+	// the brackets of IPv6 hosts are dropped (ex: 2001:db8::1 for [2001:db8::1]:9000).
+	hostname := strings.ToLower(u.Hostname())
+	// match the glob patterns.
+	names := []string{hostname, host, strings.ToLower(strings.TrimSuffix(endpoint, "/"))}
+	if ip, ok := overrides[hostname]; ok {
+		names = []string{ip}
+	}
+	for _, pattern := range a.patterns {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok {
+				return nil
+			}
+		}
+	}
+	// match the networks, all the addresses of the host have to be in an allowed network
+	// so that an entry in DNS can't be used to reach a host outside of them.
+	// The host is resolved as the requests to the endpoint resolve it, see `resolveHost`.
+	if len(a.networks) > 0 {
+		addrs, err := resolveHost(context.Background(), hostname, overrides)
+		if err != nil {
+			return fmt.Errorf("unable to resolve endpoint host %s: %v", hostname, err)
+		}
+		var ips []net.IP
+		for _, addr := range addrs {
+			if ip := net.ParseIP(addr); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) > 0 && a.containsAll(ips) {
+			return nil
+		}
+	}
+	return fmt.Errorf("endpoint %s is not in the allowed endpoints of the plugin", endpoint)
+}
+
+// returns true if all the addresses are in one of the allowed networks.
+func (a *endpointAllowlist) containsAll(ips []net.IP) bool {
+	for _, ip := range ips {
+		allowed := false
+		for _, network := range a.networks {
+			if network.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// timeout of the calls to the authorization webhook.
+const authzWebhookTimeout = 5 * time.Second
+
+// option of the create request holding the shared token, see `--auth-token-file`.
+const authTokenOption = "auth-token"
+
+// authzRequest - operation submitted for authorization.
+type authzRequest struct {
+	// Create or Remove.
+	Operation string `json:"operation"`
+	Volume    string `json:"volume"`
+	// options of the create request, without the credentials and the token.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// authorizer - Decides whether a destructive or credential bearing request is honored.
+type authorizer interface {
+	authorize(r authzRequest, token string) error
+}
+
+// authorizers - all the authorizers have to allow the request.
+type authorizers []authorizer
+
+func (a authorizers) authorize(r authzRequest, token string) error {
+	for _, authz := range a {
+		if err := authz.authorize(r, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tokenAuthorizer - Requires volumes to be created with `-o auth-token=<token>` matching the shared token.
+// Remove requests carry no options in the volume plugin protocol and can't be checked against the token.
+type tokenAuthorizer struct {
+	token string
+}
+
+func (t tokenAuthorizer) authorize(r authzRequest, token string) error {
+	if r.Operation != "Create" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(t.token)) != 1 {
+		return fmt.Errorf("unauthorized: a valid %s option is required to create volume %s", authTokenOption, r.Volume)
+	}
+	return nil
+}
+
+// webhookAuthorizer - POSTs the request as JSON to an external authorization service.
+// A 2xx response allows the request, the body of any other response is returned as the reason of the denial.
+type webhookAuthorizer struct {
+	url    string
+	client *http.Client
+}
+
+// return a new webhookAuthorizer calling `url`.
+func newWebhookAuthorizer(url string) webhookAuthorizer {
+	return webhookAuthorizer{url: url, client: &http.Client{Timeout: authzWebhookTimeout}}
+}
+
+func (w webhookAuthorizer) authorize(r authzRequest, _ string) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unauthorized: authorization webhook failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	reason, _ := ioutil.ReadAll(resp.Body)
+	msg := strings.TrimSpace(string(reason))
+	if msg == "" {
+		msg = resp.Status
+	}
+	return fmt.Errorf("unauthorized: %s %s denied: %s", r.Operation, r.Volume, msg)
+}
+
+// returns the options of the request which can be shared with the authorization webhook.
+func authzOptions(options map[string]string) map[string]string {
+	shared := make(map[string]string, len(options))
+	for k, v := range options {
+		switch k {
+		case "access-key", "secret-key", authTokenOption:
+			continue
+		}
+		shared[k] = v
+	}
+	return shared
+}
+
+// authorizes the request, all the requests are allowed if no authorizer is configured.
+func (d *minfsDriver) authorize(operation, name string, options map[string]string) error {
+	if len(d.authz) == 0 {
+		return nil
+	}
+	r := authzRequest{Operation: operation, Volume: name, Options: authzOptions(options)}
+	return d.authz.authorize(r, options[authTokenOption])
+}
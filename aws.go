@@ -0,0 +1,248 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AWS services holding the credentials of the volumes.
+	awsSecretsManager = "secretsmanager"
+	awsSSM            = "ssm"
+	// instance metadata endpoint serving the credentials of the role of the EC2 instance.
+	awsMetadataCredentials = "http://169.254.169.254/latest/meta-data/iam/security-credentials/"
+)
+
+// awsCredentials - Credentials of the plugin signing the requests to AWS.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// awsProvider - Fetches the credentials of the volumes from AWS Secrets Manager (`-o secret-arn=<arn>`)
+// or the SSM Parameter Store (`-o ssm-param=<name>`). The secret string or the parameter value is a
+// JSON object holding the `access_key` and `secret_key` fields.
+// The plugin authenticates with the `AWS_ACCESS_KEY_ID`, `AWS_SECRET_ACCESS_KEY` and `AWS_SESSION_TOKEN`
+// environment variables, or the role of the EC2 instance if they're not set.
+type awsProvider struct {
+	service string
+	region  string
+	client  *http.Client
+
+	// credentials of the role of the instance, and their expiry.
+	sync.Mutex
+	roleCredentials *awsCredentials
+}
+
+// returns a new provider reading the secrets of the AWS service in the region,
+// the region can be overridden by the ARN of a secret.
+func newAWSProvider(service, region string) *awsProvider {
+	return &awsProvider{
+		service: service,
+		region:  region,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// returns the credentials of the plugin, from the environment or the instance metadata.
+func (p *awsProvider) credentials() (*awsCredentials, error) {
+	if ak := os.Getenv("AWS_ACCESS_KEY_ID"); ak != "" {
+		return &awsCredentials{
+			AccessKeyID:     ak,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Token:           os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	p.Lock()
+	defer p.Unlock()
+
+	// fetch the credentials of the role again a bit before they expire.
+	if p.roleCredentials != nil && time.Now().Add(5*time.Minute).Before(p.roleCredentials.Expiration) {
+		return p.roleCredentials, nil
+	}
+	role, err := p.metadata(awsMetadataCredentials)
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials in the environment and no instance role: %v", err)
+	}
+	data, err := p.metadata(awsMetadataCredentials + strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0]))
+	if err != nil {
+		return nil, err
+	}
+	var creds awsCredentials
+	if err = json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("invalid instance role credentials: %v", err)
+	}
+	p.roleCredentials = &creds
+	return p.roleCredentials, nil
+}
+
+// reads a path of the instance metadata.
+func (p *awsProvider) metadata(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("instance metadata responded %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// fetch - reads the credentials from the secret or the parameter `ref`.
+func (p *awsProvider) fetch(ref string) (string, string, time.Duration, error) {
+	region := p.region
+	// the region of the secret is part of its ARN (ex: arn:aws:secretsmanager:<region>:<account>:secret:<name>).
+	if parts := strings.Split(ref, ":"); len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	var target string
+	var body interface{}
+	switch p.service {
+	case awsSecretsManager:
+		target, body = "secretsmanager.GetSecretValue", map[string]string{"SecretId": ref}
+	case awsSSM:
+		target, body = "AmazonSSM.GetParameter", map[string]interface{}{"Name": ref, "WithDecryption": true}
+	}
+	if region == "" {
+		return "", "", 0, fmt.Errorf("the region of %s is unknown, set --aws-region", ref)
+	}
+
+	var res struct {
+		SecretString string
+		Parameter    struct {
+			Value string
+		}
+	}
+	if err := p.do(region, target, body, &res); err != nil {
+		return "", "", 0, err
+	}
+	value := res.SecretString
+	if p.service == awsSSM {
+		value = res.Parameter.Value
+	}
+	var creds struct {
+		AccessKey string `json:"access_key"`
+		SecretKey string `json:"secret_key"`
+	}
+	if err := json.Unmarshal([]byte(value), &creds); err != nil {
+		return "", "", 0, fmt.Errorf("%s is not a JSON object with access_key and secret_key", ref)
+	}
+	// the secrets have no lease, they're fetched again after `--credential-refresh-interval`.
+	return creds.AccessKey, creds.SecretKey, 0, nil
+}
+
+// sends a request to the JSON API of the service in the region.
+func (p *awsProvider) do(region, target string, body, res interface{}) error {
+	creds, err := p.credentials()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s.%s.amazonaws.com/", p.service, region), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+	signAWSRequest(req, data, p.service, region, creds, time.Now().UTC())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s responded %s: %s %s", p.service, resp.Status, e.Type, e.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// signs the request with AWS signature version 4 for the service, the vendored signer only signs
+// requests to S3.
+func signAWSRequest(req *http.Request, body []byte, service, region string, creds *awsCredentials, t time.Time) {
+	amzDate := t.Format("20060102T150405Z")
+	day := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("Host", req.URL.Host)
+
+	// the headers set above are the only ones signed.
+	var names []string
+	for _, name := range []string{"content-type", "host", "x-amz-date", "x-amz-security-token", "x-amz-target"} {
+		if req.Header.Get(name) != "" {
+			names = append(names, name)
+		}
+	}
+	var headers bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(req.Header.Get(name)))
+	}
+	signedHeaders := strings.Join(names, ";")
+	payload := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		req.Method,
+		"/",
+		"",
+		headers.String(),
+		signedHeaders,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{day, region, service, "aws4_request"}, "/")
+	hashed := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(hashed[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+// returns the HMAC-SHA256 of the data with the key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
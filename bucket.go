@@ -0,0 +1,286 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/minio/minio-go"
+)
+
+// Policies for volumes referring to a bucket which doesn't exist, set with `--on-missing-bucket`.
+const (
+	// reject the volume.
+	missingBucketFail = "fail"
+	// create the bucket on the remote Minio server.
+	missingBucketCreate = "create"
+	// accept the volume and mount an empty directory until the bucket is created.
+	missingBucketMountEmpty = "mount-empty"
+)
+
+// validates the value of `--on-missing-bucket`.
+func isValidMissingBucketPolicy(policy string) bool {
+	switch policy {
+	case missingBucketFail, missingBucketCreate, missingBucketMountEmpty:
+		return true
+	}
+	return false
+}
+
+// returns the bucket of a volume created without the bucket option, the name of the volume made a valid
+// bucket name: lowercase, the other characters than letters and digits replaced by hyphens, at most 63
+// characters long. The result is validated with the other bucket names.
+func bucketNameFromVolume(volume string) string {
+	var b []byte
+	for _, c := range []byte(strings.ToLower(volume)) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			b = append(b, c)
+		} else if len(b) > 0 && b[len(b)-1] != '-' {
+			b = append(b, '-')
+		}
+	}
+	name := string(b)
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// validates the bucket name against the S3 naming rules, with `strict` the rules of the buckets created
+// nowadays, otherwise the legacy rules allowing uppercase letters and underscores (`--legacy-bucket-names`).
+func validateBucketName(name string, strict bool) error {
+	maxLength := 63
+	if !strict {
+		maxLength = 255
+	}
+	if len(name) < 3 || len(name) > maxLength {
+		return fmt.Errorf("invalid bucket name %q, must be between 3 and %d characters long.", name, maxLength)
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '.' || c == '-':
+			if i == 0 || i == len(name)-1 {
+				return fmt.Errorf("invalid bucket name %q, must start and end with a lowercase letter or a digit.", name)
+			}
+		case !strict && (c >= 'A' && c <= 'Z' || c == '_'):
+		default:
+			allowed := "lowercase letters, digits, '.' and '-'"
+			if !strict {
+				allowed = "letters, digits, '.', '-' and '_'"
+			}
+			return fmt.Errorf("invalid bucket name %q, invalid character %q at position %d, only %s are allowed.", name, c, i, allowed)
+		}
+	}
+	if strict {
+		switch {
+		case strings.Contains(name, ".."):
+			return fmt.Errorf("invalid bucket name %q, must not contain two adjacent periods.", name)
+		case strings.Contains(name, ".-") || strings.Contains(name, "-."):
+			return fmt.Errorf("invalid bucket name %q, a period must not be next to a hyphen.", name)
+		case net.ParseIP(name) != nil:
+			return fmt.Errorf("invalid bucket name %q, must not be formatted as an IP address.", name)
+		case strings.HasPrefix(name, "xn--"):
+			return fmt.Errorf("invalid bucket name %q, must not start with the reserved prefix xn--.", name)
+		case strings.HasSuffix(name, "-s3alias"):
+			return fmt.Errorf("invalid bucket name %q, must not end with the reserved suffix -s3alias.", name)
+		}
+	}
+	return nil
+}
+
+// Initialize minio client object for the remote Minio server of the volume.
+func newMinioClient(config serverConfig) (*minio.Client, error) {
+	// find out whether the scheme of the URL is HTTPS.
+	enableSSL, err := isSSL(config.endpoint)
+	if err != nil {
+		logrus.Error("Please send a valid URL of form http(s)://my-minio.com:9000 <ERROR> ", err.Error())
+		return nil, err
+	}
+
+	minioHost, err := getHost(config.endpoint)
+	if err != nil {
+		logrus.Error("Please send a valid URL of form http(s)://my-minio.com:9000 <ERROR> ", err.Error())
+		return nil, err
+	}
+
+	var minioClient *minio.Client
+	switch config.signature {
+	case signatureV2:
+		minioClient, err = minio.NewV2(minioHost, config.accessKey, config.secretKey, enableSSL)
+	case signatureV4:
+		minioClient, err = minio.NewV4(minioHost, config.accessKey, config.secretKey, enableSSL)
+	default:
+		minioClient, err = minio.New(minioHost, config.accessKey, config.secretKey, enableSSL)
+	}
+	if err != nil {
+		logrus.Errorf("Error creating new Minio client. <Error> %s", err.Error())
+		return nil, err
+	}
+	// resolve the endpoint with the host overrides and the resolver of the plugin.
+	minioClient.SetCustomTransport(endpointTransport(config))
+	return minioClient, nil
+}
+
+// ensureBucket - Verifies that the bucket of the volume exists on the remote Minio server.
+// A missing bucket is handled as per the `--on-missing-bucket` policy of the driver,
+// returns false if the bucket doesn't exist and the policy is `mount-empty`.
+func (d *minfsDriver) ensureBucket(ctx context.Context, config serverConfig) (exists bool, err error) {
+	s := requestSpan(ctx).child("ensureBucket")
+	s.set("endpoint", config.endpoint)
+	s.set("bucket", config.bucket)
+	defer func() { s.finish(err) }()
+
+	fields := logrus.Fields{
+		"endpoint": config.endpoint,
+		"bucket":   config.bucket,
+		"region":   config.region,
+	}
+
+	exists, err = bucketExists(config)
+	if err != nil {
+		requestLog(ctx).WithFields(fields).Errorf("Unable to verify if the bucket exists. <ERROR> %v", err)
+		return false, err
+	}
+	if exists {
+		return true, nil
+	}
+
+	// the bucket can't be created without credentials.
+	if config.anonymous && d.onMissingBucket == missingBucketCreate {
+		return false, newCodedError(errNotFound, "bucket %s doesn't exist on %s and can't be created anonymously", config.bucket, config.endpoint)
+	}
+	switch d.onMissingBucket {
+	case missingBucketCreate:
+		// Create the bucket.
+		if err := makeBucket(config); err != nil {
+			requestLog(ctx).WithFields(fields).Errorf("Unable to create the bucket. <ERROR> %v", err)
+			return false, err
+		}
+		if err := setupNewBucket(config); err != nil {
+			requestLog(ctx).WithFields(fields).Errorf("Unable to set up the bucket. <ERROR> %v", err)
+			// the lifecycle and the policy are only set when the plugin creates the bucket.
+			if rErr := removeNewBucket(config); rErr != nil {
+				requestLog(ctx).WithFields(fields).Errorf("Unable to remove the bucket which couldn't be set up. <ERROR> %v", rErr)
+			}
+			return false, err
+		}
+		requestLog(ctx).WithFields(fields).Info("Bucket created.")
+		return true, nil
+	case missingBucketMountEmpty:
+		requestLog(ctx).WithFields(fields).Warn("Bucket doesn't exist, an empty directory will be mounted until it's created.")
+		return false, nil
+	}
+	return false, newCodedError(errNotFound, "bucket %s doesn't exist on %s", config.bucket, config.endpoint)
+}
+
+// checkBucket - Verifies that the bucket of the volume can be served, without side effects.
+// Used by `-o dry-run=true`, the endpoint has to be reachable, the credentials have to allow listing
+// the bucket and a missing bucket has to be acceptable as per the `--on-missing-bucket` policy.
+// The permission to create a missing bucket can't be verified without creating it.
+func (d *minfsDriver) checkBucket(ctx context.Context, config serverConfig) (err error) {
+	s := requestSpan(ctx).child("checkBucket")
+	s.set("endpoint", config.endpoint)
+	s.set("bucket", config.bucket)
+	defer func() { s.finish(err) }()
+
+	exists, err := bucketExists(config)
+	if err != nil {
+		return newCodedError(errorCodeOf(err), "unable to verify if bucket %s exists on %s: %v", config.bucket, config.endpoint, err)
+	}
+	if !exists {
+		switch {
+		case d.onMissingBucket == missingBucketFail:
+			return newCodedError(errNotFound, "bucket %s doesn't exist on %s", config.bucket, config.endpoint)
+		case d.onMissingBucket == missingBucketCreate && config.anonymous:
+			return newCodedError(errNotFound, "bucket %s doesn't exist on %s and can't be created anonymously", config.bucket, config.endpoint)
+		}
+		return nil
+	}
+	if err := listBucket(config); err != nil {
+		return newCodedError(errorCodeOf(err), "unable to list bucket %s: %v", config.bucket, err)
+	}
+	return nil
+}
+
+// returns true if the bucket of the volume exists, see `useS3Request`.
+func bucketExists(config serverConfig) (bool, error) {
+	if !useS3Request(config) {
+		minioClient, err := newMinioClient(config)
+		if err != nil {
+			return false, err
+		}
+		return minioClient.BucketExists(config.bucket)
+	}
+	resp, err := s3Request(config, "HEAD", "", nil, config.region, nil, nil)
+	if err != nil {
+		if e, ok := err.(s3Error); ok && e.statusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// creates the bucket of the volume in its region.
+func makeBucket(config serverConfig) error {
+	// object locking can only be enabled with the request creating the bucket.
+	if config.objectLocking || useS3Request(config) {
+		return s3MakeBucket(config)
+	}
+	minioClient, err := newMinioClient(config)
+	if err != nil {
+		return err
+	}
+	return minioClient.MakeBucket(config.bucket, config.region)
+}
+
+// sets the lifecycle and the policy of the volume on the bucket the plugin just created.
+func setupNewBucket(config serverConfig) error {
+	if err := setBucketLifecycle(config); err != nil {
+		return fmt.Errorf("setting the lifecycle of bucket %s failed: %v", config.bucket, err)
+	}
+	if err := setBucketPolicy(config); err != nil {
+		return fmt.Errorf("setting the policy of bucket %s failed: %v", config.bucket, err)
+	}
+	return nil
+}
+
+// verifies that the objects of the bucket of the volume can be listed.
+func listBucket(config serverConfig) error {
+	if useS3Request(config) {
+		_, err := s3Do(config, "GET", url.Values{"list-type": {"2"}, "max-keys": {"1"}}, config.region, nil, nil)
+		return err
+	}
+	minioClient, err := newMinioClient(config)
+	if err != nil {
+		return err
+	}
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for object := range minioClient.ListObjectsV2(config.bucket, "", false, doneCh) {
+		return object.Err
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+
+	"github.com/minio/minio-go/pkg/policy"
+)
+
+// Bucket policies - With `-o bucket-policy=<policy>`, the anonymous access policy of `mc policy` is set on the
+// bucket when the plugin creates it: `download` lets anyone read the objects, `upload` write them, `public`
+// both, and `private` (the default) keeps the bucket private. The policy of an existing bucket is left
+// untouched.
+
+// anonymous access policies of the buckets created by the plugin, set with `-o bucket-policy=<policy>`.
+const (
+	bucketPolicyPrivate  = "private"
+	bucketPolicyDownload = "download"
+	bucketPolicyUpload   = "upload"
+	bucketPolicyPublic   = "public"
+)
+
+// policies of minio-go of the bucket policies.
+var bucketPolicies = map[string]policy.BucketPolicy{
+	bucketPolicyPrivate:  policy.BucketPolicyNone,
+	bucketPolicyDownload: policy.BucketPolicyReadOnly,
+	bucketPolicyUpload:   policy.BucketPolicyWriteOnly,
+	bucketPolicyPublic:   policy.BucketPolicyReadWrite,
+}
+
+// version of the policy language of the bucket policies.
+const bucketPolicyVersion = "2012-10-17"
+
+// sets the anonymous access policy of the volume on its bucket, a new bucket is private already.
+func setBucketPolicy(config serverConfig) error {
+	if config.bucketPolicy == "" || config.bucketPolicy == bucketPolicyPrivate {
+		return nil
+	}
+	statements := policy.SetPolicy(nil, bucketPolicies[config.bucketPolicy], config.bucket, "")
+	body, err := json.Marshal(policy.BucketAccessPolicy{Version: bucketPolicyVersion, Statements: statements})
+	if err != nil {
+		return err
+	}
+	_, err = s3Do(config, "PUT", url.Values{"policy": {""}}, config.region, nil, body)
+	return err
+}
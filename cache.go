@@ -0,0 +1,321 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Caches - Every volume has a cache directory of its own under `<mountroot>/.cache/`, passed to minfs
+// and removed with the volume unless it's created with `-o retain-cache=true`. The retained caches are
+// kept by bucket under `<mountroot>/.cache/retained/`, so that a volume re-created for the same bucket,
+// whatever its name, starts with the warm cache of the removed one.
+// The cache can also be kept on a filesystem managed by the plugin, mounted at the cache directory
+// before minfs starts and destroyed once it's stopped:
+//   - With `-o encrypt-cache=true` the cache is kept on an ephemeral dm-crypt device rather than in
+//     plaintext on the disk of the host. The device is a plain dm-crypt mapping of a sparse loop file,
+//     keyed with random bytes which are never stored: the cached objects can't be read once the device
+//     is closed on unmount, even if the loop file was left behind. This requires `losetup`, `cryptsetup`
+//     and `mkfs.ext4` on the host.
+//   - With `-o cache=tmpfs[,size=<size>]` the cache is kept on a tmpfs, in memory, for RAM speed reads
+//     of small hot datasets without the cached objects ever hitting the disk.
+
+// directory under the mount root holding the caches, bind mounted in the minfs containers
+// with the mount root.
+const cacheDir = ".cache"
+
+// directory under the cache directory holding the caches retained by bucket.
+const retainedCacheDir = "retained"
+
+// Types of the managed caches, set with `-o cache=<type>`.
+const (
+	cacheTmpfs = "tmpfs"
+)
+
+// volumeCache - A managed cache mounted for a volume.
+type volumeCache struct {
+	// directory the cache is mounted at, passed to minfs.
+	dir string
+	// loop device backing an encrypted cache.
+	loop string
+	// name of the dm-crypt mapping of an encrypted cache.
+	mapper string
+}
+
+// parses the cache option of the form `tmpfs[,size=<size>]`, returns the type and size of the cache.
+func parseCacheOption(option string) (string, string, error) {
+	if option == "" {
+		return "", "", nil
+	}
+	parts := strings.Split(option, ",")
+	if parts[0] != cacheTmpfs {
+		return "", "", fmt.Errorf("invalid value %q for cache option, must be tmpfs[,size=<size>].", option)
+	}
+	var size string
+	for _, part := range parts[1:] {
+		if !strings.HasPrefix(part, "size=") {
+			return "", "", fmt.Errorf("invalid value %q for cache option, must be tmpfs[,size=<size>].", option)
+		}
+		size = strings.TrimPrefix(part, "size=")
+		if _, err := parseMemoryLimit(size); err != nil {
+			return "", "", fmt.Errorf("invalid cache size %q, must be a size in bytes with an optional K, M or G suffix (ex: 2G).", size)
+		}
+	}
+	return parts[0], size, nil
+}
+
+// runs the command, returning its output in the error if it fails.
+func runCacheCommand(ctx context.Context, name string, args ...string) (string, error) {
+	defer observeExec(name, time.Now())
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s failed: %v %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// returns the cache directory of the volume, the cache of its bucket if it's retained and not used by
+// another volume, minfs can't share its cache.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) cachePath(v *mountInfo) string {
+	root := filepath.Join(d.volumeMountRoot(v.config), cacheDir)
+	if v.config.retainCache {
+		dir := filepath.Join(root, retainedCacheDir, retainedCacheKey(v.config))
+		if !d.cacheInUse(dir) {
+			return dir
+		}
+	}
+	return filepath.Join(root, v.name)
+}
+
+// returns the key of the retained cache of the bucket of the volume. A retained cache is only used again by
+// the volumes accessing the bucket as the same principal, so that the objects cached for a volume can't be
+// read through a volume whose credentials don't allow it.
+func retainedCacheKey(config serverConfig) string {
+	endpoint := config.endpoint
+	if len(config.endpoints) > 0 {
+		endpoint = strings.Join(config.endpoints, ",")
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", endpoint, config.bucket, cachePrincipal(config))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// returns who the bucket is accessed as, the reference of the secret for the credentials fetched from a
+// provider since the keys rotate, the access key otherwise.
+func cachePrincipal(config serverConfig) string {
+	switch {
+	case config.anonymous:
+		return "anonymous"
+	case config.credentialProvider != "":
+		return "provider:" + config.credentialProvider + ":" + config.credentialRef
+	}
+	return "access-key:" + config.accessKey
+}
+
+// returns true if the cache directory is the cache of a volume.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) cacheInUse(dir string) bool {
+	for _, v := range d.mounts {
+		if v.cacheDir == dir {
+			return true
+		}
+	}
+	for _, s := range d.shared {
+		if s.cacheDir == dir {
+			return true
+		}
+	}
+	for _, v := range d.mounts {
+		for _, m := range v.union {
+			if m.cacheDir == dir {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sets up the cache directory of the volume, and its managed cache if it has one and it's not set up
+// yet, the managed cache is kept when minfs is restarted after a crash.
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while
+// the managed cache is set up.
+func (d *minfsDriver) setupCache(v *mountInfo) error {
+	if v.cache != nil {
+		return nil
+	}
+	if !v.config.encryptCache && v.config.cacheType == "" {
+		if err := os.MkdirAll(v.cacheDir, 0700); err != nil {
+			return err
+		}
+		return d.runAs.chown(v.cacheDir)
+	}
+	var c *volumeCache
+	err := d.unlocked(func(ctx context.Context) (err error) {
+		c, err = d.newCache(ctx, v)
+		return err
+	})
+	if err == nil {
+		v.cache = c
+	}
+	return err
+}
+
+// sets up the managed cache of the volume at its cache directory.
+func (d *minfsDriver) newCache(ctx context.Context, v *mountInfo) (c *volumeCache, err error) {
+	c = &volumeCache{
+		dir: v.cacheDir,
+	}
+	// undo the steps done so far on failure.
+	defer func() {
+		if err != nil {
+			c.teardown()
+		}
+	}()
+	if err = os.MkdirAll(c.dir, 0700); err != nil {
+		return nil, err
+	}
+	if v.config.cacheType == cacheTmpfs {
+		opts := "mode=0700"
+		if v.config.cacheSize != "" {
+			size, _ := parseMemoryLimit(v.config.cacheSize)
+			opts = fmt.Sprintf("%s,size=%d", opts, size)
+		}
+		if _, err = runCacheCommand(ctx, "mount", "-t", "tmpfs", "-o", opts, "tmpfs", c.dir); err != nil {
+			return nil, err
+		}
+	} else if err = d.mountEncryptedCache(ctx, c, v.name); err != nil {
+		return nil, err
+	}
+	os.Chmod(c.dir, 0700)
+	// minfs has to be able to write its cache when it doesn't run as root.
+	if err = d.runAs.chown(c.dir); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// mounts an encrypted device at the directory of the cache.
+func (d *minfsDriver) mountEncryptedCache(ctx context.Context, c *volumeCache, name string) (err error) {
+	// the loop file is sparse, and removed once attached so that it's freed when the device is detached.
+	image := c.dir + ".img"
+	f, err := os.OpenFile(image, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(d.encryptedCacheSize)
+	f.Close()
+	if err != nil {
+		os.Remove(image)
+		return err
+	}
+	c.loop, err = runCacheCommand(ctx, "losetup", "--find", "--show", image)
+	os.Remove(image)
+	if err != nil {
+		return err
+	}
+	// the key is read from /dev/urandom and only known to the kernel.
+	mapper := "minfs-cache-" + name
+	if _, err = runCacheCommand(ctx, "cryptsetup", "open", "--type", "plain", "--cipher", "aes-xts-plain64",
+		"--key-size", "512", "--key-file", "/dev/urandom", "--keyfile-size", "64", c.loop, mapper); err != nil {
+		return err
+	}
+	c.mapper = mapper
+	device := "/dev/mapper/" + c.mapper
+	if _, err = runCacheCommand(ctx, "mkfs.ext4", "-q", "-m", "0", device); err != nil {
+		return err
+	}
+	_, err = runCacheCommand(ctx, "mount", device, c.dir)
+	return err
+}
+
+// destroys the managed cache of the volume, once minfs is stopped.
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while
+// the cache is destroyed.
+func (d *minfsDriver) teardownCache(v *mountInfo) {
+	c := v.cache
+	if c == nil {
+		return
+	}
+	v.cache = nil
+	d.unlocked(func(ctx context.Context) error {
+		if err := c.teardown(); err != nil {
+			requestLog(ctx).WithField("volume", v.name).Errorf("Destroying the cache failed. <ERROR> %v", err)
+		}
+		return nil
+	})
+}
+
+// removes the cache directory of the removed volume unless it's retained, logging the space freed.
+func removeCacheDir(v *mountInfo) {
+	if v.config.retainCache || v.cacheDir == "" {
+		return
+	}
+	var size int64
+	filepath.Walk(v.cacheDir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err := os.RemoveAll(v.cacheDir); err != nil {
+		logrus.WithField("volume", v.name).Errorf("Removing the cache directory failed. <ERROR> %v", err)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"volume": v.name,
+		"cache":  v.cacheDir,
+		"bytes":  size,
+	}).Info("Cache directory removed.")
+}
+
+// unmounts the cache, closes its encrypted device, and removes its directory.
+func (c *volumeCache) teardown() error {
+	// the teardown isn't cancelled with the request, the devices would be left behind.
+	ctx := context.Background()
+	var errs []string
+	// the directory isn't mounted if the setup failed before the mount.
+	if _, err := runCacheCommand(ctx, "umount", c.dir); err != nil {
+		// the mount is detached rather than left behind, the device is closed below.
+		lazyUnmount(c.dir)
+	}
+	if c.mapper != "" {
+		if _, err := runCacheCommand(ctx, "cryptsetup", "close", c.mapper); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if c.loop != "" {
+		if _, err := runCacheCommand(ctx, "losetup", "--detach", c.loop); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	os.Remove(c.dir)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
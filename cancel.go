@@ -0,0 +1,76 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Cancellation - The commands run while serving a request (mount, umount, cryptsetup...) are bound to
+// the context of the request, cancelled once the request exceeds `--request-timeout` (Docker gives up on
+// Create and Mount after 2 minutes) or when the plugin is stopped with SIGTERM, so that they are killed
+// rather than left running after Docker stopped waiting. minfs itself outlives the requests and is not
+// bound to them. The commands run outside of a request are only cancelled when the plugin is stopped.
+
+// default of `--request-timeout`, the time Docker waits for Create and Mount.
+const defaultRequestTimeout = 2 * time.Minute
+
+// time given to the requests being served to finish once the plugin is stopped.
+const stopTimeout = 5 * time.Second
+
+// interval at which `stop` checks whether the requests finished.
+const stopPollInterval = 50 * time.Millisecond
+
+// returns the context of the request holding the lock, the context of the plugin if there's none.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) context() context.Context {
+	if d.req == nil || d.req.ctx == nil {
+		return d.stopCtx
+	}
+	return d.req.ctx
+}
+
+// returns the context of a new request, cancelled after `--request-timeout` or when the plugin is stopped.
+func (d *minfsDriver) requestContext() (context.Context, context.CancelFunc) {
+	if d.requestTimeout <= 0 {
+		return context.WithCancel(d.stopCtx)
+	}
+	return context.WithTimeout(d.stopCtx, d.requestTimeout)
+}
+
+// cancels the commands of the requests being served and waits for the requests to finish, up to `stopTimeout`.
+// Must not be called with the driver lock held.
+func (d *minfsDriver) stop() {
+	d.cancelStop()
+	deadline := time.Now().Add(stopTimeout)
+	for {
+		d.RLock()
+		requests := d.requests
+		d.RUnlock()
+		if requests == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			logrus.Warnf("%d requests being served didn't finish within %s.", requests, stopTimeout)
+			return
+		}
+		time.Sleep(stopPollInterval)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Resource limits - With `--minfs-memory-limit` and `--minfs-cpu-quota`, or the `memory-limit` and
+// `cpu-quota` options of a volume, every minfs process is placed in a cgroup of its own bounding its
+// memory and CPU, so that a runaway mount doesn't starve the containers of the host.
+// The cgroups are created under `/sys/fs/cgroup/minfs/` (cgroup v2), minfs running in a helper
+// container gets the limits of the container instead.
+
+const (
+	// mount point of the cgroup v2 hierarchy.
+	cgroupRoot = "/sys/fs/cgroup"
+	// cgroup holding the cgroups of the minfs processes.
+	cgroupParent = "minfs"
+	// period of the CPU quota, in microseconds.
+	cpuPeriod = 100000
+)
+
+// parses a memory limit in bytes with an optional K, M or G suffix (ex: 512M).
+func parseMemoryLimit(limit string) (int64, error) {
+	multiplier := int64(1)
+	number := strings.ToUpper(limit)
+	switch {
+	case strings.HasSuffix(number, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(number, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(number, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		number = number[:len(number)-1]
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid memory limit %q, must be a size in bytes with an optional K, M or G suffix (ex: 512M).", limit)
+	}
+	return n * multiplier, nil
+}
+
+// parses a CPU quota in number of CPUs (ex: 0.5).
+func parseCPUQuota(quota string) (float64, error) {
+	cpus, err := strconv.ParseFloat(quota, 64)
+	if err != nil || cpus <= 0 {
+		return 0, fmt.Errorf("invalid CPU quota %q, must be a number of CPUs (ex: 0.5).", quota)
+	}
+	return cpus, nil
+}
+
+// validates the memory and CPU limits, empty limits are not set.
+func validateResourceLimits(memory, cpu string) error {
+	if memory != "" {
+		if _, err := parseMemoryLimit(memory); err != nil {
+			return err
+		}
+	}
+	if cpu != "" {
+		if _, err := parseCPUQuota(cpu); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the memory limit in bytes and the CPU quota of the minfs process of the volume, the options
+// of the volume take precedence over the flags of the plugin. Zero values are unlimited.
+func (d *minfsDriver) resourceLimits(v *mountInfo) (int64, float64) {
+	memory, cpu := d.minfsMemoryLimit, d.minfsCPUQuota
+	if v.config.memoryLimit != "" {
+		memory = v.config.memoryLimit
+	}
+	if v.config.cpuQuota != "" {
+		cpu = v.config.cpuQuota
+	}
+	// the limits are validated by the create request and at startup.
+	var memoryBytes int64
+	var cpus float64
+	if memory != "" {
+		memoryBytes, _ = parseMemoryLimit(memory)
+	}
+	if cpu != "" {
+		cpus, _ = parseCPUQuota(cpu)
+	}
+	return memoryBytes, cpus
+}
+
+// places the process in a new cgroup limiting its memory and CPU, returns the path of the cgroup,
+// empty if the volume has no limits.
+func (d *minfsDriver) limitResources(v *mountInfo, pid int) (string, error) {
+	memory, cpus := d.resourceLimits(v)
+	if memory == 0 && cpus == 0 {
+		return "", nil
+	}
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("resource limits require cgroup v2 mounted at %s", cgroupRoot)
+	}
+	parent := filepath.Join(cgroupRoot, cgroupParent)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	// the controllers have to be enabled for the children of the root and of the parent.
+	for _, dir := range []string{cgroupRoot, parent} {
+		if err := writeCgroupFile(dir, "cgroup.subtree_control", "+memory +cpu"); err != nil {
+			return "", err
+		}
+	}
+	cgroup := filepath.Join(parent, fmt.Sprintf("%s-%d", v.name, pid))
+	if err := os.Mkdir(cgroup, 0755); err != nil {
+		return "", err
+	}
+	if memory > 0 {
+		if err := writeCgroupFile(cgroup, "memory.max", strconv.FormatInt(memory, 10)); err != nil {
+			removeCgroup(cgroup)
+			return "", err
+		}
+	}
+	if cpus > 0 {
+		if err := writeCgroupFile(cgroup, "cpu.max", fmt.Sprintf("%d %d", int64(cpus*cpuPeriod), cpuPeriod)); err != nil {
+			removeCgroup(cgroup)
+			return "", err
+		}
+	}
+	if err := writeCgroupFile(cgroup, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		removeCgroup(cgroup)
+		return "", err
+	}
+	return cgroup, nil
+}
+
+// writes the value to a control file of the cgroup.
+func writeCgroupFile(cgroup, file, value string) error {
+	if err := ioutil.WriteFile(filepath.Join(cgroup, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("setting %s of cgroup %s failed: %v", file, cgroup, err)
+	}
+	return nil
+}
+
+// removes the cgroup of a minfs process once it exited.
+func removeCgroup(cgroup string) {
+	if cgroup != "" {
+		os.Remove(cgroup)
+	}
+}
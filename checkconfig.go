@@ -0,0 +1,273 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+)
+
+// Configuration check - With `--check-config`, the plugin validates its flags and exits rather than serving
+// Docker, so that a deployment pipeline can check the configuration before the plugin is (re)started: the
+// mount root is writable and not a FUSE mount, minfs (or its image) and /dev/fuse are available, the
+// endpoints of the default options and of the driver aliases are reachable with their credentials, and the
+// volumes of the state file can be restored and their buckets used. The report is printed on the standard
+// output, the exit code is 1 if a check failed. The check doesn't take the lock of the running instance and
+// changes nothing: the mount root isn't created and the volumes aren't mounted.
+
+// configCheck - Result of a check of `--check-config`.
+type configCheck struct {
+	name string
+	// what was verified, printed when the check passed.
+	detail string
+	err    error
+	// the plugin can start despite the error, only some volumes are affected.
+	warning bool
+}
+
+// runs the checks of the configuration, prints the report to `w` and returns the exit code.
+func (d *minfsDriver) runConfigCheck(w io.Writer, aliases []*driverAlias) int {
+	checks := d.checkConfig(aliases)
+	failed, warnings := 0, 0
+	for _, c := range checks {
+		switch {
+		case c.err == nil:
+			fmt.Fprintf(w, "OK    %s: %s\n", c.name, c.detail)
+		case c.warning:
+			warnings++
+			fmt.Fprintf(w, "WARN  %s: %v\n", c.name, c.err)
+		default:
+			failed++
+			fmt.Fprintf(w, "FAIL  %s: %v\n", c.name, c.err)
+		}
+	}
+	fmt.Fprintf(w, "%d checks, %d failed, %d warnings.\n", len(checks), failed, warnings)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// returns the results of the checks of the configuration.
+func (d *minfsDriver) checkConfig(aliases []*driverAlias) []configCheck {
+	checks := []configCheck{d.checkMountRoot()}
+	checks = append(checks, d.checkMinfs()...)
+	checks = append(checks, checkBinary("mergerfs binary", d.mergerfsBinary, "required by the union volumes (-o buckets)"))
+	if d.minioAdmin != nil {
+		checks = append(checks, checkBinary("mc binary", d.mcBinary, "required by the service accounts of the volumes"))
+	}
+	if d.defaultOptions["endpoint"] != "" {
+		c := configCheck{name: "default options"}
+		c.detail, c.err = d.checkEndpointOptions(d.defaultOptions)
+		checks = append(checks, c)
+	}
+	for _, a := range aliases {
+		// the options of the alias take precedence over the default options.
+		options := d.withDefaultOptions(a.defaults)
+		if options["endpoint"] == "" {
+			continue
+		}
+		c := configCheck{name: "alias " + a.name}
+		c.detail, c.err = d.checkEndpointOptions(options)
+		checks = append(checks, c)
+	}
+	if d.stateFile != "" {
+		checks = append(checks, d.checkStateVolumes()...)
+	}
+	return checks
+}
+
+// checks that the mount root is a writable directory, not mounted by FUSE.
+func (d *minfsDriver) checkMountRoot() configCheck {
+	c := configCheck{name: "mount root " + d.mountRoot}
+	fi, err := os.Stat(d.mountRoot)
+	if os.IsNotExist(err) {
+		c.detail = "doesn't exist, it's created at startup"
+		return c
+	}
+	if err != nil {
+		c.err = err
+		return c
+	}
+	if !fi.IsDir() {
+		c.err = fmt.Errorf("not a directory")
+		return c
+	}
+	if isFuseMounted(d.mountRoot) {
+		c.err = fmt.Errorf("is a FUSE mount, the volumes can't be mounted under it")
+		return c
+	}
+	f, err := ioutil.TempFile(d.mountRoot, ".minfs-check-")
+	if err != nil {
+		c.err = fmt.Errorf("not writable: %v", err)
+		return c
+	}
+	f.Close()
+	os.Remove(f.Name())
+	c.detail = "writable directory"
+	return c
+}
+
+// checks that minfs can be run, on the host or in helper containers.
+func (d *minfsDriver) checkMinfs() []configCheck {
+	if d.docker != nil {
+		c := configCheck{name: "minfs image " + d.minfsImage}
+		if err := d.docker.do("GET", "/images/"+d.minfsImage+"/json", nil, nil, nil); err != nil {
+			c.err = fmt.Errorf("not available on the Docker daemon: %v", err)
+		} else {
+			c.detail = "available on the Docker daemon"
+		}
+		return []configCheck{c}
+	}
+	fuse := configCheck{name: "/dev/fuse", detail: "available"}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		fuse.err = err
+	}
+	return []configCheck{checkBinary("minfs binary", d.minfsBinary, ""), fuse}
+}
+
+// checks that the binary can be run, a missing binary is a warning if `usage` is set.
+func checkBinary(name, binary, usage string) configCheck {
+	c := configCheck{name: name}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		c.err = err
+		if usage != "" {
+			c.err, c.warning = fmt.Errorf("%v, %s", err, usage), true
+		}
+		return c
+	}
+	c.detail = path
+	return c
+}
+
+// checks the endpoints of the options of an alias or of the default options with their credentials.
+func (d *minfsDriver) checkEndpointOptions(defaults map[string]string) (string, error) {
+	options := make(map[string]string, len(defaults))
+	for k, v := range defaults {
+		options[k] = v
+	}
+	if err := validateOptions(options); err != nil {
+		return "", err
+	}
+	if err := d.credentialFileOptions(options); err != nil {
+		return "", err
+	}
+	if _, _, _, err := d.credentialProviderOptions(options); err != nil {
+		return "", err
+	}
+	anonymous, err := parseBoolOption(options, "anonymous")
+	if err != nil {
+		return "", err
+	}
+	config := serverConfig{
+		bucket:    options["bucket"],
+		accessKey: options["access-key"],
+		secretKey: options["secret-key"],
+		region:    options["region"],
+		signature: d.signature,
+		anonymous: anonymous,
+		proxy:     options["proxy"],
+		noProxy:   options["no-proxy"],
+	}
+	if config.region == "" {
+		config.region = defaultLocation
+	}
+	if signature, ok := options["signature"]; ok {
+		config.signature = signature
+	}
+	// the volumes created without credentials are given a service account, see `addServiceAccount`.
+	credentials := "credentials"
+	if !anonymous && config.accessKey == "" && d.minioAdmin != nil {
+		config.accessKey, config.secretKey = d.minioAdmin.accessKey, d.minioAdmin.secretKey
+		credentials = "MinIO admin credentials"
+	}
+	endpoints := splitEndpoints(options["endpoint"])
+	for _, endpoint := range endpoints {
+		if err := d.allowedEndpoints.verify(endpoint, nil); err != nil {
+			return "", err
+		}
+		config.endpoint = endpoint
+		switch {
+		case config.bucket != "":
+			err = d.checkBucket(d.stopCtx, config)
+		case anonymous || config.accessKey == "":
+			if !endpointReachable(config, endpoint) {
+				err = newCodedError(errEndpointUnreachable, "endpoint %s is unreachable", endpoint)
+			}
+		default:
+			err = listBuckets(config)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	switch {
+	case config.bucket != "":
+		return fmt.Sprintf("bucket %s usable on %d endpoint(s)", config.bucket, len(endpoints)), nil
+	case anonymous || config.accessKey == "":
+		return fmt.Sprintf("%d endpoint(s) reachable", len(endpoints)), nil
+	}
+	return fmt.Sprintf("%d endpoint(s) reachable, %s accepted", len(endpoints), credentials), nil
+}
+
+// lists the buckets of the endpoint, to verify the credentials when no bucket is set.
+func listBuckets(config serverConfig) error {
+	client, err := newMinioClient(config)
+	if err != nil {
+		return err
+	}
+	if _, err := client.ListBuckets(); err != nil {
+		return fmt.Errorf("unable to list the buckets of %s: %v", config.endpoint, err)
+	}
+	return nil
+}
+
+// checks that the volumes of the state file can be restored and that their buckets can be used.
+func (d *minfsDriver) checkStateVolumes() []configCheck {
+	c := configCheck{name: "state file " + d.stateFile}
+	if _, err := os.Stat(d.stateFile); os.IsNotExist(err) {
+		c.detail = "doesn't exist, no volumes to restore"
+		return []configCheck{c}
+	}
+	// the volumes are only validated, the check registers nothing.
+	mounts, err := d.validateStateFile(d.stateFile)
+	if err != nil {
+		c.err = err
+		return []configCheck{c}
+	}
+	c.detail = fmt.Sprintf("%d volumes to restore", len(mounts))
+	checks := []configCheck{c}
+
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].name < mounts[j].name })
+	for _, v := range mounts {
+		// the volumes of missing buckets are still served, the plugin starts without them.
+		c := configCheck{name: "volume " + v.name, warning: true, detail: fmt.Sprintf("bucket %s usable on %s", v.config.bucket, v.config.endpoint)}
+		if len(v.config.union) > 0 {
+			c.detail = fmt.Sprintf("%d buckets usable on %s", len(v.config.union), v.config.endpoint)
+			c.err = d.ensureUnionBuckets(d.stopCtx, v.config, true)
+		} else {
+			c.err = d.checkBucket(d.stopCtx, v.config)
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
@@ -0,0 +1,144 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// State persistence - With `--state-file`, the definitions of the volumes are kept in a state bundle
+// (see `exportState`) which is imported again when the plugin starts, so that the volumes survive a
+// restart of the plugin. The handlers only mark the state dirty, the file is written in the background
+// every `--checkpoint-interval` when the state changed, right away after a volume is removed, and on
+// shutdown, so that high request rates don't wait on fsync.
+
+// serializes the writes of the state file.
+var checkpointLock sync.Mutex
+
+// marks the state to be written by the next checkpoint.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) markDirty() {
+	if d.stateFile != "" {
+		d.stateDirty = true
+	}
+}
+
+// marks the state dirty and asks for it to be written without waiting for the next interval,
+// used after destructive requests.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) flushState() {
+	if d.stateFile == "" {
+		return
+	}
+	d.stateDirty = true
+	select {
+	case d.stateFlush <- struct{}{}:
+	default:
+	}
+}
+
+// writes the state every interval if it's dirty, or as soon as a flush is asked for.
+func (d *minfsDriver) checkpointLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.stateFlush:
+		}
+		d.checkpoint()
+	}
+}
+
+// writes the state file if the state is dirty.
+func (d *minfsDriver) checkpoint() {
+	checkpointLock.Lock()
+	defer checkpointLock.Unlock()
+
+	d.Lock()
+	if !d.stateDirty {
+		d.Unlock()
+		return
+	}
+	bundle, err := d.exportState(true)
+	d.stateDirty = false
+	d.Unlock()
+
+	if err == nil {
+		err = d.storeBundleCredentials(bundle)
+	}
+	if err == nil {
+		err = writeStateFile(d.stateFile, bundle)
+	}
+	if err != nil {
+		logrus.Errorf("Writing the state file failed. <ERROR> %v", err)
+		// retried on the next checkpoint.
+		d.Lock()
+		d.stateDirty = true
+		d.Unlock()
+		return
+	}
+	d.pruneStoredCredentials(bundle)
+}
+
+// writes the bundle to the file, the file is replaced atomically once the bundle is synced to the disk.
+func writeStateFile(path string, bundle stateBundle) error {
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// imports the volumes of the state file written by the previous run of the plugin, if there's one.
+func (d *minfsDriver) restoreState() error {
+	if _, err := os.Stat(d.stateFile); os.IsNotExist(err) {
+		return nil
+	}
+	res, err := d.importStateFile(d.stateFile)
+	if err != nil {
+		return err
+	}
+	logrus.WithField("volumes", len(res.Imported)).Info("State restored.")
+	return nil
+}
@@ -0,0 +1,177 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/minio/minio-go"
+)
+
+// cloneSource - volume a new volume is cloned from (`-o clone-from=<volume>`).
+type cloneSource struct {
+	// name of the source volume.
+	volume string
+	// server config of the source volume at the time of the clone.
+	config serverConfig
+	// number of objects copied so far, reported in the status of the volume.
+	copied int
+	// error of the copy once it failed.
+	err error
+	// closed once the copy completes.
+	done chan struct{}
+	// closed to stop the copy, when the volume is removed.
+	stop chan struct{}
+}
+
+// Cloning - The bucket of a volume created with `-o clone-from` is copied from the bucket of the source volume
+// in the background from the creation of the volume, its first mount waits for the copy to complete.
+// The number of objects copied is reported in the status of the volume until then. A failed copy is
+// reported to the mount waiting for it, and started again for the next mount. The clones in progress are
+// kept in the state file, and copied again from the start when the volumes are restored.
+
+// starts copying the bucket of the source volume into the bucket of the cloned volume.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) startClone(v *mountInfo) {
+	c := v.clone
+	c.done, c.stop = make(chan struct{}), make(chan struct{})
+	src, dst := c.config, v.config
+	go func() {
+		_, err := cloneBucket(src, dst, c.stop, func(copied int) {
+			d.Lock()
+			c.copied = copied
+			d.Unlock()
+		})
+		d.Lock()
+		defer d.Unlock()
+		c.err = err
+		if err == nil && v.clone == c {
+			v.clone = nil
+			// the volume is no longer restored as a clone in progress.
+			d.markDirty()
+		}
+		close(c.done)
+	}()
+}
+
+// waits for the copy of the bucket of the cloned volume to complete. A failed copy is started again.
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while waiting.
+func (d *minfsDriver) waitClone(v *mountInfo) error {
+	c := v.clone
+	err := d.unlocked(func(ctx context.Context) error {
+		select {
+		case <-c.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if c.err != nil {
+		v.clone = &cloneSource{volume: c.volume, config: c.config}
+		d.startClone(v)
+		return newCodedError(errorCodeOf(c.err), "cloning volume %s failed: %v", c.volume, c.err)
+	}
+	return nil
+}
+
+// stops copying the bucket, the objects already copied are left in the bucket.
+// Has to be called with the driver lock held.
+func (c *cloneSource) cancel() {
+	// the removal of the volume may be retried.
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+}
+
+// returns the options of a volume cloned from the volume with the given config.
+// The clone is made server side, the new volume has to be on the same server and
+// inherits the endpoint and the credentials of the source volume.
+func cloneOptions(src serverConfig, options map[string]string) (map[string]string, error) {
+	if src.anonymous {
+		return nil, fmt.Errorf("anonymous volumes cannot be cloned")
+	}
+	if src.addressing == addressingVirtualHost || options["addressing"] == addressingVirtualHost {
+		return nil, fmt.Errorf("volumes using virtual-host addressing cannot be cloned")
+	}
+	endpoint := src.endpoint
+	if len(src.endpoints) > 0 {
+		endpoint = strings.Join(src.endpoints, ",")
+	}
+	if options["endpoint"] != "" && options["endpoint"] != endpoint {
+		return nil, fmt.Errorf("cloned volumes have to be on the endpoint of the source volume %s", endpoint)
+	}
+	if options["bucket"] == src.bucket {
+		return nil, fmt.Errorf("cloned volumes cannot use the bucket %s of the source volume", src.bucket)
+	}
+	cloned := make(map[string]string, len(options))
+	for k, v := range options {
+		cloned[k] = v
+	}
+	cloned["endpoint"] = endpoint
+	// the service account of the source volume is limited to its bucket, the clone gets a service account of
+	// its own.
+	if cloned["access-key"] == "" && cloned["secret-key"] == "" && !src.serviceAccount {
+		cloned["access-key"] = src.accessKey
+		cloned["secret-key"] = src.secretKey
+	}
+	return cloned, nil
+}
+
+// copies all the objects of the source bucket into the bucket of the volume, server side, until `stop` is closed.
+// `progress` is called with the number of objects copied after each object. Returns the number of copied objects.
+func cloneBucket(src, dst serverConfig, stop <-chan struct{}, progress func(copied int)) (int, error) {
+	minioClient, err := newMinioClient(dst)
+	if err != nil {
+		return 0, err
+	}
+	fields := logrus.Fields{
+		"endpoint": dst.endpoint,
+		"source":   src.bucket,
+		"bucket":   dst.bucket,
+	}
+	logrus.WithFields(fields).Info("Cloning bucket.")
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	copied := 0
+	for object := range minioClient.ListObjectsV2(src.bucket, "", true, doneCh) {
+		if object.Err != nil {
+			return copied, object.Err
+		}
+		select {
+		case <-stop:
+			return copied, fmt.Errorf("cancelled after %d objects", copied)
+		default:
+		}
+		if err := minioClient.CopyObject(dst.bucket, object.Key, src.bucket+"/"+object.Key, minio.NewCopyConditions()); err != nil {
+			return copied, newCodedError(errorCodeOf(err), "copying %s/%s failed: %v", src.bucket, object.Key, err)
+		}
+		copied++
+		progress(copied)
+	}
+	logrus.WithFields(fields).Infof("Bucket cloned, %d objects copied.", copied)
+	return copied, nil
+}
@@ -0,0 +1,271 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+// minfsvolctl - Command line client of the admin API of the minfs docker volume plugin,
+// to inspect and repair the volumes without crafting raw requests.
+// $ minfsvolctl --address=unix:///run/minfs-admin.sock list
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+const usage = `usage: minfsvolctl [--address=<admin address>] <command> [arguments]
+
+commands:
+  list                     list the volumes
+  inspect <volume>         show the details of a volume
+  check <volume>           verify the bucket and the mount of a volume
+  force-unmount <volume>   unmount a volume even if it's in use by containers
+  remount <volume>         restart minfs serving a volume
+  credentials <volume>     rotate the credentials of a volume, read from
+                           $MINFS_ACCESS_KEY and $MINFS_SECRET_KEY
+  state dump               print the state bundle of all the volumes
+  state import <file>      import a state bundle
+  drain [on|off]           show, enter or leave drain mode
+  options                  list the -o options supported by the plugin
+`
+
+// client - client of the admin API.
+type client struct {
+	base string
+	http *http.Client
+}
+
+// return a new client of the admin API served at `address`,
+// a TCP address or the path of a unix socket prefixed with `unix://`.
+func newClient(address string) *client {
+	if strings.HasPrefix(address, "unix://") {
+		socket := strings.TrimPrefix(address, "unix://")
+		return &client{
+			base: "http://admin",
+			http: &http.Client{
+				Transport: &http.Transport{
+					Dial: func(_, _ string) (net.Conn, error) {
+						return net.Dial("unix", socket)
+					},
+				},
+			},
+		}
+	}
+	return &client{base: "http://" + address, http: http.DefaultClient}
+}
+
+// sends the request and decodes the JSON response into `out`, the error of the response is returned on failure.
+func (c *client) do(method, path string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return fmt.Errorf("%s", e.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// prints `v` as indented JSON.
+func printJSON(v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// volume - subset of the volume details used by `list`.
+type volume struct {
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+	Bucket string `json:"bucket"`
+	Status struct {
+		Mounted     bool `json:"mounted"`
+		Connections int  `json:"connections"`
+		Restarts    int  `json:"restarts"`
+	} `json:"status"`
+}
+
+func list(c *client) error {
+	var vols []volume
+	if err := c.do("GET", "/volumes", nil, &vols); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDRIVER\tBUCKET\tMOUNTED\tCONNECTIONS\tRESTARTS")
+	for _, v := range vols {
+		driver := v.Driver
+		if driver == "" {
+			driver = "minfs"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%d\t%d\n", v.Name, driver, v.Bucket, v.Status.Mounted, v.Status.Connections, v.Status.Restarts)
+	}
+	return w.Flush()
+}
+
+// option - description of a `-o` option, see `GET /options`.
+type option struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Values      []string `json:"values"`
+	Default     string   `json:"default"`
+	Description string   `json:"description"`
+}
+
+func options(c *client) error {
+	var opts []option
+	if err := c.do("GET", "/options", nil, &opts); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "OPTION\tTYPE\tDEFAULT\tDESCRIPTION")
+	for _, o := range opts {
+		typ := o.Type
+		if len(o.Values) > 0 {
+			typ = strings.Join(o.Values, "|")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", o.Name, typ, o.Default, o.Description)
+	}
+	return w.Flush()
+}
+
+// runs `method path` and prints the JSON response.
+func call(c *client, method, path string, body io.Reader) error {
+	var res interface{}
+	if err := c.do(method, path, body, &res); err != nil {
+		return err
+	}
+	return printJSON(res)
+}
+
+func run(c *client, args []string) error {
+	// returns the volume argument of the command.
+	volumePath := func(action string) (string, error) {
+		if len(args) != 2 || args[1] == "" {
+			return "", fmt.Errorf("%s requires the name of a volume", args[0])
+		}
+		path := "/volumes/" + url.PathEscape(args[1])
+		if action != "" {
+			path += "/" + action
+		}
+		return path, nil
+	}
+
+	switch args[0] {
+	case "list":
+		return list(c)
+	case "inspect", "check", "force-unmount", "remount":
+		action := map[string]string{"inspect": "", "check": "check", "force-unmount": "unmount", "remount": "remount"}[args[0]]
+		path, err := volumePath(action)
+		if err != nil {
+			return err
+		}
+		method := "POST"
+		if action == "" {
+			method = "GET"
+		}
+		return call(c, method, path, nil)
+	case "credentials":
+		path, err := volumePath("credentials")
+		if err != nil {
+			return err
+		}
+		// the keys are read from the environment to keep them out of the process list.
+		creds := map[string]string{"accessKey": os.Getenv("MINFS_ACCESS_KEY"), "secretKey": os.Getenv("MINFS_SECRET_KEY")}
+		if creds["accessKey"] == "" || creds["secretKey"] == "" {
+			return fmt.Errorf("MINFS_ACCESS_KEY and MINFS_SECRET_KEY have to be set")
+		}
+		body, err := json.Marshal(creds)
+		if err != nil {
+			return err
+		}
+		return call(c, "POST", path, bytes.NewReader(body))
+	case "state":
+		switch {
+		case len(args) == 2 && args[1] == "dump":
+			return call(c, "GET", "/state", nil)
+		case len(args) == 3 && args[1] == "import":
+			data, err := ioutil.ReadFile(args[2])
+			if err != nil {
+				return err
+			}
+			return call(c, "POST", "/state", bytes.NewReader(data))
+		}
+		return fmt.Errorf("usage: state dump | state import <file>")
+	case "drain":
+		method := "GET"
+		if len(args) == 2 {
+			switch args[1] {
+			case "on":
+				method = "POST"
+			case "off":
+				method = "DELETE"
+			default:
+				return fmt.Errorf("usage: drain [on|off]")
+			}
+		}
+		return call(c, method, "/drain", nil)
+	case "options":
+		return options(c)
+	}
+	return fmt.Errorf("unknown command %s", args[0])
+}
+
+func main() {
+	address := flag.String("address", "127.0.0.1:9101", "admin address of the plugin (`--admin-address` of the plugin).")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(newClient(*address), flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "minfsvolctl: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Mount tracking - The IDs of the active mounts of every volume, given by docker with the mount and
+// unmount requests, are reported in the status of the volume (`mounts`) along with the names of the
+// containers using the volume (`containers`). The containers are looked up with the Docker API once
+// the mount request is served, as docker doesn't tell which container a mount ID belongs to.
+
+// records the mount of the volume by docker.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) trackMount(v *mountInfo, id string) {
+	if v.mountIDs == nil {
+		v.mountIDs = make(map[string]bool)
+	}
+	if id != "" {
+		v.mountIDs[id] = true
+	}
+	d.countVolumes()
+	d.resolveContainers(v)
+}
+
+// forgets the mount of the volume, all the mounts are forgotten once the volume is released.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) untrackMount(v *mountInfo, id string) {
+	delete(v.mountIDs, id)
+	if v.connections == 0 {
+		v.mountIDs = nil
+	}
+	d.countVolumes()
+	d.resolveContainers(v)
+}
+
+// returns the sorted IDs of the active mounts of the volume.
+func (v *mountInfo) activeMounts() []string {
+	ids := make([]string, 0, len(v.mountIDs))
+	for id := range v.mountIDs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// looks up the names of the containers using the volume in the background, the Docker daemon may be
+// waiting for the response of the plugin to start the containers.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) resolveContainers(v *mountInfo) {
+	if v.connections == 0 {
+		v.containers = nil
+		return
+	}
+	go func() {
+		names, err := d.dockerAPI.volumeContainers(v.name)
+		if err != nil {
+			logrus.WithField("volume", v.name).Debugf("Unable to look up the containers using the volume. <ERROR> %v", err)
+			return
+		}
+		d.Lock()
+		defer d.Unlock()
+		if d.isTracked(v) && v.connections > 0 {
+			v.containers = names
+		}
+	}()
+}
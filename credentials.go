@@ -0,0 +1,221 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Credential files - The credentials of a volume can be read from files (ex: docker secrets) with
+// `-o access-key-file=<name> -o secret-key-file=<name>` instead of being passed as options.
+// The names are relative to `--credential-files-dir` (/run/secrets by default), the files have to resolve
+// under the directory once the symlinks are followed, so that a volume can't read other files of the host.
+// The files are read again when they change (see `watchCredentialFiles`) or when the plugin receives
+// SIGHUP, the volumes whose credentials changed are remounted one at a time with the new credentials.
+// The containers already running keep the previous minfs and credentials, see `stagedRemountVolume`, the
+// previous keys have to stay valid until they exit.
+
+// reads the access and secret keys from the files.
+func readCredentialFiles(accessKeyFile, secretKeyFile string) (string, string, error) {
+	accessKey, err := ioutil.ReadFile(accessKeyFile)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to read the access key file: %v", err)
+	}
+	secretKey, err := ioutil.ReadFile(secretKeyFile)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to read the secret key file: %v", err)
+	}
+	a, s := strings.TrimSpace(string(accessKey)), strings.TrimSpace(string(secretKey))
+	if a == "" || s == "" {
+		return "", "", fmt.Errorf("credential files %s and %s cannot be empty", accessKeyFile, secretKeyFile)
+	}
+	return a, s, nil
+}
+
+// verifies that the name of a credential file is relative to `--credential-files-dir` and stays in it.
+func validateCredentialFileName(name string) error {
+	if filepath.IsAbs(name) {
+		return fmt.Errorf("credential file %q must be relative to --credential-files-dir.", name)
+	}
+	if filepath.Clean(name) == "." {
+		return fmt.Errorf("invalid credential file %q.", name)
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return fmt.Errorf("credential file %q must not leave --credential-files-dir.", name)
+		}
+	}
+	return nil
+}
+
+// returns the path of the credential file `name` of a volume in `--credential-files-dir`,
+// the file has to resolve under the directory once the symlinks are followed.
+func (d *minfsDriver) credentialFilePath(name string) (string, error) {
+	if err := validateCredentialFileName(name); err != nil {
+		return "", err
+	}
+	dir, err := filepath.EvalSymlinks(d.credentialFilesDir)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve --credential-files-dir: %v", err)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(dir, name))
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve credential file %s: %v", name, err)
+	}
+	if !isUnder(path, dir, false) {
+		return "", fmt.Errorf("credential file %s resolves to %s outside of %s", name, path, d.credentialFilesDir)
+	}
+	return path, nil
+}
+
+// reads the access and secret keys of a volume from the files of `--credential-files-dir`.
+func (d *minfsDriver) readVolumeCredentialFiles(accessKeyFile, secretKeyFile string) (string, string, error) {
+	accessKeyPath, err := d.credentialFilePath(accessKeyFile)
+	if err != nil {
+		return "", "", err
+	}
+	secretKeyPath, err := d.credentialFilePath(secretKeyFile)
+	if err != nil {
+		return "", "", err
+	}
+	return readCredentialFiles(accessKeyPath, secretKeyPath)
+}
+
+// resolves the `access-key-file` and `secret-key-file` options of the create request into the
+// `access-key` and `secret-key` options.
+func (d *minfsDriver) credentialFileOptions(options map[string]string) error {
+	accessKeyFile, secretKeyFile := options["access-key-file"], options["secret-key-file"]
+	if accessKeyFile == "" && secretKeyFile == "" {
+		return nil
+	}
+	if accessKeyFile == "" || secretKeyFile == "" {
+		return fmt.Errorf("access-key-file and secret-key-file options have to be set together.")
+	}
+	if options["access-key"] != "" || options["secret-key"] != "" {
+		return fmt.Errorf("access-key and secret-key cannot be set with access-key-file and secret-key-file.")
+	}
+	accessKey, secretKey, err := d.readVolumeCredentialFiles(accessKeyFile, secretKeyFile)
+	if err != nil {
+		return err
+	}
+	options["access-key"], options["secret-key"] = accessKey, secretKey
+	return nil
+}
+
+// reloads the credential files of the volumes, called on SIGHUP.
+// The volumes are processed one at a time, releasing the driver lock in between,
+// so that the other volumes can be used while a volume is remounted.
+func (d *minfsDriver) reloadCredentials() {
+	d.RLock()
+	var names []string
+	for name, v := range d.mounts {
+		if v.config.accessKeyFile != "" {
+			names = append(names, name)
+		}
+	}
+	d.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := d.reloadVolumeCredentials(name); err != nil {
+			logrus.WithField("volume", name).Errorf("Reloading the credentials failed. <ERROR> %v", err)
+		}
+	}
+}
+
+// reloads the credential files of the volume and remounts it if the credentials changed.
+// The files are read before the driver lock is taken, the lock of the volume keeps its config from changing.
+func (d *minfsDriver) reloadVolumeCredentials(name string) error {
+	v := d.lockVolume(name)
+	if v == nil {
+		return nil
+	}
+	defer v.ops.Unlock()
+
+	accessKey, secretKey, err := d.readVolumeCredentialFiles(v.config.accessKeyFile, v.config.secretKeyFile)
+	if err != nil {
+		return err
+	}
+	d.Lock()
+	defer d.Unlock()
+	if accessKey == v.config.accessKey && secretKey == v.config.secretKey {
+		return nil
+	}
+	return d.rotateCredentials(v, accessKey, secretKey)
+}
+
+// sets the credentials of the volume on request of an operator (admin API or `-o rotate-credentials=true`).
+// The credentials of the volumes reading them from files are only changed by updating the files.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) updateCredentials(v *mountInfo, accessKey, secretKey string) error {
+	if accessKey == "" || secretKey == "" {
+		return newCodedError(errBadOption, "access-key and secret-key cannot be empty")
+	}
+	if v.config.accessKeyFile != "" {
+		return newCodedError(errBadOption, "credentials of volume %s are read from %s and %s, update the files and send SIGHUP to the plugin",
+			v.name, v.config.accessKeyFile, v.config.secretKeyFile)
+	}
+	// the service account would be left behind.
+	if v.config.serviceAccount {
+		return newCodedError(errBadOption, "volume %s uses the service account %s minted for it, its credentials cannot be changed",
+			v.name, v.config.accessKey)
+	}
+	return d.rotateCredentials(v, accessKey, secretKey)
+}
+
+// updates the credentials of an existing volume created again with `-o rotate-credentials=true`.
+// The endpoint and bucket of the request have to match the ones of the volume.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) recreateWithCredentials(v *mountInfo, options map[string]string) error {
+	endpoint := v.config.endpoint
+	if len(v.config.endpoints) > 0 {
+		endpoint = strings.Join(v.config.endpoints, ",")
+	}
+	if (options["endpoint"] != "" && options["endpoint"] != endpoint) || (options["bucket"] != "" && options["bucket"] != v.config.bucket) {
+		return newCodedError(errBadOption, "volume %s already exists with endpoint %s and bucket %s, only its credentials can be rotated",
+			v.name, endpoint, v.config.bucket)
+	}
+	return d.updateCredentials(v, options["access-key"], options["secret-key"])
+}
+
+// sets the credentials of the volume, the volume is remounted if it's served by minfs.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) rotateCredentials(v *mountInfo, accessKey, secretKey string) error {
+	if v.config.anonymous {
+		return newCodedError(errBadOption, "anonymous volume %s has no credentials", v.name)
+	}
+	v.config.accessKey, v.config.secretKey = accessKey, secretKey
+	v.missingCredentials = ""
+	logrus.WithField("volume", v.name).Info("Credentials changed.")
+	d.markDirty()
+
+	// volumes not served by minfs pick up the credentials on their next mount.
+	if !v.mounted() {
+		return nil
+	}
+	if err := d.remountVolume(v, "credentials changed"); err != nil {
+		return err
+	}
+	logrus.WithField("volume", v.name).Info("Volume remounted with the new credentials.")
+	return nil
+}
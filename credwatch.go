@@ -0,0 +1,135 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// interval at which the watched directories are synced with the credential files of the volumes.
+	credentialWatchSyncInterval = 10 * time.Second
+	// the credentials are reloaded once the files have not changed for this long,
+	// the access and secret key files are usually updated together.
+	credentialWatchDebounce = time.Second
+	// changes of the entries of a directory replacing or updating the files in it.
+	credentialWatchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_ATTRIB
+)
+
+// Watching the credential files - With `--watch-credential-files`, the directories of the credential
+// files of the volumes are watched with inotify. The files are often replaced rather than written
+// (ex: secrets updated with a rename), the directories are watched rather than the files.
+// A change reloads the credential files as on SIGHUP, see `reloadCredentials`.
+
+// returns the directories of the credential files of the volumes.
+func (d *minfsDriver) credentialDirs() map[string]bool {
+	d.RLock()
+	defer d.RUnlock()
+
+	dirs := make(map[string]bool)
+	for _, v := range d.mounts {
+		if v.config.accessKeyFile != "" {
+			dirs[filepath.Dir(filepath.Join(d.credentialFilesDir, v.config.accessKeyFile))] = true
+			dirs[filepath.Dir(filepath.Join(d.credentialFilesDir, v.config.secretKeyFile))] = true
+		}
+	}
+	return dirs
+}
+
+// watches the credential files of the volumes and reloads them on change, until the plugin exits.
+func (d *minfsDriver) watchCredentialFiles() {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		logrus.Errorf("Unable to watch the credential files. <ERROR> %v", err)
+		return
+	}
+	// the events are only used as a trigger, their content is not decoded.
+	events := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			if _, err := syscall.Read(fd, buf); err != nil {
+				logrus.Errorf("Watching the credential files failed. <ERROR> %v", err)
+				return
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	// watch descriptors of the watched directories.
+	watched := make(map[string]int)
+	// directories which could not be watched, the failure is only logged once until they are watched.
+	failed := make(map[string]bool)
+	sync := time.NewTicker(credentialWatchSyncInterval)
+	defer sync.Stop()
+	// fires once the files have settled after a change.
+	var reload <-chan time.Time
+	for {
+		// watch the directories of the volumes created or imported in the meantime,
+		// and stop watching the directories no volume uses anymore.
+		dirs := d.credentialDirs()
+		for dir, wd := range watched {
+			if dirs[dir] {
+				continue
+			}
+			if _, err := syscall.InotifyRmWatch(fd, uint32(wd)); err != nil {
+				logrus.WithField("directory", dir).Debugf("Unable to stop watching the credential files. <ERROR> %v", err)
+			}
+			delete(watched, dir)
+		}
+		for dir := range failed {
+			if !dirs[dir] {
+				delete(failed, dir)
+			}
+		}
+		for dir := range dirs {
+			if _, ok := watched[dir]; ok {
+				continue
+			}
+			wd, err := syscall.InotifyAddWatch(fd, dir, credentialWatchMask)
+			if err != nil {
+				log := logrus.WithField("directory", dir)
+				if failed[dir] {
+					log.Debugf("Unable to watch the credential files. <ERROR> %v", err)
+				} else {
+					log.Warnf("Unable to watch the credential files, retrying every %s. <ERROR> %v", credentialWatchSyncInterval, err)
+					failed[dir] = true
+				}
+				continue
+			}
+			delete(failed, dir)
+			watched[dir] = wd
+		}
+
+		select {
+		case <-sync.C:
+		case <-events:
+			reload = time.After(credentialWatchDebounce)
+		case <-reload:
+			reload = nil
+			logrus.Info("Credential files changed, reloading them.")
+			d.reloadCredentials()
+		}
+	}
+}
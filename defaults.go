@@ -0,0 +1,71 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Default options - With `--default-opt <option>=<value>`, the operator sets the default value of any
+// `-o` option of the volumes, so that the site policy doesn't have to be repeated in every compose file.
+// The defaults are merged under the options of the create request and the defaults of the driver alias,
+// which take precedence.
+
+// defaultOptFlags - values of the repeatable `--default-opt` flag, by option.
+type defaultOptFlags map[string]string
+
+func (f defaultOptFlags) String() string {
+	var opts []string
+	for k, v := range f {
+		opts = append(opts, k+"="+v)
+	}
+	return strings.Join(opts, " ")
+}
+
+func (f defaultOptFlags) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return fmt.Errorf("invalid default option %q, must be <option>=<value>", value)
+	}
+	name, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+	if _, ok := lookupOption(name); !ok {
+		return fmt.Errorf("invalid default option %q, %s is not a supported option", value, name)
+	}
+	// the value is checked against the schema of the option, so that the plugin fails at startup rather
+	// than every create request.
+	if err := validateOptions(map[string]string{name: v}); err != nil {
+		return fmt.Errorf("invalid default option %q, %v", value, err)
+	}
+	f[name] = v
+	return nil
+}
+
+// returns the options of the create request merged over the default options of the plugin.
+func (d *minfsDriver) withDefaultOptions(options map[string]string) map[string]string {
+	if len(d.defaultOptions) == 0 {
+		return options
+	}
+	merged := make(map[string]string, len(options)+len(d.defaultOptions))
+	for k, v := range d.defaultOptions {
+		merged[k] = v
+	}
+	for k, v := range options {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,45 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import "testing"
+
+// The default options are checked against the schema of the options when the flags are parsed.
+func TestDefaultOptFlagsSet(t *testing.T) {
+	testCases := []struct {
+		value string
+		valid bool
+	}{
+		{"consistency=strict", true},
+		{"owner=1000:1000", true},
+		{" watch-changes = true ", true},
+		{"consistency=eventual", false},
+		{"watch-changes=sometimes", false},
+		{"uid=1000", false},
+		{"consistency", false},
+	}
+	for i, testCase := range testCases {
+		f := make(defaultOptFlags)
+		err := f.Set(testCase.value)
+		if testCase.valid && err != nil {
+			t.Errorf("Test %d: expected %q to be accepted, got %v", i+1, testCase.value, err)
+		}
+		if !testCase.valid && err == nil {
+			t.Errorf("Test %d: expected %q to be rejected.", i+1, testCase.value)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// default path of the Docker daemon API socket.
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// dockerClient - Minimal client of the Docker Engine API served on the unix socket of the daemon.
+// Used to run minfs in helper containers, see `--minfs-image`.
+type dockerClient struct {
+	client *http.Client
+}
+
+// return a new dockerClient talking to the daemon listening at `socket`.
+func newDockerClient(socket string) *dockerClient {
+	return &dockerClient{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Dial: func(_, _ string) (net.Conn, error) {
+					return net.Dial("unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// sends a request to the Docker API, `in` is encoded as the JSON body and the JSON response is decoded into `out`.
+func (c *dockerClient) do(method, path string, query url.Values, in, out interface{}) error {
+	resp, err := c.request(method, path, query, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// sends a request to the Docker API and returns the response, non 2xx responses are returned as errors.
+func (c *dockerClient) request(method, path string, query url.Values, in interface{}) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(buf)
+	}
+	u := url.URL{Scheme: "http", Host: "docker", Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var e struct {
+			Message string `json:"message"`
+		}
+		msg, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(msg, &e) == nil && e.Message != "" {
+			msg = []byte(e.Message)
+		}
+		return nil, fmt.Errorf("docker API %s %s: %s (%d)", method, path, bytes.TrimSpace(msg), resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// containerSpec - subset of the container create request of the Docker API.
+type containerSpec struct {
+	Image      string
+	Cmd        []string
+	Env        []string
+	User       string `json:",omitempty"`
+	Labels     map[string]string
+	HostConfig hostConfig
+}
+
+// hostConfig - subset of the container host config of the Docker API.
+type hostConfig struct {
+	Binds       []string
+	CapAdd      []string
+	Devices     []deviceMapping
+	SecurityOpt []string
+	NetworkMode string
+	// host entries (`<name>:<ip>`) added to /etc/hosts of the container.
+	ExtraHosts []string `json:",omitempty"`
+	// memory limit in bytes and CPU quota in billionths of a CPU, unlimited if 0.
+	Memory   int64 `json:",omitempty"`
+	NanoCPUs int64 `json:"NanoCpus,omitempty"`
+}
+
+// deviceMapping - device made available inside the container.
+type deviceMapping struct {
+	PathOnHost        string
+	PathInContainer   string
+	CgroupPermissions string
+}
+
+// returns the names of the containers using the volume, created or running.
+func (c *dockerClient) volumeContainers(volume string) ([]string, error) {
+	filters, err := json.Marshal(map[string][]string{
+		"volume": {volume},
+		"status": {"created", "running", "paused", "restarting"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var containers []struct {
+		Names []string
+	}
+	if err := c.do("GET", "/containers/json", url.Values{"all": {"true"}, "filters": {string(filters)}}, nil, &containers); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, c := range containers {
+		if len(c.Names) > 0 {
+			names = append(names, strings.TrimPrefix(c.Names[0], "/"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// creates and starts a container, returns its id.
+func (c *dockerClient) runContainer(name string, spec containerSpec) (string, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.do("POST", "/containers/create", url.Values{"name": {name}}, spec, &created); err != nil {
+		return "", err
+	}
+	if err := c.do("POST", "/containers/"+created.ID+"/start", nil, nil, nil); err != nil {
+		c.removeContainer(created.ID)
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// blocks until the container exits, returns an error if it exited with a non zero status.
+func (c *dockerClient) waitContainer(id string) error {
+	var res struct {
+		StatusCode int
+	}
+	if err := c.do("POST", "/containers/"+id+"/wait", nil, nil, &res); err != nil {
+		return err
+	}
+	if res.StatusCode != 0 {
+		return fmt.Errorf("container %s exited with status %d", id, res.StatusCode)
+	}
+	return nil
+}
+
+// forcibly removes the container, killing it if it's still running.
+func (c *dockerClient) removeContainer(id string) error {
+	return c.do("DELETE", "/containers/"+id, url.Values{"force": {"true"}}, nil, nil)
+}
+
+// streams the stdout and stderr of the container to the writers until the container exits.
+func (c *dockerClient) followLogs(id string, stdout, stderr io.Writer) error {
+	query := url.Values{"follow": {"true"}, "stdout": {"true"}, "stderr": {"true"}}
+	resp, err := c.request("GET", "/containers/"+id+"/logs", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// containers without a TTY multiplex both streams,
+	// each frame has an 8 byte header holding the stream type and the frame size.
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(resp.Body, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		if _, err := io.CopyN(w, resp.Body, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
+
+// starts minfs in a helper container running `--minfs-image`.
+// The mount root is bind mounted with shared propagation, so that the FUSE mount
+// made inside the container is visible at the mountpoint on the host.
+// This requires the mount root to be a shared mount on the host (`mount --make-shared`).
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while the
+// container is started.
+func (d *minfsDriver) startMinfsContainer(v *mountInfo) (*minfsProcess, error) {
+	spec := containerSpec{
+		Image:  d.minfsImage,
+		Cmd:    d.minfsArgs(v),
+		Env:    minfsEnv(v),
+		User:   d.runAs.containerUser(),
+		Labels: map[string]string{"minfs.volume": v.name},
+		HostConfig: hostConfig{
+			Binds:       []string{d.mountRoot + ":" + d.mountRoot + ":rshared"},
+			CapAdd:      []string{"SYS_ADMIN"},
+			Devices:     []deviceMapping{{PathOnHost: "/dev/fuse", PathInContainer: "/dev/fuse", CgroupPermissions: "rwm"}},
+			SecurityOpt: []string{"apparmor:unconfined"},
+			NetworkMode: "host",
+		},
+	}
+	// minfs resolves the endpoint as the plugin does, see `endpointHostEntries`.
+	spec.HostConfig.ExtraHosts = endpointHostEntries(v.config)
+	// bound the memory and CPU of minfs, see `limitResources`.
+	memory, cpus := d.resourceLimits(v)
+	spec.HostConfig.Memory = memory
+	spec.HostConfig.NanoCPUs = int64(cpus * 1e9)
+	// the mount root of the volume is bind mounted as well if it has one of its own.
+	if root := d.volumeMountRoot(v.config); root != d.mountRoot {
+		spec.HostConfig.Binds = append(spec.HostConfig.Binds, root+":"+root+":rshared")
+	}
+	name := "minfs-" + filepath.Base(v.mountPoint)
+	logrus.WithField("volume", v.name).Debugf("starting minfs container %s: %v", name, spec.Cmd)
+	var id string
+	err := d.unlocked(func(context.Context) (err error) {
+		// clear a leftover container of a previous mount of the volume.
+		d.docker.removeContainer(name)
+		id, err = d.docker.runContainer(name, spec)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// capture the output of minfs into the plugin log.
+	stdout, stderr := v.output.writer("stdout"), v.output.writer("stderr")
+	logsDone := make(chan struct{})
+	go func() {
+		defer close(logsDone)
+		if err := d.docker.followLogs(id, stdout, stderr); err != nil {
+			logrus.WithField("volume", v.name).Debugf("Streaming minfs container logs failed. <ERROR> %v", err)
+		}
+		stdout.flush()
+		stderr.flush()
+	}()
+
+	return &minfsProcess{
+		container: id,
+		wait: func() error {
+			err := d.docker.waitContainer(id)
+			<-logsDone
+			// the container is not reused, the next mount starts a new one.
+			if rErr := d.docker.removeContainer(id); rErr != nil {
+				logrus.WithField("volume", v.name).Errorf("Removing minfs container failed. <ERROR> %v", rErr)
+			}
+			return err
+		},
+		kill: func() error {
+			return d.docker.removeContainer(id)
+		},
+	}, nil
+}
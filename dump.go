@@ -0,0 +1,116 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// State dump - On SIGUSR1 the plugin writes a JSON snapshot of its volumes (status, connections, health
+// and recent output of minfs) to the log, or to `--dump-file`, so that the state of a wedged host can be
+// captured without restarting anything. The credentials of the volumes are never part of the dump.
+// If the driver lock can't be taken, the dump holds the stacks of the goroutines instead of the volumes.
+
+// time waited for the driver lock before dumping the goroutines instead.
+const dumpLockTimeout = 5 * time.Second
+
+// stateDump - Snapshot of the state of the plugin.
+type stateDump struct {
+	Time     time.Time    `json:"time"`
+	Draining bool         `json:"draining"`
+	Volumes  []volumeDump `json:"volumes"`
+	// number of Mount, Unmount and Remove requests queued to the workers, by volume.
+	QueuedRequests map[string]int `json:"queuedRequests,omitempty"`
+	// set when the driver lock was not released within `dumpLockTimeout`.
+	LockTimeout bool   `json:"lockTimeout,omitempty"`
+	Goroutines  string `json:"goroutines,omitempty"`
+}
+
+// volumeDump - State of a volume in the dump.
+type volumeDump struct {
+	Name       string                 `json:"name"`
+	Mountpoint string                 `json:"mountpoint"`
+	Status     map[string]interface{} `json:"status"`
+	// last lines of output of minfs.
+	Output []string `json:"output,omitempty"`
+}
+
+// returns the snapshot of the state of the plugin.
+func (d *minfsDriver) dumpState() stateDump {
+	// the queues are read without the driver lock, they tell which volumes are stuck.
+	dump := stateDump{Time: time.Now().UTC(), QueuedRequests: d.workers.pending()}
+	locked := make(chan struct{})
+	go func() {
+		d.RLock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(dumpLockTimeout):
+		// the lock is released by the goroutine above once it gets it.
+		go func() {
+			<-locked
+			d.RUnlock()
+		}()
+		buf := make([]byte, 1<<20)
+		dump.LockTimeout = true
+		dump.Goroutines = string(buf[:runtime.Stack(buf, true)])
+		return dump
+	}
+	defer d.RUnlock()
+
+	dump.Draining = d.draining
+	names := make([]string, 0, len(d.mounts))
+	for name := range d.mounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := d.mounts[name]
+		dump.Volumes = append(dump.Volumes, volumeDump{
+			Name:       name,
+			Mountpoint: v.mountPoint,
+			Status:     v.status(),
+			Output:     v.output.tail(),
+		})
+	}
+	return dump
+}
+
+// writes the snapshot of the state of the plugin to the file, or to the log if it's empty.
+func (d *minfsDriver) writeStateDump(file string) {
+	data, err := json.MarshalIndent(d.dumpState(), "", "  ")
+	if err != nil {
+		logrus.Errorf("Dumping the state failed. <ERROR> %v", err)
+		return
+	}
+	if file == "" {
+		logrus.Infof("State dump:\n%s", data)
+		return
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		logrus.Errorf("Writing the state dump failed. <ERROR> %v", err)
+		return
+	}
+	logrus.Infof("State dumped to %s.", file)
+}
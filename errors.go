@@ -0,0 +1,94 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/minio/minio-go"
+)
+
+// errorCode - Category of the errors returned to docker.
+// The code prefixes the error message of the response (ex: "[not-found] volume foo not found"),
+// so that tools driving the plugin can react to the errors programmatically.
+type errorCode string
+
+// Error codes of the responses.
+const (
+	// the volume, bucket or object doesn't exist.
+	errNotFound errorCode = "not-found"
+	// an option of the request is missing or invalid.
+	errBadOption errorCode = "bad-option"
+	// the request is not authorized by the plugin, or the credentials are refused by the server.
+	errAuthFailed errorCode = "auth-failed"
+	// the Minio server can't be reached.
+	errEndpointUnreachable errorCode = "endpoint-unreachable"
+	// the volume is in use by containers.
+	errMountBusy errorCode = "mount-busy"
+	// the plugin is draining and doesn't accept new volumes or mounts, the request can be retried later.
+	errUnavailable errorCode = "unavailable"
+	// the plugin holds the maximum number of volumes, see `--max-volumes`.
+	errQuotaExceeded errorCode = "quota-exceeded"
+	// any other failure.
+	errInternal errorCode = "internal"
+)
+
+// codedError - error carrying its error code.
+type codedError struct {
+	code errorCode
+	msg  string
+}
+
+func (e codedError) Error() string {
+	return e.msg
+}
+
+// returns a new error of the given code.
+func newCodedError(code errorCode, format string, args ...interface{}) error {
+	return codedError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// returns the error code of the error, errors of the S3 API are classified by their S3 error code.
+func errorCodeOf(err error) errorCode {
+	switch e := err.(type) {
+	case codedError:
+		return e.code
+	case net.Error:
+		// includes the *url.Error returned by the HTTP clients.
+		return errEndpointUnreachable
+	case minio.ErrorResponse:
+		return s3ErrorCode(e.Code)
+	case s3Error:
+		return s3ErrorCode(e.Code)
+	}
+	return errInternal
+}
+
+// maps the S3 error codes to the error codes of the plugin.
+func s3ErrorCode(code string) errorCode {
+	switch code {
+	case "AccessDenied", "AllAccessDisabled", "InvalidAccessKeyId", "SignatureDoesNotMatch":
+		return errAuthFailed
+	case "NoSuchBucket", "NoSuchKey":
+		return errNotFound
+	case "AuthorizationHeaderMalformed", "PermanentRedirect", "IllegalLocationConstraintException":
+		// the region of the volume doesn't match the region of the bucket or of the endpoint.
+		return errBadOption
+	}
+	return errInternal
+}
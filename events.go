@@ -0,0 +1,151 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Volume events - With `--event-webhook`, the plugin POSTs a JSON event to the given URL whenever a volume is
+// created, mounted (by its first container), unmounted (by its last container), removed, remounted or
+// becomes unhealthy (its endpoint is no longer `healthy`, see `recordProbe`). The events are queued and sent
+// in order by a single goroutine so that the requests of Docker never wait for the webhook, the events
+// which don't fit in the queue while the webhook is slow or down are dropped and logged.
+
+// events sent to `--event-webhook`.
+const (
+	eventCreated   = "created"
+	eventMounted   = "mounted"
+	eventUnmounted = "unmounted"
+	eventRemoved   = "removed"
+	eventUnhealthy = "unhealthy"
+	eventRemounted = "remounted"
+)
+
+// time given to the event webhook to accept an event.
+const eventWebhookTimeout = 10 * time.Second
+
+// number of events waiting to be sent before new events are dropped.
+const eventQueueSize = 1024
+
+// volumeEvent - Event POSTed to the webhook.
+type volumeEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Host   string    `json:"host"`
+	Volume string    `json:"volume"`
+	// name of the driver the volume was created with, see `--driver-aliases`.
+	Driver      string   `json:"driver"`
+	Endpoint    string   `json:"endpoint"`
+	Bucket      string   `json:"bucket,omitempty"`
+	Buckets     []string `json:"buckets,omitempty"`
+	Mountpoint  string   `json:"mountpoint"`
+	Connections int      `json:"connections"`
+	// health of the endpoint and last probe error of the unhealthy events.
+	Health string `json:"health,omitempty"`
+	// why the volume was remounted or the endpoint is unhealthy.
+	Reason string `json:"reason,omitempty"`
+}
+
+// eventNotifier - Sends the events of the volumes to the webhook.
+type eventNotifier struct {
+	url    string
+	host   string
+	client *http.Client
+	queue  chan volumeEvent
+}
+
+// returns a new eventNotifier POSTing the events to `url`, its events are sent once `run` is started.
+func newEventNotifier(url string) *eventNotifier {
+	host, _ := os.Hostname()
+	return &eventNotifier{
+		url:    url,
+		host:   host,
+		client: &http.Client{Timeout: eventWebhookTimeout},
+		queue:  make(chan volumeEvent, eventQueueSize),
+	}
+}
+
+// sends the queued events, until the plugin exits.
+func (n *eventNotifier) run() {
+	for e := range n.queue {
+		if err := n.post(e); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"event":  e.Event,
+				"volume": e.Volume,
+			}).Errorf("Sending the volume event failed. <ERROR> %v", err)
+		}
+	}
+}
+
+// POSTs the event to the webhook.
+func (n *eventNotifier) post(e volumeEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("event webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// queues the event of the volume, no-op without `--event-webhook`.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) notify(event string, v *mountInfo, reason string) {
+	if d.events == nil {
+		return
+	}
+	e := volumeEvent{
+		Time:        time.Now().UTC(),
+		Event:       event,
+		Host:        d.events.host,
+		Volume:      v.name,
+		Driver:      v.driver,
+		Endpoint:    v.config.endpoint,
+		Bucket:      v.config.bucket,
+		Mountpoint:  v.mountPoint,
+		Connections: v.connections,
+		Reason:      reason,
+	}
+	for _, b := range v.config.union {
+		e.Buckets = append(e.Buckets, b.bucket)
+	}
+	if event == eventUnhealthy {
+		e.Health = v.health.state
+	}
+	select {
+	case d.events.queue <- e:
+	default:
+		logrus.WithFields(logrus.Fields{
+			"event":  event,
+			"volume": v.name,
+		}).Warn("Event queue is full, volume event dropped.")
+	}
+}
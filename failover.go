@@ -0,0 +1,134 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// interval at which the active endpoint of the volumes with several endpoints is probed.
+const failoverProbeInterval = 10 * time.Second
+
+// Failover - A volume can be created with several endpoints of a highly available Minio deployment
+// (`-o endpoint=https://m1:9000,https://m2:9000`). The first reachable endpoint is used at mount time,
+// while the volume is mounted its active endpoint is probed and minfs is restarted on another
+// reachable endpoint when it becomes unreachable.
+
+// splits the comma separated `endpoint` option.
+func splitEndpoints(option string) []string {
+	var endpoints []string
+	for _, e := range strings.Split(option, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			endpoints = append(endpoints, e)
+		}
+	}
+	return endpoints
+}
+
+// returns true if the Minio server at `endpoint` responds, whatever the response.
+func endpointReachable(config serverConfig, endpoint string) bool {
+	config.endpoint = endpoint
+	_, err := bucketExists(config)
+	return err == nil || errorCodeOf(err) != errEndpointUnreachable
+}
+
+// returns the first reachable endpoint of the volume, starting with the active endpoint.
+func selectEndpoint(config serverConfig) (string, error) {
+	if len(config.endpoints) <= 1 || endpointReachable(config, config.endpoint) {
+		return config.endpoint, nil
+	}
+	for _, e := range config.endpoints {
+		if e != config.endpoint && endpointReachable(config, e) {
+			return e, nil
+		}
+	}
+	return "", newCodedError(errEndpointUnreachable, "none of the endpoints %s is reachable", strings.Join(config.endpoints, ", "))
+}
+
+// switches the volume to a reachable endpoint if its active endpoint is unreachable.
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while
+// the endpoints are probed.
+func (d *minfsDriver) failover(v *mountInfo) bool {
+	if len(v.config.endpoints) <= 1 {
+		return false
+	}
+	var endpoint string
+	err := d.unlocked(func(context.Context) (err error) {
+		endpoint, err = selectEndpoint(v.config)
+		return err
+	})
+	if err != nil || endpoint == v.config.endpoint {
+		return false
+	}
+	d.log().WithFields(logrus.Fields{
+		"volume": v.name,
+		"from":   v.config.endpoint,
+		"to":     endpoint,
+	}).Warn("Endpoint unreachable, failing over.")
+	v.config.endpoint = endpoint
+	return true
+}
+
+// probes the active endpoint of the volume while it's served by `p`.
+// On failover minfs is killed, the supervisor restarts it on the new endpoint.
+func (d *minfsDriver) monitorEndpoint(v *mountInfo, p *minfsProcess) {
+	ticker := time.NewTicker(failoverProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		// probe and select the new endpoint without holding the locks, so that an unreachable endpoint
+		// doesn't hold up the operations of the volume.
+		d.RLock()
+		config := v.config
+		d.RUnlock()
+		if endpointReachable(config, config.endpoint) {
+			continue
+		}
+		endpoint, err := selectEndpoint(config)
+		if err != nil || endpoint == config.endpoint {
+			continue
+		}
+
+		// the endpoint is switched unless minfs was restarted or the volume failed over in the meantime.
+		d.Lock()
+		failed := v.proc == p && v.config.endpoint == config.endpoint
+		if failed {
+			logrus.WithFields(logrus.Fields{
+				"volume": v.name,
+				"from":   config.endpoint,
+				"to":     endpoint,
+			}).Warn("Endpoint unreachable, failing over.")
+			v.config.endpoint = endpoint
+		}
+		d.Unlock()
+		if !failed {
+			continue
+		}
+		if err := p.kill(); err != nil {
+			logrus.WithField("volume", v.name).Errorf("Stopping minfs for the failover failed. <ERROR> %v", err)
+		}
+		return
+	}
+}
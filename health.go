@@ -0,0 +1,189 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Endpoint health - The endpoint of every volume is probed at `--probe-interval` with a HEAD request
+// of its bucket. The consecutive failed probes move the volume from healthy to degraded
+// (`--probe-degraded-after`) and unreachable (`--probe-unreachable-after`), a successful probe makes
+// it healthy again. A volume whose endpoint is healthy while its mount fails points at minfs
+// rather than at the Minio server. With the credentials of the MinIO admin API, the reachable endpoints
+// are also checked for offline servers and drives, see `minioAdmin`.
+
+// Health states of the endpoint of a volume.
+const (
+	healthHealthy     = "healthy"
+	healthDegraded    = "degraded"
+	healthUnreachable = "unreachable"
+	// the endpoint is reachable but servers or drives of the MinIO deployment are offline or healing.
+	healthServerDegraded = "server-degraded"
+)
+
+// value of the `minfs_volume_endpoint_health` gauge for each state.
+var healthGaugeValues = map[string]float64{
+	healthHealthy:        0,
+	healthDegraded:       1,
+	healthUnreachable:    2,
+	healthServerDegraded: 3,
+}
+
+// endpointHealth - health of the endpoint of a volume, updated by the endpoint prober.
+type endpointHealth struct {
+	// one of the health states, empty until the endpoint is probed.
+	state string
+	// number of consecutive failed probes.
+	failures int
+	// time and error of the last probe.
+	lastProbe time.Time
+	lastErr   string
+	// health of the MinIO deployment of the last probe, nil without the admin API.
+	server *serverHealth
+	// error of the last server info request, the health of the deployment is unknown.
+	serverErr string
+}
+
+// returns the health state after `failures` consecutive failed probes.
+func (d *minfsDriver) healthState(failures int) string {
+	switch {
+	case failures >= d.probeUnreachableAfter:
+		return healthUnreachable
+	case failures >= d.probeDegradedAfter:
+		return healthDegraded
+	}
+	return healthHealthy
+}
+
+// returns true if the volume is healthy: its endpoint isn't degraded or unreachable, and minfs is running
+// if containers use the volume (it isn't while it's restarted after a crash).
+func (v *mountInfo) healthy() bool {
+	if v.connections > 0 && (!v.mounted() || !v.unionHealthy()) {
+		return false
+	}
+	return v.health.state == "" || v.health.state == healthHealthy
+}
+
+// returns the summary of the status of the volume reported in the List responses, so that the unhealthy
+// volumes are found without inspecting each of them. `status` is the full status of the volume.
+func (v *mountInfo) listStatus() map[string]interface{} {
+	return map[string]interface{}{
+		"createdAt":   v.createdAt.Format(time.RFC3339),
+		"mounted":     v.mounted(),
+		"healthy":     v.healthy(),
+		"connections": v.connections,
+	}
+}
+
+// HEADs the bucket of the volume, a missing bucket counts as a failure.
+func probeEndpoint(config serverConfig) error {
+	exists, err := bucketExists(config)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s not found", config.bucket)
+	}
+	return nil
+}
+
+// probes the endpoints of the volumes every `interval`, until the plugin exits.
+func (d *minfsDriver) probeEndpoints(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		// probe without holding the lock, the volumes may be used in the meantime.
+		d.RLock()
+		configs := make(map[string]serverConfig, len(d.mounts))
+		for name, v := range d.mounts {
+			// snapshot volumes are served from the local copy of the objects.
+			if v.config.snapshot.IsZero() {
+				configs[name] = v.config
+			}
+		}
+		d.RUnlock()
+
+		names := make([]string, 0, len(configs))
+		for name := range configs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		// the server info of every endpoint is fetched once per probe.
+		servers := make(map[string]*serverHealth)
+		serverErrs := make(map[string]error)
+		for _, name := range names {
+			config := configs[name]
+			err := probeEndpoint(config)
+			if err == nil && d.minioAdmin != nil {
+				if _, ok := servers[config.endpoint]; !ok {
+					servers[config.endpoint], serverErrs[config.endpoint] = d.minioAdmin.serverHealth(config)
+				}
+			}
+			d.recordProbe(name, err, servers[config.endpoint], serverErrs[config.endpoint])
+		}
+	}
+}
+
+// updates the health of the volume with the result of a probe and the health of the MinIO deployment.
+func (d *minfsDriver) recordProbe(name string, err error, server *serverHealth, serverErr error) {
+	d.Lock()
+	defer d.Unlock()
+
+	v, ok := d.mounts[name]
+	if !ok {
+		return
+	}
+	h := &v.health
+	h.lastProbe = time.Now()
+	h.lastErr = ""
+	if err != nil {
+		h.failures++
+		h.lastErr = err.Error()
+		driverMetrics.inc(metricProbeFailures, labels{"volume": name})
+	} else {
+		h.failures = 0
+	}
+	state := d.healthState(h.failures)
+	h.server, h.serverErr = server, ""
+	if serverErr != nil && serverErr != errNotMinio {
+		h.serverErr = serverErr.Error()
+	}
+	if state == healthHealthy && server != nil && server.degraded() {
+		state = healthServerDegraded
+		h.lastErr = server.String()
+	}
+	if state != h.state && h.state != "" {
+		logrus.WithFields(logrus.Fields{
+			"volume":   name,
+			"endpoint": v.config.endpoint,
+			"from":     h.state,
+			"to":       state,
+			"error":    h.lastErr,
+		}).Warn("Endpoint health changed.")
+	}
+	changed := state != h.state
+	h.state = state
+	driverMetrics.set(metricEndpointHealth, labels{"volume": name}, healthGaugeValues[state])
+	if changed && state != healthHealthy {
+		d.notify(eventUnhealthy, v, h.lastErr)
+	}
+}
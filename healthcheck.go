@@ -0,0 +1,155 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/sdk"
+)
+
+// Health check - `minfs-docker-volume healthcheck` lists the volumes through the socket of a running
+// plugin and exits with 1 if the plugin doesn't answer within `--timeout`, for instance while a request
+// holds the driver lock, or if any of the volumes is unhealthy, and with 0 otherwise. It's meant to be
+// used as the HEALTHCHECK of the plugin container or as the watchdog command of a systemd unit.
+// With `--admin-address`, the admin API has to answer too.
+
+// default of `healthcheck --timeout`.
+const defaultHealthcheckTimeout = 10 * time.Second
+
+// returns true if the command line runs the health check rather than the plugin.
+func isHealthcheck(args []string) bool {
+	return len(args) > 1 && args[1] == "healthcheck"
+}
+
+// runs the health check with the arguments following `healthcheck`, returns the exit code.
+func runHealthcheck(args []string) int {
+	defaultAddress := socketAddress
+	if os.Geteuid() != 0 {
+		defaultAddress = rootlessSocketPath(pluginName)
+	}
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	address := flags.String("address", defaultAddress, "address of the plugin, unix://<path>, tcp://<host>:<port> or the path of a unix socket.")
+	adminAddress := flags.String("admin-address", "", "address of the admin API of the plugin, not checked if empty.")
+	timeout := flags.Duration("timeout", defaultHealthcheckTimeout, "time given to the plugin to answer.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if err := validateListenAddress(*address); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	unhealthy, err := checkPluginHealth(*address, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "plugin is unresponsive: %v\n", err)
+		return 1
+	}
+	if *adminAddress != "" {
+		if err := checkAdminHealth(*adminAddress, *timeout); err != nil {
+			fmt.Fprintf(os.Stderr, "admin API is unresponsive: %v\n", err)
+			return 1
+		}
+	}
+	if len(unhealthy) > 0 {
+		fmt.Fprintf(os.Stderr, "unhealthy volumes: %s\n", strings.Join(unhealthy, ", "))
+		return 1
+	}
+	fmt.Println("healthy")
+	return 0
+}
+
+// returns an HTTP client reaching the address, a TCP address or the path of a unix socket prefixed
+// with `unix://`, and the base URL of the requests.
+func healthcheckClient(address string, timeout time.Duration) (*http.Client, string) {
+	if strings.HasPrefix(address, "tcp://") {
+		return &http.Client{Timeout: timeout}, "http://" + strings.TrimPrefix(address, "tcp://")
+	}
+	if !strings.HasPrefix(address, "unix://") && strings.Contains(address, ":") {
+		return &http.Client{Timeout: timeout}, "http://" + address
+	}
+	socket := strings.TrimPrefix(address, "unix://")
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.DialTimeout("unix", socket, timeout)
+			},
+		},
+	}, "http://plugin"
+}
+
+// lists the volumes of the plugin, returns the names of the unhealthy ones.
+func checkPluginHealth(address string, timeout time.Duration) ([]string, error) {
+	client, base := healthcheckClient(address, timeout)
+	resp, err := client.Post(base+"/VolumeDriver.List", sdk.DefaultContentTypeV1_1, strings.NewReader("{}"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("List returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	var res struct {
+		Volumes []struct {
+			Name   string
+			Status map[string]interface{}
+		}
+		Err string
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("invalid List response: %v", err)
+	}
+	if res.Err != "" {
+		return nil, fmt.Errorf("List failed: %s", res.Err)
+	}
+	var unhealthy []string
+	for _, v := range res.Volumes {
+		if healthy, ok := v.Status["healthy"].(bool); ok && !healthy {
+			unhealthy = append(unhealthy, v.Name)
+		}
+	}
+	sort.Strings(unhealthy)
+	return unhealthy, nil
+}
+
+// lists the volumes through the admin API, only the answer of the API is checked.
+func checkAdminHealth(address string, timeout time.Duration) error {
+	client, base := healthcheckClient(address, timeout)
+	resp, err := client.Get(base + "/volumes")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("GET /volumes returned %s", resp.Status)
+	}
+	return nil
+}
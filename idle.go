@@ -0,0 +1,101 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Idle unmount - The mount of a volume is released when the last container using it unmounts it. With
+// `--idle-unmount-after`, the mount is kept while the volume is idle (no container uses it) and released once
+// it has been idle for the given duration, so that the containers restarted or started again soon after reuse
+// the live mount instead of waiting for minfs. The next Mount of a volume whose idle mount was released
+// mounts it again as usual. The idle mounts are released when the plugin is drained or the volume removed.
+
+// longest interval at which the idle mounts are checked.
+const idleCheckInterval = time.Minute
+
+// shortest interval at which the idle mounts are checked, for the durations below a couple of seconds.
+const idleMinCheckInterval = time.Second
+
+// returns true if the volume is mounted but no container uses it, see `--idle-unmount-after`.
+func (v *mountInfo) idle() bool {
+	return v.connections == 0 && v.mounted()
+}
+
+// releases the mounts of the volumes idle for `--idle-unmount-after`, until the plugin exits.
+func (d *minfsDriver) unmountIdleVolumes() {
+	interval := d.idleUnmountAfter / 2
+	if interval > idleCheckInterval {
+		interval = idleCheckInterval
+	}
+	if interval < idleMinCheckInterval {
+		interval = idleMinCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.releaseIdleVolumes(d.idleUnmountAfter)
+	}
+}
+
+// releases the mounts of the volumes idle for `idleFor` or longer.
+// Must not be called with the driver lock held.
+func (d *minfsDriver) releaseIdleVolumes(idleFor time.Duration) {
+	d.RLock()
+	var names []string
+	for name, v := range d.mounts {
+		if v.idle() && time.Since(v.idleSince) >= idleFor {
+			names = append(names, name)
+		}
+	}
+	d.RUnlock()
+
+	for _, name := range names {
+		d.releaseIdleVolume(name, idleFor)
+	}
+}
+
+// releases the mount of the volume if it's still idle for `idleFor` or longer.
+// Must not be called with the driver lock held.
+func (d *minfsDriver) releaseIdleVolume(name string, idleFor time.Duration) {
+	v := d.lockVolume(name)
+	if v == nil {
+		return
+	}
+	defer v.ops.Unlock()
+	d.Lock()
+	defer d.Unlock()
+
+	// the volume may have been mounted again in the meantime.
+	if !v.idle() || time.Since(v.idleSince) < idleFor {
+		return
+	}
+	log := logrus.WithFields(logrus.Fields{
+		"volume":    name,
+		"idleSince": v.idleSince.Format(time.RFC3339),
+	})
+	if err := d.releaseVolume(v); err != nil {
+		log.Errorf("Unmounting the idle volume failed. <ERROR> %v", err)
+		return
+	}
+	log.Info("Idle volume unmounted.")
+	v.idleSince = time.Time{}
+	d.notify(eventUnmounted, v, "idle")
+}
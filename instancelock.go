@@ -0,0 +1,147 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Single instance - Two instances of the plugin serving the same mount root, or writing the same state file,
+// would unmount and overwrite each other's volumes. At startup the plugin takes an exclusive lock (flock) on
+// `<mountroot>/.minfs.lock`, and on `<state-file>.lock` with `--state-file`, and refuses to start if another
+// instance holds them, unless it's a standby (see `awaitTakeover`). The pid of the instance holding the locks
+// is written in the lock files and cleared when it exits. The locks are released by the kernel when the
+// instance dies, so the lock files left by a crashed instance are taken over on the next start, and the
+// recovery is logged with the pid of the crashed instance.
+
+// lock file of the instance serving the mount root.
+const mountRootLockFile = ".minfs.lock"
+
+// lock files held by the running instance, kept open while the plugin runs.
+var instanceLocks []*os.File
+
+// errLockHeld - returned when another instance holds one of the lock files.
+type errLockHeld struct {
+	path string
+	// pid written by the holder, 0 if unknown.
+	pid int
+}
+
+func (e errLockHeld) Error() string {
+	if e.pid == 0 {
+		return fmt.Sprintf("another instance of the plugin holds %s", e.path)
+	}
+	if syscall.Kill(e.pid, 0) == syscall.ESRCH {
+		return fmt.Sprintf("%s is held by a process started by the instance of the plugin %d which is no longer running", e.path, e.pid)
+	}
+	return fmt.Sprintf("another instance of the plugin (pid %d) holds %s", e.pid, e.path)
+}
+
+// returns the lock files of the instance serving the mount root with the state file.
+func instanceLockPaths(mountRoot, stateFile string) []string {
+	paths := []string{filepath.Join(mountRoot, mountRootLockFile)}
+	if stateFile != "" {
+		paths = append(paths, stateFile+".lock")
+	}
+	return paths
+}
+
+// reads the pid written in the lock file, 0 if there's none.
+func readLockPid(f *os.File) int {
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// takes the lock files, returns errLockHeld if another instance holds one of them. Either all the locks
+// are taken or none.
+func lockInstance(paths []string) error {
+	var files []*os.File
+	release := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for _, path := range paths {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			release()
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			release()
+			return err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			pid := readLockPid(f)
+			f.Close()
+			release()
+			if err == syscall.EWOULDBLOCK {
+				return errLockHeld{path: path, pid: pid}
+			}
+			return err
+		}
+		files = append(files, f)
+	}
+	for _, f := range files {
+		// the pid of an instance which exited cleanly is cleared, see `unlockInstance`.
+		if pid := readLockPid(f); pid != 0 && pid != os.Getpid() {
+			logrus.WithFields(logrus.Fields{
+				"lock": f.Name(),
+				"pid":  pid,
+			}).Warn("Recovered the lock left by an instance of the plugin which didn't exit cleanly.")
+		}
+		if err := writeLockPid(f, os.Getpid()); err != nil {
+			release()
+			return fmt.Errorf("unable to write %s: %v", f.Name(), err)
+		}
+	}
+	instanceLocks = files
+	return nil
+}
+
+// writes the pid in the lock file, 0 clears it.
+func writeLockPid(f *os.File, pid int) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if pid == 0 {
+		return nil
+	}
+	_, err := f.WriteAt([]byte(strconv.Itoa(pid)+"\n"), 0)
+	return err
+}
+
+// clears the pid of the lock files and releases them, before the plugin exits.
+func unlockInstance() {
+	for _, f := range instanceLocks {
+		writeLockPid(f, 0)
+		f.Close()
+	}
+	instanceLocks = nil
+}
@@ -0,0 +1,46 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mount isolation - With `-o isolate-by-mount-id=true`, every Mount request of the volume is given a
+// subdirectory of the bucket of its own, named after the ID of the mount, as its mountpoint. The containers
+// sharing the volume definition (ex: parallel CI jobs) then never see each other's files. The
+// subdirectories are kept in the bucket once the containers are gone.
+
+// returns true if the mount ID given by docker can name a subdirectory of the volume.
+func isValidMountID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, "/\x00")
+}
+
+// returns the mountpoint of the mount `id` of the volume, its subdirectory is created if it doesn't exist.
+func isolatedMountpoint(v *mountInfo, id string) (string, error) {
+	dir := filepath.Join(v.mountPoint, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating the directory of mount %s failed: %v", id, err)
+	}
+	if err := applyOwnershipTo(v.config, dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
@@ -0,0 +1,326 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Credential stores - With `--credential-store=keyring` or `--credential-store=secret-service`, the
+// credentials of the volumes are kept in a secret store of the host rather than in `--state-file`, which
+// only holds a reference to them, for the hosts where secrets can't be written to the disk. `keyring` is
+// the user keyring of the Linux kernel, which is lost when the host reboots, `secret-service` is the
+// libsecret compatible store of the host (GNOME Keyring, KeePassXC...) reached with `secret-tool`.
+// The credentials are written to the store by the checkpoints of the state file, and removed once the
+// state file no longer refers to them.
+
+// names of the credential stores, see `--credential-store`.
+const (
+	credentialStoreState         = "state"
+	credentialStoreKeyring       = "keyring"
+	credentialStoreSecretService = "secret-service"
+)
+
+// time given to `secret-tool` to access the secret service.
+const secretToolTimeout = 10 * time.Second
+
+// credentialStore - A secret store of the host keeping the credentials of the volumes out of the state file.
+type credentialStore interface {
+	// name of the store, prefix of the references of the credentials.
+	name() string
+	// writes the credentials of the volume, replacing the previous ones.
+	store(volume string, creds volumeCredentials) error
+	// reads the credentials of the volume.
+	load(volume string) (volumeCredentials, error)
+	// removes the credentials of the volume, it's not an error if they're missing.
+	remove(volume string) error
+}
+
+// returns the credential store of `--credential-store`, nil if the credentials are kept in the state file.
+func newCredentialStore(name string) (credentialStore, error) {
+	switch name {
+	case credentialStoreState:
+		return nil, nil
+	case credentialStoreKeyring:
+		return kernelKeyring{}, nil
+	case credentialStoreSecretService:
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return nil, fmt.Errorf("secret-tool is required by the secret-service credential store: %v", err)
+		}
+		return secretService{}, nil
+	}
+	return nil, fmt.Errorf("invalid credential store %q, must be %s, %s or %s", name, credentialStoreState, credentialStoreKeyring, credentialStoreSecretService)
+}
+
+// returns the reference of the credentials of the volume kept in the store, `<store>:<volume>`.
+func credentialStoreRef(store credentialStore, volume string) string {
+	return store.name() + ":" + volume
+}
+
+// writes the credentials of the volumes of the bundle exported for the state file to the credential store,
+// before the state file refers to them. The driver lock isn't held, the store can take up to
+// `secretToolTimeout` to answer.
+func (d *minfsDriver) storeBundleCredentials(bundle stateBundle) error {
+	for _, s := range bundle.Volumes {
+		if s.storeCredentials == nil {
+			continue
+		}
+		if err := d.storeCredentials(s.Name, *s.storeCredentials); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writes the credentials of the volume to the credential store, unless they're unchanged since they were
+// last written.
+func (d *minfsDriver) storeCredentials(volume string, creds volumeCredentials) error {
+	ref := credentialStoreRef(d.credentialStore, volume)
+	d.RLock()
+	stored, ok := d.storedCredentials[ref]
+	d.RUnlock()
+	if ok && stored == creds {
+		return nil
+	}
+	if err := d.credentialStore.store(volume, creds); err != nil {
+		return fmt.Errorf("writing the credentials of volume %s to the %s credential store failed: %v", volume, d.credentialStore.name(), err)
+	}
+	d.Lock()
+	d.storedCredentials[ref] = creds
+	d.Unlock()
+	return nil
+}
+
+// reads the credentials referred to by the state file from the credential store.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) loadStoredCredentials(ref string) (volumeCredentials, error) {
+	i := strings.Index(ref, ":")
+	if i < 0 {
+		return volumeCredentials{}, fmt.Errorf("invalid credential reference %q", ref)
+	}
+	if d.credentialStore == nil || d.credentialStore.name() != ref[:i] {
+		return volumeCredentials{}, fmt.Errorf("credentials are kept in the %s credential store, --credential-store=%s is required", ref[:i], ref[:i])
+	}
+	creds, err := d.credentialStore.load(ref[i+1:])
+	if err != nil {
+		return creds, fmt.Errorf("reading the credentials from the %s credential store failed: %v", ref[:i], err)
+	}
+	d.storedCredentials[ref] = creds
+	return creds, nil
+}
+
+// removes the credentials the state bundle written to the state file no longer refers to from the store.
+func (d *minfsDriver) pruneStoredCredentials(bundle stateBundle) {
+	if d.credentialStore == nil {
+		return
+	}
+	refs := make(map[string]bool, len(bundle.Volumes))
+	for _, s := range bundle.Volumes {
+		if s.CredentialStoreRef != "" {
+			refs[s.CredentialStoreRef] = true
+		}
+	}
+	d.Lock()
+	var removed []string
+	for ref := range d.storedCredentials {
+		if !refs[ref] {
+			removed = append(removed, ref)
+			delete(d.storedCredentials, ref)
+		}
+	}
+	d.Unlock()
+
+	prefix := d.credentialStore.name() + ":"
+	for _, ref := range removed {
+		if !strings.HasPrefix(ref, prefix) {
+			continue
+		}
+		if err := d.credentialStore.remove(strings.TrimPrefix(ref, prefix)); err != nil {
+			logrus.WithField("ref", ref).Errorf("Removing the credentials from the credential store failed. <ERROR> %v", err)
+		}
+	}
+}
+
+// keyctl operations, see keyctl(2).
+const (
+	keyctlUnlink = 9
+	keyctlSearch = 10
+	keyctlRead   = 11
+)
+
+// special ID of the keyring of the user of the process.
+const keySpecUserKeyring = -4
+
+// kernelKeyring - Keeps the credentials as `user` keys of the user keyring of the Linux kernel.
+type kernelKeyring struct{}
+
+func (kernelKeyring) name() string {
+	return credentialStoreKeyring
+}
+
+// returns the description of the key of the volume.
+func keyDescription(volume string) string {
+	return pluginName + ":" + volume
+}
+
+// adds the key of the volume to the user keyring, add_key(2) updates the payload of an existing key.
+func (kernelKeyring) store(volume string, creds volumeCredentials) error {
+	payload, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	keyType, desc, err := keyStrings(volume)
+	if err != nil {
+		return err
+	}
+	keyring := int32(keySpecUserKeyring)
+	_, _, errno := syscall.Syscall6(syscall.SYS_ADD_KEY, uintptr(unsafe.Pointer(keyType)), uintptr(unsafe.Pointer(desc)),
+		uintptr(unsafe.Pointer(&payload[0])), uintptr(len(payload)), uintptr(keyring), 0)
+	if errno != 0 {
+		return fmt.Errorf("add_key failed: %v", errno)
+	}
+	return nil
+}
+
+func (k kernelKeyring) load(volume string) (volumeCredentials, error) {
+	var creds volumeCredentials
+	id, err := k.search(volume)
+	if err != nil {
+		return creds, err
+	}
+	// the first read returns the size of the payload.
+	size, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlRead, uintptr(id), 0, 0, 0, 0)
+	if errno != 0 {
+		return creds, fmt.Errorf("reading key %s failed: %v", keyDescription(volume), errno)
+	}
+	payload := make([]byte, size)
+	if size > 0 {
+		_, _, errno = syscall.Syscall6(syscall.SYS_KEYCTL, keyctlRead, uintptr(id), uintptr(unsafe.Pointer(&payload[0])), size, 0, 0)
+		if errno != 0 {
+			return creds, fmt.Errorf("reading key %s failed: %v", keyDescription(volume), errno)
+		}
+	}
+	err = json.Unmarshal(payload, &creds)
+	return creds, err
+}
+
+func (k kernelKeyring) remove(volume string) error {
+	id, err := k.search(volume)
+	if err == errKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keyring := int32(keySpecUserKeyring)
+	if _, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlUnlink, uintptr(id), uintptr(keyring), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("unlinking key %s failed: %v", keyDescription(volume), errno)
+	}
+	return nil
+}
+
+// returned by `search` when the user keyring has no key for the volume.
+var errKeyNotFound = fmt.Errorf("key not found in the user keyring")
+
+// returns the ID of the key of the volume in the user keyring.
+func (kernelKeyring) search(volume string) (int32, error) {
+	keyType, desc, err := keyStrings(volume)
+	if err != nil {
+		return 0, err
+	}
+	keyring := int32(keySpecUserKeyring)
+	id, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlSearch, uintptr(keyring), uintptr(unsafe.Pointer(keyType)),
+		uintptr(unsafe.Pointer(desc)), 0, 0)
+	if errno == syscall.ENOKEY {
+		return 0, errKeyNotFound
+	}
+	if errno != 0 {
+		return 0, fmt.Errorf("searching key %s failed: %v", keyDescription(volume), errno)
+	}
+	return int32(id), nil
+}
+
+// returns the type and the description of the key of the volume as C strings.
+func keyStrings(volume string) (*byte, *byte, error) {
+	keyType, err := syscall.BytePtrFromString("user")
+	if err != nil {
+		return nil, nil, err
+	}
+	desc, err := syscall.BytePtrFromString(keyDescription(volume))
+	return keyType, desc, err
+}
+
+// secretService - Keeps the credentials in the libsecret compatible store of the host with `secret-tool`,
+// the secrets are looked up by the `service` and `volume` attributes.
+type secretService struct{}
+
+func (secretService) name() string {
+	return credentialStoreSecretService
+}
+
+// runs secret-tool with the attributes of the volume, `stdin` is the secret written by `store`.
+func secretTool(op, volume string, stdin []byte, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretToolTimeout)
+	defer cancel()
+	args = append(append([]string{op}, args...), "service", "minfs-docker-volume", "volume", volume)
+	cmd := exec.CommandContext(ctx, "secret-tool", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("secret-tool %s failed: %v %s", op, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (secretService) store(volume string, creds volumeCredentials) error {
+	payload, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	_, err = secretTool("store", volume, payload, "--label=minfs volume "+volume)
+	return err
+}
+
+func (secretService) load(volume string) (volumeCredentials, error) {
+	var creds volumeCredentials
+	out, err := secretTool("lookup", volume, nil)
+	if err != nil {
+		return creds, err
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return creds, fmt.Errorf("no secret for volume %s", volume)
+	}
+	err = json.Unmarshal(out, &creds)
+	return creds, err
+}
+
+func (secretService) remove(volume string) error {
+	_, err := secretTool("clear", volume, nil)
+	return err
+}
@@ -0,0 +1,136 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Lifecycle - With `-o expiry-days=<days>`, the objects of the bucket expire on the server the given number of
+// days after they're written, and with `-o expiry-rules=<prefix>=<days>,...` the objects under the given
+// prefixes do, so that scratch volumes clean themselves up. The rules are set as the lifecycle configuration
+// of the bucket when the plugin creates it, the lifecycle of an existing bucket is left untouched.
+
+// expiryRule - Expiration rule of the lifecycle of the bucket.
+type expiryRule struct {
+	// prefix of the objects expiring, all the objects of the bucket if empty.
+	prefix string
+	days   int
+}
+
+// lifecycleConfiguration - body of the PUT bucket lifecycle request.
+type lifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LifecycleConfiguration"`
+	Rules   []lifecycleRule `xml:"Rule"`
+}
+
+// lifecycleRule - rule of the lifecycle configuration.
+type lifecycleRule struct {
+	ID         string `xml:"ID"`
+	Prefix     string `xml:"Filter>Prefix"`
+	Status     string `xml:"Status"`
+	ExpireDays int    `xml:"Expiration>Days"`
+}
+
+// parses a number of days of the expiry options.
+func parseExpiryDays(option, value string) (int, error) {
+	days, err := strconv.Atoi(value)
+	if err != nil || days <= 0 {
+		return 0, fmt.Errorf("invalid number of days %q in %s option, must be a positive integer.", value, option)
+	}
+	return days, nil
+}
+
+// parses the `expiry-days` and `expiry-rules` options of the create request into the expiration rules of
+// the bucket, nil if neither is set.
+func parseExpiryOptions(options map[string]string) ([]expiryRule, error) {
+	var rules []expiryRule
+	if value := options["expiry-days"]; value != "" {
+		days, err := parseExpiryDays("expiry-days", value)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, expiryRule{days: days})
+	}
+	if value := options["expiry-rules"]; value != "" {
+		seen := make(map[string]bool)
+		for _, spec := range strings.Split(value, ",") {
+			spec = strings.TrimSpace(spec)
+			i := strings.LastIndex(spec, "=")
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid rule %q in expiry-rules option, must be <prefix>=<days>.", spec)
+			}
+			days, err := parseExpiryDays("expiry-rules", spec[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			prefix := strings.TrimPrefix(spec[:i], "/")
+			if seen[prefix] {
+				return nil, fmt.Errorf("prefix %s is repeated in expiry-rules option.", prefix)
+			}
+			seen[prefix] = true
+			rules = append(rules, expiryRule{prefix: prefix, days: days})
+		}
+	}
+	return rules, nil
+}
+
+// formats the prefix scoped expiration rules as the expiry-rules option, the rule of the whole bucket
+// is the expiry-days option.
+func formatExpiryRules(rules []expiryRule) (days int, prefixRules string) {
+	var specs []string
+	for _, r := range rules {
+		if r.prefix == "" {
+			days = r.days
+			continue
+		}
+		specs = append(specs, r.prefix+"="+strconv.Itoa(r.days))
+	}
+	return days, strings.Join(specs, ",")
+}
+
+// sets the expiration rules of the volume as the lifecycle configuration of its bucket.
+func setBucketLifecycle(config serverConfig) error {
+	if len(config.expiry) == 0 {
+		return nil
+	}
+	lifecycle := lifecycleConfiguration{}
+	for i, r := range config.expiry {
+		lifecycle.Rules = append(lifecycle.Rules, lifecycleRule{
+			ID:         fmt.Sprintf("minfs-expiry-%d", i+1),
+			Prefix:     r.prefix,
+			Status:     "Enabled",
+			ExpireDays: r.days,
+		})
+	}
+	body, err := xml.Marshal(lifecycle)
+	if err != nil {
+		return err
+	}
+	_, err = s3Do(config, "PUT", url.Values{"lifecycle": {""}}, config.region, nil, body)
+	return err
+}
+
+// removes the empty bucket of the volume the plugin just created, when it couldn't be set up.
+func removeNewBucket(config serverConfig) error {
+	_, err := s3Do(config, "DELETE", nil, config.region, nil, nil)
+	return err
+}
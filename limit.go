@@ -0,0 +1,76 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+// Concurrent mounts - With `--max-concurrent-mounts`, the mounts starting minfs wait in a queue for
+// one of the mount slots, so that mass container restarts don't start dozens of minfs processes at once.
+// The queued mounts don't hold the driver lock, the other requests are served while they wait.
+
+// returns the channel of the mount slots, nil if the mounts are not limited.
+func newMountSlots(max int) chan struct{} {
+	if max <= 0 {
+		return nil
+	}
+	return make(chan struct{}, max)
+}
+
+// returns true if mounting the volume starts minfs, the volumes already in use only count the new connection.
+func (d *minfsDriver) needsMount(name string) bool {
+	d.RLock()
+	defer d.RUnlock()
+
+	v, ok := d.mounts[name]
+	return ok && v.connections == 0 && v.config.snapshot.IsZero()
+}
+
+// waits for a free mount slot, the returned function releases it.
+// Must not be called with the driver lock held.
+func (d *minfsDriver) acquireMountSlot(name string) func() {
+	if d.mountSlots == nil || !d.needsMount(name) {
+		return func() {}
+	}
+	driverMetrics.add(metricMountsQueued, nil, 1)
+	d.mountSlots <- struct{}{}
+	driverMetrics.add(metricMountsQueued, nil, -1)
+	return func() { <-d.mountSlots }
+}
+
+// Volume count - With `--max-volumes`, creating a volume fails with `quota-exceeded` once the plugin
+// holds the maximum number of volumes, so that a single host can't accumulate unbounded FUSE mounts.
+// The number of volumes is exported as `minfs_volumes`.
+
+// returns an error if no more volumes can be created.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) checkVolumeQuota() error {
+	if d.maxVolumes > 0 && len(d.mounts) >= d.maxVolumes {
+		return newCodedError(errQuotaExceeded, "maximum number of volumes (%d) reached, see --max-volumes.", d.maxVolumes)
+	}
+	return nil
+}
+
+// updates the metrics of the number of volumes and of the volumes mounted by containers.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) countVolumes() {
+	driverMetrics.set(metricVolumes, nil, float64(len(d.mounts)))
+	mounted := 0
+	for _, v := range d.mounts {
+		if v.connections > 0 {
+			mounted++
+		}
+	}
+	driverMetrics.set(metricActiveMounts, nil, float64(mounted))
+}
@@ -0,0 +1,74 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/go-plugins-helpers/sdk"
+)
+
+// listenFlags - values of the repeatable `--listen` flag.
+// The driver is served on every address, sharing the same volumes and state.
+type listenFlags []string
+
+func (f *listenFlags) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *listenFlags) Set(value string) error {
+	if err := validateListenAddress(value); err != nil {
+		return err
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// validates a listen address, `unix://<path>`, `tcp://<host>:<port>` or the path of a unix socket.
+func validateListenAddress(address string) error {
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		if !strings.Contains(strings.TrimPrefix(address, "tcp://"), ":") {
+			return fmt.Errorf("invalid listen address %q, must be tcp://<host>:<port>", address)
+		}
+	case strings.HasPrefix(address, "unix://"):
+		if strings.TrimPrefix(address, "unix://") == "" {
+			return fmt.Errorf("invalid listen address %q, must be unix://<path>", address)
+		}
+	case strings.Contains(address, "://"):
+		return fmt.Errorf("invalid listen address %q, the scheme must be unix or tcp", address)
+	}
+	return nil
+}
+
+// serves the handler on the address until it fails.
+// Docker finds the driver served over TCP through the spec file /etc/docker/plugins/minfs.spec.
+func serveListener(h sdk.Handler, address string) error {
+	if strings.HasPrefix(address, "tcp://") {
+		return h.ServeTCP(pluginName, strings.TrimPrefix(address, "tcp://"), nil)
+	}
+	path := strings.TrimPrefix(address, "unix://")
+	if rootlessMode {
+		if !filepath.IsAbs(path) {
+			path = rootlessSocketPath(path)
+		}
+		return serveRootlessUnix(h, path)
+	}
+	return h.ServeUnix(path, 0)
+}
@@ -17,18 +17,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/go-plugins-helpers/volume"
-	"github.com/minio/minio-go"
 )
 
 // Used for Plugin discovery.
@@ -37,6 +41,8 @@ import (
 // A unix server is started at the `socketAdress` to enable discovery of this plugin by docker.
 const (
 	socketAddress = "/run/docker/plugins/minfs.sock"
+	// name of the plugin in the spec file written when the driver is served over TCP.
+	pluginName = "minfs"
 
 	defaultLocation = "us-east-1"
 )
@@ -52,12 +58,92 @@ const (
 type serverConfig struct {
 	// Endpoint of the remote Minio server.
 	endpoint string
+	// all the endpoints of the volume if several are set, `endpoint` is the active one, see `failover`.
+	endpoints []string
 	// `minfs` mounts the remote bucket to a the local `mountpoint`.
 	bucket string
 	// accessKey of the remote minio server.
 	accessKey string
 	// secretKey of the remote Minio server.
 	secretKey string
+	// files the credentials are read from, reloaded on SIGHUP. Empty if the credentials are passed as options.
+	accessKeyFile string
+	secretKeyFile string
+	// credential provider the credentials are fetched from and the reference of the secret
+	// (ex: the path of a Vault secret), empty if the credentials are passed as options.
+	credentialProvider string
+	credentialRef      string
+	// region of the bucket, the requests are signed for it and the bucket is created in it if it doesn't exist.
+	region string
+	// enable object locking (WORM) on the bucket if it's created by the plugin.
+	objectLocking bool
+	// access the bucket without credentials, for public buckets.
+	anonymous bool
+	// the credentials are those of a service account minted for the volume, deleted with it, see `addServiceAccount`.
+	serviceAccount bool
+	// serve the bucket read only as it was at this time, zero for the live bucket.
+	snapshot time.Time
+	// S3 signature version (v2 or v4), chosen by minio-go for the endpoint if empty.
+	signature string
+	// bucket addressing style (path or virtual-host), path if empty.
+	addressing string
+	// cache consistency mode of minfs (strict or cached), the minfs defaults are used if empty.
+	consistency string
+	// see the changes made by other clients, minfs is run with the strict consistency.
+	watchChanges bool
+	// mount propagation of the mountpoint (private, rshared or rslave), inherited from the mount root if empty.
+	propagation string
+	// SELinux context of the mount, `auto` for the context shared by all the containers, see `selinuxLabel`.
+	selinuxLabel string
+	// owner (`<uid>[:<gid>]`) and octal mode applied to the root of the mountpoint after the mount, unchanged if empty.
+	owner string
+	mode  string
+	// umask of the files created in the volume, passed to minfs, the minfs default is used if empty.
+	umask string
+	// keep the minfs cache on an ephemeral encrypted device, see `setupCache`.
+	encryptCache bool
+	// type (tmpfs) and size of the filesystem the minfs cache is kept on, see `setupCache`.
+	cacheType string
+	cacheSize string
+	// prefixes of the objects read in the background once the volume is mounted, see `startPrefetch`.
+	prefetch []string
+	// wait for minfs to upload the buffered writes before unmounting, see `flushUploads`.
+	flushOnUnmount bool
+	// every mount is given a subdirectory of the bucket named after its ID, see `isolatedMountpoint`.
+	isolateByMountID bool
+	// memory limit and CPU quota of the minfs process, override `--minfs-memory-limit` and `--minfs-cpu-quota`.
+	memoryLimit string
+	cpuQuota    string
+	// root of the mountpoint of the volume, overrides `--mountroot`.
+	mountRoot string
+	// keep the cache directory when the volume is removed.
+	retainCache bool
+	// delete the bucket when the volume is removed, see `purgeBucket`.
+	purgeOnRemove bool
+	// delete the objects of a non empty bucket purged on remove.
+	forcePurge bool
+	// KMS key the objects written to the bucket are encrypted with, see `sseHeaders`.
+	sseKMSKeyID string
+	// storage class of the objects written to the bucket, see `writeHeaders`.
+	storageClass string
+	// expiration rules set as the lifecycle of the bucket if it's created by the plugin, see `setBucketLifecycle`.
+	expiry []expiryRule
+	// anonymous access policy set on the bucket if it's created by the plugin, see `setBucketPolicy`.
+	bucketPolicy string
+	// log level of minfs, see `minfsOptions`.
+	minfsLogLevel string
+	// quota of the bucket in bytes, zero without quota, and the action taken once it's exceeded, see `checkQuotas`.
+	quota       int64
+	quotaAction string
+	// addresses of host names used instead of resolving them, see `resolveHost`.
+	hostOverrides map[string]string
+	// buckets of a union volume, the first one is the bucket of the volume, see `mountUnion`.
+	union []unionBucket
+	// HTTP(S) proxy of the requests to the endpoint and the hosts reached directly, see `proxyFunc`.
+	proxy   string
+	noProxy string
+	// file the requests to the endpoint are traced to with `-o s3-trace=true`, not traced if empty, see `tracingTransport`.
+	traceFile string
 }
 
 // Represents an instance of `minfs` mount of remote Minio bucket.
@@ -66,6 +152,10 @@ type serverConfig struct {
 //   - The local mountpoint.
 //   - The number of connections alive for the mount (No.Of.Services still using the mount point).
 type mountInfo struct {
+	// lock of the operations of the volume, see `lockVolume`.
+	ops *sync.Mutex
+	// name of the volume.
+	name       string
 	config     serverConfig
 	mountPoint string
 	// the number of containers using the mount.
@@ -73,6 +163,379 @@ type mountInfo struct {
 	// unmount is done only if the number of connections is 0.
 	// otherwise just the count is decreased.
 	connections int
+	// IDs of the active mounts given by docker, and names of the containers using the volume.
+	mountIDs   map[string]bool
+	containers []string
+	// the supervised minfs process serving the mount, nil when not mounted.
+	proc *minfsProcess
+	// number of times minfs was restarted after exiting unexpectedly.
+	restarts int
+	// number of consecutive crashes, used to compute the restart backoff.
+	failures int
+	// time and reason of the last unexpected exit of minfs.
+	lastExit    time.Time
+	lastExitErr string
+	// last lines of output of the minfs process.
+	output *outputTail
+	// set when an empty directory is mounted since the bucket doesn't exist (`--on-missing-bucket=mount-empty`).
+	bucketMissing bool
+	// reference of the credentials the volume was restored without since the credential store doesn't
+	// have them, until they're set again, see `stateVolumes`.
+	missingCredentials string
+	// volume the bucket is cloned from in the background, nil once cloned, see `startClone`.
+	clone *cloneSource
+	// times of the snapshots taken of the volume, see `takeSnapshot`.
+	snapshots []time.Time
+	// set once the objects of a snapshot volume have been fetched, see `restoreSnapshot`.
+	snapshotRestored bool
+	// set while the restored objects of a snapshot volume are bind mounted at its mountpoint.
+	snapshotMounted bool
+	// shared minfs mount the mountpoint is bound to, see `--share-mounts`.
+	shared *mountInfo
+	// mounts of the buckets of a union volume merged at the mountpoint, see `mountUnion`.
+	union []*mountInfo
+	// time at which the volume was created.
+	createdAt time.Time
+	// time of the last mount of the volume by a container, zero if it was never mounted.
+	lastMounted time.Time
+	// time at which the last container unmounted the volume whose mount is kept, see `--idle-unmount-after`.
+	idleSince time.Time
+	// alias of the driver the volume was created with, empty for the main driver.
+	driver string
+	// health of the endpoint, see `probeEndpoints`.
+	health endpointHealth
+	// time until which the credentials fetched from the credential provider can be used.
+	credentialsExpire time.Time
+	// time and steps of the last unmount, see `unmountVolume`.
+	lastUnmount  time.Time
+	unmountSteps []string
+	// cache directory of minfs, removed with the volume, see `setupCache`.
+	cacheDir string
+	// managed cache mounted at the cache directory while the volume is mounted.
+	cache *volumeCache
+	// previous minfs processes still serving the containers started before a staged remount, see `stagedRemountVolume`.
+	previous []*minfsProcess
+	// progress of the prefetch of the last mount, nil if the volume has no prefixes to prefetch.
+	prefetch *prefetchProgress
+	// set when the bucket is mounted read only since the writes are denied, see `checkWriteAccess`.
+	readOnly bool
+	// usage of the bucket of a volume with a quota, see `checkQuotas`.
+	quota volumeQuota
+}
+
+// returns true if the bucket of the volume is mounted at its mountpoint, by minfs, a shared mount or a union.
+func (v *mountInfo) mounted() bool {
+	return v.proc != nil || v.shared != nil || v.union != nil || v.snapshotMounted
+}
+
+// status of the mount reported to docker with the volume info (`docker volume inspect`).
+func (v *mountInfo) status() map[string]interface{} {
+	status := map[string]interface{}{
+		"mounted":     v.mounted(),
+		"healthy":     v.healthy(),
+		"connections": v.connections,
+		"restarts":    v.restarts,
+		"createdAt":   v.createdAt.Format(time.RFC3339),
+	}
+	if len(v.mountIDs) > 0 {
+		status["mounts"] = v.activeMounts()
+	}
+	if len(v.containers) > 0 {
+		status["containers"] = v.containers
+	}
+	if v.driver != "" {
+		status["driver"] = v.driver
+	}
+	if v.idle() {
+		status["idleSince"] = v.idleSince.Format(time.RFC3339)
+	}
+	if !v.lastMounted.IsZero() {
+		status["lastMounted"] = v.lastMounted.Format(time.RFC3339)
+	}
+	proc := v.proc
+	if v.shared != nil {
+		status["sharedMount"] = v.shared.mountPoint
+		proc = v.shared.proc
+	}
+	if len(v.config.union) > 0 {
+		status["buckets"] = formatUnionBuckets(v.config.union)
+	}
+	if v.union != nil {
+		status["unionMounts"] = v.unionStatus()
+	}
+	if proc != nil && proc.container != "" {
+		status["container"] = proc.container
+	} else if proc != nil {
+		status["pid"] = proc.pid
+	}
+	if len(v.previous) > 0 {
+		pids := make([]int, 0, len(v.previous))
+		for _, p := range v.previous {
+			pids = append(pids, p.pid)
+		}
+		status["previousPids"] = pids
+	}
+	if v.config.region != defaultLocation {
+		status["region"] = v.config.region
+	}
+	if v.config.anonymous {
+		status["anonymous"] = true
+	}
+	if v.config.serviceAccount {
+		status["serviceAccount"] = v.config.accessKey
+	}
+	if v.config.credentialProvider != "" {
+		status["credentialProvider"] = v.config.credentialProvider
+		status["credentialsExpire"] = v.credentialsExpire.Format(time.RFC3339)
+	}
+	if v.bucketMissing {
+		status["bucketMissing"] = true
+	}
+	if v.missingCredentials != "" {
+		status["missingCredentials"] = v.missingCredentials
+	}
+	if v.clone != nil {
+		status["clonePendingFrom"] = v.clone.volume
+		status["cloneObjectsCopied"] = v.clone.copied
+		if v.clone.err != nil {
+			status["cloneError"] = v.clone.err.Error()
+		}
+	}
+	if v.config.consistency != "" {
+		status["consistency"] = v.config.consistency
+	}
+	if v.config.watchChanges {
+		status["watchChanges"] = true
+	}
+	if v.config.encryptCache {
+		status["encryptCache"] = true
+	}
+	if v.config.cacheType != "" {
+		status["cache"] = v.config.cacheType
+		if v.config.cacheSize != "" {
+			status["cacheSize"] = v.config.cacheSize
+		}
+	}
+	if len(v.config.prefetch) > 0 {
+		status["prefetchPrefixes"] = v.config.prefetch
+	}
+	if v.prefetch != nil {
+		status["prefetch"] = v.prefetch.status()
+	}
+	if v.config.flushOnUnmount {
+		status["flushOnUnmount"] = true
+	}
+	if v.config.isolateByMountID {
+		status["isolateByMountID"] = true
+	}
+	if v.config.memoryLimit != "" {
+		status["memoryLimit"] = v.config.memoryLimit
+	}
+	if v.config.cpuQuota != "" {
+		status["cpuQuota"] = v.config.cpuQuota
+	}
+	if v.config.mountRoot != "" {
+		status["mountRoot"] = v.config.mountRoot
+	}
+	if v.cacheDir != "" {
+		status["cacheDir"] = v.cacheDir
+	}
+	if v.config.retainCache {
+		status["retainCache"] = true
+	}
+	if v.config.sseKMSKeyID != "" {
+		status["sseKMSKeyID"] = v.config.sseKMSKeyID
+	}
+	if v.config.storageClass != "" {
+		status["storageClass"] = v.config.storageClass
+	}
+	if v.config.bucketPolicy != "" {
+		status["bucketPolicy"] = v.config.bucketPolicy
+	}
+	if v.config.traceFile != "" {
+		status["s3TraceFile"] = v.config.traceFile
+	}
+	if len(v.config.expiry) > 0 {
+		days, rules := formatExpiryRules(v.config.expiry)
+		if days > 0 {
+			status["expiryDays"] = days
+		}
+		if rules != "" {
+			status["expiryRules"] = rules
+		}
+	}
+	if v.config.minfsLogLevel != "" {
+		status["minfsLogLevel"] = v.config.minfsLogLevel
+	}
+	if len(v.config.hostOverrides) > 0 {
+		status["hostOverrides"] = formatHostOverrides(v.config.hostOverrides)
+	}
+	if v.config.proxy != "" {
+		status["proxy"] = redactProxy(v.config.proxy)
+		if v.config.noProxy != "" {
+			status["noProxy"] = v.config.noProxy
+		}
+	}
+	if v.config.purgeOnRemove {
+		status["purgeOnRemove"] = true
+		status["forcePurge"] = v.config.forcePurge
+	}
+	if n := v.pendingUploads(); n >= 0 {
+		status["pendingUploads"] = n
+	}
+	if v.config.propagation != "" {
+		status["propagation"] = v.config.propagation
+	}
+	if label := selinuxLabel(v.config); label != "" {
+		status["selinuxLabel"] = label
+	}
+	if v.config.owner != "" {
+		status["owner"] = v.config.owner
+	}
+	if v.config.mode != "" {
+		status["mode"] = v.config.mode
+	}
+	if v.config.umask != "" {
+		status["umask"] = v.config.umask
+	}
+	if !v.config.snapshot.IsZero() {
+		status["snapshot"] = v.config.snapshot.Format(time.RFC3339)
+		status["readOnly"] = true
+	}
+	if v.readOnly {
+		status["readOnly"] = true
+		status["readOnlyReason"] = "writes to the bucket are denied to the credentials of the volume"
+	} else if v.quotaReadOnly() {
+		status["readOnly"] = true
+		status["readOnlyReason"] = "the quota of the volume is exceeded"
+	}
+	if v.config.quota > 0 {
+		status["quota"] = v.config.quota
+		status["quotaAction"] = v.config.quotaAction
+		if !v.quota.checked.IsZero() {
+			status["usage"] = v.quota.usage
+			status["quotaExceeded"] = v.quota.exceeded
+			status["usageChecked"] = v.quota.checked.Format(time.RFC3339)
+		}
+		if v.quota.lastErr != "" {
+			status["usageError"] = v.quota.lastErr
+		}
+	}
+	if len(v.snapshots) > 0 {
+		snapshots := make([]string, 0, len(v.snapshots))
+		for _, t := range v.snapshots {
+			snapshots = append(snapshots, t.Format(time.RFC3339))
+		}
+		status["snapshots"] = snapshots
+	}
+	if v.health.state != "" {
+		status["endpointHealth"] = v.health.state
+		status["lastProbe"] = v.health.lastProbe.Format(time.RFC3339)
+		if v.health.lastErr != "" {
+			status["lastProbeError"] = v.health.lastErr
+		}
+		if v.health.server != nil {
+			status["server"] = v.health.server.status()
+		}
+		if v.health.serverErr != "" {
+			status["serverInfoError"] = v.health.serverErr
+		}
+	}
+	// the steps are only reported when the unmount had to be escalated.
+	if len(v.unmountSteps) > 1 {
+		status["lastUnmount"] = v.lastUnmount.Format(time.RFC3339)
+		status["unmountSteps"] = v.unmountSteps
+	}
+	if !v.lastExit.IsZero() {
+		status["lastExit"] = v.lastExit.Format(time.RFC3339)
+		status["lastExitError"] = v.lastExitErr
+	}
+	if lines := v.output.tail(); len(lines) > 0 {
+		status["minfsOutput"] = lines
+	}
+	return status
+}
+
+// pluginConfig - plugin level configuration, set using the flags passed when starting the plugin server.
+type pluginConfig struct {
+	// root folder under which the volumes are mounted.
+	mountRoot string
+	// path to the minfs executable.
+	minfsBinary string
+	// path to the mergerfs executable merging the buckets of the union volumes.
+	mergerfsBinary string
+	// path to the mc executable managing the service accounts of the volumes.
+	mcBinary string
+	// number of lines of minfs output retained per volume.
+	outputLines int
+	// time the mount of a volume no container uses is kept for, see `--idle-unmount-after`.
+	idleUnmountAfter time.Duration
+	// if set, minfs is run in helper containers of this image instead of on the host.
+	minfsImage string
+	// path of the Docker daemon API socket, used to manage the helper containers.
+	dockerSocket string
+	// policy for volumes referring to a missing bucket (fail, create or mount-empty).
+	onMissingBucket string
+	// default S3 signature version of the volumes.
+	signature string
+	// operating mode of the plugin, strict or permissive.
+	mode string
+	// endpoints volumes are allowed to point to, all endpoints are allowed if nil.
+	allowedEndpoints *endpointAllowlist
+	// directories volumes can be mounted under, `-o mount-root` is refused if empty.
+	allowedMountRoots mountRootAllowlist
+	// directory the `-o access-key-file` and `-o secret-key-file` of the volumes are relative to.
+	credentialFilesDir string
+	// authorizers of the Create and Remove requests, all requests are allowed if empty.
+	authz authorizers
+	// sends the events of the volumes to the event webhook, no events are sent if nil.
+	events *eventNotifier
+	// encrypts the credentials of the exported volumes, export and import are disabled if nil.
+	stateCipher *stateCipher
+	// keeps the credentials of the volumes out of the state file, the state file holds them if nil.
+	credentialStore credentialStore
+	// serve the volumes mounting the same bucket with a single minfs mount.
+	shareMounts bool
+	// remount the mounted volumes by moving a new mount over their mountpoint.
+	stagedRemount bool
+	// number of consecutive failed probes after which an endpoint is degraded or unreachable.
+	probeDegradedAfter    int
+	probeUnreachableAfter int
+	// maximum number of mounts starting minfs at once, unlimited if 0.
+	maxConcurrentMounts int
+	// time given to each unmount step before escalating.
+	unmountTimeout time.Duration
+	// time given to minfs to mount the bucket.
+	mountTimeout time.Duration
+	// interval at which the mount of minfs is checked while it starts.
+	mountPollInterval time.Duration
+	// time after which the commands of a request are cancelled.
+	requestTimeout time.Duration
+	// secret stores the credentials of the volumes can be fetched from, keyed by name.
+	providers map[string]credentialProvider
+	// credentials of the admin API of the MinIO servers, the admin API isn't used if nil.
+	minioAdmin *minioAdmin
+	// time the credentials of a provider are cached for if their secret has no lease.
+	credentialRefreshInterval time.Duration
+	// size in bytes of the encrypted caches of the volumes.
+	encryptedCacheSize int64
+	// time an unmount waits for the pending uploads of a volume with `flush-on-unmount`.
+	flushTimeout time.Duration
+	// default memory limit and CPU quota of the minfs processes, unlimited if empty.
+	minfsMemoryLimit string
+	minfsCPUQuota    string
+	// user the minfs processes run as, nil to run them as root.
+	runAs *runAsUser
+	// verify the write access of the volumes, see `--check-write-access`.
+	checkWrites bool
+	// accept the bucket names following the legacy S3 naming rules.
+	legacyBucketNames bool
+	// default values of the options of the volumes.
+	defaultOptions map[string]string
+	// maximum number of volumes, unlimited if 0.
+	maxVolumes int
+	// file the state of the volumes is kept in, not kept if empty.
+	stateFile string
 }
 
 // minfsDriver - The struct implements the `github.com/docker/go-plugins-helpers/volume.Driver` interface.
@@ -81,7 +544,7 @@ type mountInfo struct {
 //    In our case the struct `minfsDriver` implements the interface.
 // 2. Create a new instance of `minfsDriver` and register it with the `go-plugin-helper`.
 //    `go-plugin-helper` is a tool built to make development of docker plugins easier, visit https://github.com/docker/go-plugins-helpers/.
-//     The registration is done using https://godoc.org/github.com/docker/go-plugins-helpers/volume#NewHandler .
+//     The requests are served with the handler of the plugin, see `newVolumeHandler`.
 // 3. Docker interacts with the plugin server via HTTP endpoints whose
 //    protocols defined here https://docs.docker.com/engine/extend/plugins_volume/#/volumedrivercreate.
 // 4. Once registered the implemented methods on `minfsDriver` are called whenever docker
@@ -91,6 +554,102 @@ type minfsDriver struct {
 	// used for atomic access to the fields.
 	sync.RWMutex
 	mountRoot string
+	// minfs executable used to serve the mounts.
+	minfsBinary string
+	// mergerfs executable merging the buckets of the union volumes, see `mountUnion`.
+	mergerfsBinary string
+	// mc executable managing the service accounts of the volumes, see `runMc`.
+	mcBinary string
+	// number of lines of minfs output retained per volume.
+	outputLines int
+	// time the mount of a volume no container uses is kept for, released on the last unmount if 0.
+	idleUnmountAfter time.Duration
+	// image of the helper containers running minfs, empty if minfs runs on the host.
+	minfsImage string
+	// client of the Docker API used to manage the helper containers.
+	docker *dockerClient
+	// client of the Docker API used to look up the containers using the volumes.
+	dockerAPI *dockerClient
+	// policy for volumes referring to a missing bucket, see `--on-missing-bucket`.
+	onMissingBucket string
+	// default S3 signature version of the volumes, see `--signature`.
+	signature string
+	// default operating mode of the volumes, see `--mode`.
+	mode string
+	// endpoints volumes are allowed to point to, see `--allowed-endpoints`.
+	allowedEndpoints *endpointAllowlist
+	// directories volumes are allowed to be mounted under with `-o mount-root`, see `--allowed-mount-roots`.
+	allowedMountRoots mountRootAllowlist
+	// directory the credential files of the volumes are read from, see `--credential-files-dir`.
+	credentialFilesDir string
+	// authorizers of the Create and Remove requests, see `--auth-token-file` and `--authz-webhook`.
+	authz authorizers
+	// sends the events of the volumes to `--event-webhook`, nil if not set.
+	events *eventNotifier
+	// encrypts the credentials of the exported volumes, see `--state-key-file`.
+	stateCipher *stateCipher
+	// keeps the credentials of the volumes out of the state file, see `--credential-store`.
+	credentialStore credentialStore
+	// credentials written to the credential store, keyed by reference, see `storeCredentials`.
+	storedCredentials map[string]volumeCredentials
+	// serve the volumes mounting the same bucket with a single minfs mount, see `--share-mounts`.
+	shareMounts bool
+	// remount the mounted volumes by moving a new mount over their mountpoint, see `remountVolume`.
+	stagedRemount bool
+	// shared minfs mounts, keyed by `sharedMountKey`.
+	shared map[string]*mountInfo
+	// workers processing the Mount, Unmount and Remove requests of the volumes, see `dispatch`.
+	workers *volumeWorkers
+	// new volumes and mounts are refused while draining, see the `/drain` admin API.
+	draining bool
+	// request of docker holding the lock, see `beginRequest`.
+	req *request
+	// number of requests of docker being served, waited for by `stop`.
+	requests int
+	// thresholds of the endpoint health, see `--probe-degraded-after` and `--probe-unreachable-after`.
+	probeDegradedAfter    int
+	probeUnreachableAfter int
+	// slots of the mounts starting minfs, nil if unlimited, see `--max-concurrent-mounts`.
+	mountSlots chan struct{}
+	// time given to each unmount step before escalating, see `--unmount-timeout`.
+	unmountTimeout time.Duration
+	// time given to minfs to mount the bucket, see `--mount-timeout`.
+	mountTimeout time.Duration
+	// interval at which the mount of minfs is checked while it starts, see `--mount-poll-interval`.
+	mountPollInterval time.Duration
+	// time after which the commands of a request are cancelled, see `--request-timeout`.
+	requestTimeout time.Duration
+	// context of the plugin, cancelled when it's stopped, see `stop`.
+	stopCtx    context.Context
+	cancelStop context.CancelFunc
+	// credential providers, see `--vault-address`.
+	providers map[string]credentialProvider
+	// credentials of the MinIO admin API, see `--minio-admin-access-key-file`.
+	minioAdmin *minioAdmin
+	// time the credentials of a provider are cached for if their secret has no lease, see `--credential-refresh-interval`.
+	credentialRefreshInterval time.Duration
+	// size in bytes of the encrypted caches of the volumes, see `--encrypted-cache-size`.
+	encryptedCacheSize int64
+	// time an unmount waits for the pending uploads of a volume, see `--flush-timeout`.
+	flushTimeout time.Duration
+	// default memory limit and CPU quota of the minfs processes, see `--minfs-memory-limit` and `--minfs-cpu-quota`.
+	minfsMemoryLimit string
+	minfsCPUQuota    string
+	// user the minfs processes run as, see `--run-as-user`.
+	runAs *runAsUser
+	// mount the buckets the volumes can't write to read only, see `--check-write-access`.
+	checkWrites bool
+	// accept the bucket names following the legacy S3 naming rules, see `--legacy-bucket-names`.
+	legacyBucketNames bool
+	// default values of the options of the volumes, see `--default-opt`.
+	defaultOptions map[string]string
+	// maximum number of volumes, see `--max-volumes`.
+	maxVolumes int
+	// file the state of the volumes is kept in, see `--state-file`.
+	stateFile string
+	// set when the state changed since it was last written, and signaled to write it right away.
+	stateDirty bool
+	stateFlush chan struct{}
 	// config of the remote Minio server.
 	config serverConfig
 	// the local path to which the remote Minio bucket is mounted to.
@@ -103,14 +662,62 @@ type minfsDriver struct {
 }
 
 // return a new instance of minfsDriver.
-func newMinfsDriver(mountRoot string) *minfsDriver {
-	logrus.WithField("method", "new minfs driver").Debug(mountRoot)
+func newMinfsDriver(cfg pluginConfig) *minfsDriver {
+	logrus.WithField("method", "new minfs driver").Debugf("%#v", cfg)
 
 	d := &minfsDriver{
-		mountRoot: mountRoot,
-		config:    serverConfig{},
-		mounts:    make(map[string]*mountInfo),
+		mountRoot:                 cfg.mountRoot,
+		minfsBinary:               cfg.minfsBinary,
+		mergerfsBinary:            cfg.mergerfsBinary,
+		mcBinary:                  cfg.mcBinary,
+		outputLines:               cfg.outputLines,
+		idleUnmountAfter:          cfg.idleUnmountAfter,
+		minfsImage:                cfg.minfsImage,
+		onMissingBucket:           cfg.onMissingBucket,
+		signature:                 cfg.signature,
+		mode:                      cfg.mode,
+		allowedEndpoints:          cfg.allowedEndpoints,
+		allowedMountRoots:         cfg.allowedMountRoots,
+		credentialFilesDir:        cfg.credentialFilesDir,
+		authz:                     cfg.authz,
+		events:                    cfg.events,
+		stateCipher:               cfg.stateCipher,
+		credentialStore:           cfg.credentialStore,
+		storedCredentials:         make(map[string]volumeCredentials),
+		shareMounts:               cfg.shareMounts,
+		stagedRemount:             cfg.stagedRemount,
+		shared:                    make(map[string]*mountInfo),
+		probeDegradedAfter:        cfg.probeDegradedAfter,
+		probeUnreachableAfter:     cfg.probeUnreachableAfter,
+		mountSlots:                newMountSlots(cfg.maxConcurrentMounts),
+		unmountTimeout:            cfg.unmountTimeout,
+		mountTimeout:              cfg.mountTimeout,
+		mountPollInterval:         cfg.mountPollInterval,
+		requestTimeout:            cfg.requestTimeout,
+		providers:                 cfg.providers,
+		minioAdmin:                cfg.minioAdmin,
+		credentialRefreshInterval: cfg.credentialRefreshInterval,
+		encryptedCacheSize:        cfg.encryptedCacheSize,
+		flushTimeout:              cfg.flushTimeout,
+		minfsMemoryLimit:          cfg.minfsMemoryLimit,
+		minfsCPUQuota:             cfg.minfsCPUQuota,
+		runAs:                     cfg.runAs,
+		checkWrites:               cfg.checkWrites,
+		legacyBucketNames:         cfg.legacyBucketNames,
+		defaultOptions:            cfg.defaultOptions,
+		maxVolumes:                cfg.maxVolumes,
+		stateFile:                 cfg.stateFile,
+		stateFlush:                make(chan struct{}, 1),
+		config:                    serverConfig{},
+		mounts:                    make(map[string]*mountInfo),
+		dockerAPI:                 newDockerClient(cfg.dockerSocket),
+		workers:                   newVolumeWorkers(),
 	}
+	d.stopCtx, d.cancelStop = context.WithCancel(context.Background())
+	if cfg.minfsImage != "" {
+		d.docker = newDockerClient(cfg.dockerSocket)
+	}
+	d.countVolumes()
 
 	return d
 }
@@ -126,104 +733,396 @@ func newMinfsDriver(mountRoot string) *minfsDriver {
 // The remote bucket will be mounted at `mountRoot + volumeName`.
 // mountRoot is passed as `--mountroot` flag when starting the plugin server.
 func (d *minfsDriver) Create(r volume.Request) volume.Response {
-	logrus.WithField("method", "Create").Debugf("%#v", r)
+	return d.createVolume(r, "")
+}
+
+// creates the volume for the driver alias `driver`, see `--alias`.
+func (d *minfsDriver) createVolume(r volume.Request, driver string) (res volume.Response) {
+	req := newRequest("Create", r.Name)
+	req.log.Debugf("%#v", r)
+	// the lock of an existing volume is held until the request is served, see `lockVolume`.
+	locked := d.lockVolume(r.Name)
+	if locked != nil {
+		defer locked.ops.Unlock()
+	}
 	// hold lock for safe access.
 	d.Lock()
 	defer d.Unlock()
+	d.beginRequest(req)
+	defer func() { res = d.endRequest(req, res) }()
 	// validate the inputs.
 	// verify that the name of the volume is not empty.
 	if r.Name == "" {
-		return errorResponse("Name of the driver cannot be empty.Use `$ docker volume create -d <plugin-name> --name <volume-name>`")
+		return errorResponse(errBadOption, "Name of the driver cannot be empty.Use `$ docker volume create -d <plugin-name> --name <volume-name>`")
+	}
+	// the options of the request take precedence over the defaults of the plugin.
+	r.Options = d.withDefaultOptions(r.Options)
+	// the errors of the checks are left to Mount in permissive mode (`--mode`, `-o create-mode`).
+	mode, err := d.createMode(r.Options)
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	// the unknown options are ignored in permissive mode.
+	if mode == modePermissive {
+		for _, name := range unknownOptions(r.Options) {
+			req.log.WithFields(logrus.Fields{"volume": r.Name, "option": name}).Warn("Unknown option ignored in permissive mode.")
+			delete(r.Options, name)
+		}
+	}
+	// reject the options which are not supported, see `volumeOptions`.
+	if err := validateOptions(r.Options); err != nil {
+		return errorResponse(errBadOption, err.Error())
 	}
 	// if the volume is already created verify that the server configs match.
 	// If not return with error.
 	// Since the plugin system identifies a mount uniquely by its name,
 	// its not possible to create a duplicate volume pointing to a different Minio server or bucket.
 	if mntInfo, ok := d.mounts[r.Name]; ok {
+		// `-o rotate-credentials=true` sets the credentials of the existing volume.
+		rotate, err := parseBoolOption(r.Options, "rotate-credentials")
+		if err != nil {
+			return errorResponse(errBadOption, err.Error())
+		}
+		if rotate {
+			if mntInfo != locked {
+				return errorResponse(errBadOption, fmt.Sprintf("volume %s was created by another request in the meantime.", r.Name))
+			}
+			if err := d.unlocked(func(context.Context) error { return d.authorize("Create", r.Name, r.Options) }); err != nil {
+				return errorResponse(errAuthFailed, err.Error())
+			}
+			if err := d.recreateWithCredentials(mntInfo, r.Options); err != nil {
+				return errorResponseOf(err)
+			}
+			return volume.Response{}
+		}
 		// Since the volume by the given name already exists,
 		// match to see whether the endpoint, bucket, accessKey and secretKey of the
 		// new  request and the existing entry match.
 		// return error on mismatch.
 		// else return with success message,
 		// Since the volume already exists no need to proceed further.
-		err := matchServerConfig(mntInfo.config, r)
+		err = matchServerConfig(mntInfo.config, r)
 		if err != nil {
-			return errorResponse(err.Error())
+			return errorResponse(errBadOption, err.Error())
 		}
 		// return success since the volume exists and the configs match.
 		return volume.Response{}
 	}
 
+	if d.draining {
+		return errorResponse(errUnavailable, "plugin is draining for maintenance, no volumes can be created, retry later.")
+	}
+	if err := d.checkVolumeQuota(); err != nil {
+		return errorResponseOf(err)
+	}
 	// verify that all the options are set when the volume is created.
 	if r.Options == nil {
-		return errorResponse("No options provided. Please refer example usage.")
+		return errorResponse(errBadOption, "No options provided. Please refer example usage.")
+	}
+	// verify that the request is authorized (`--auth-token-file`, `--authz-webhook`).
+	if err := d.unlocked(func(context.Context) error { return d.authorize("Create", r.Name, r.Options) }); err != nil {
+		return errorResponse(errAuthFailed, err.Error())
+	}
+	// a volume cloned from an existing volume inherits its endpoint and credentials,
+	// the objects of the source bucket are copied before the first mount.
+	var clone *cloneSource
+	if src := r.Options["clone-from"]; src != "" {
+		srcInfo, ok := d.mounts[src]
+		if !ok {
+			return errorResponse(errNotFound, fmt.Sprintf("clone-from volume %s not found", src))
+		}
+		options, err := cloneOptions(srcInfo.config, r.Options)
+		if err != nil {
+			return errorResponse(errBadOption, err.Error())
+		}
+		r.Options = options
+		clone = &cloneSource{volume: src, config: srcInfo.config}
+	}
+	// with `-o wait-for-endpoint=<duration>` the checks of the endpoint are retried while it's starting.
+	wait, err := parseWaitForEndpoint(r.Options)
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	// several endpoints of a highly available deployment can be set, comma separated.
+	endpoints := splitEndpoints(r.Options["endpoint"])
+	if len(endpoints) == 0 {
+		return errorResponse(errBadOption, "endpoint option cannot be empty.")
 	}
-	if r.Options["endpoint"] == "" {
-		return errorResponse("endpoint option cannot be empty.")
+	var hostOverrides map[string]string
+	if option, ok := r.Options["host-override"]; ok {
+		var err error
+		if hostOverrides, err = parseHostOverrides(option); err != nil {
+			return errorResponse(errBadOption, err.Error())
+		}
 	}
+	// a union volume mounts several buckets, the first one is the bucket of the volume.
+	var union []unionBucket
+	if option, ok := r.Options["buckets"]; ok {
+		if r.Options["bucket"] != "" {
+			return errorResponse(errBadOption, "bucket and buckets options cannot be combined.")
+		}
+		var err error
+		if union, err = parseUnionBuckets(option, !d.legacyBucketNames); err != nil {
+			return errorResponse(errBadOption, err.Error())
+		}
+		r.Options["bucket"] = union[0].bucket
+	}
+	// the bucket defaults to the name of the volume, created as per the `--on-missing-bucket` policy.
 	if r.Options["bucket"] == "" {
-		return errorResponse("bucket option cannot be empty.")
+		bucket := bucketNameFromVolume(r.Name)
+		if err := validateBucketName(bucket, true); err != nil {
+			return errorResponse(errBadOption, fmt.Sprintf("bucket option is not set and the name of the volume doesn't make a valid bucket name: %v", err))
+		}
+		r.Options["bucket"] = bucket
 	}
-	if r.Options["access-key"] == "" {
-		return errorResponse("access-key option cannot be empty")
+	// the credentials can be read from files.
+	if err := d.credentialFileOptions(r.Options); err != nil {
+		return errorResponse(errBadOption, err.Error())
 	}
-	if r.Options["secret-key"] == "" {
-		return errorResponse("secret-key cannot be empty.")
+	// or fetched from a credential provider.
+	var provider, providerRef string
+	var credentialsExpire time.Time
+	err = d.unlocked(func(context.Context) (err error) {
+		provider, providerRef, credentialsExpire, err = d.credentialProviderOptions(r.Options)
+		return err
+	})
+	if err != nil {
+		return errorResponseOf(err)
+	}
+	// credentials are not required to mount public buckets anonymously.
+	anonymous, err := parseBoolOption(r.Options, "anonymous")
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	// with the MinIO admin credentials, a service account is minted for the volumes created without credentials.
+	serviceAccount := !anonymous && d.minioAdmin != nil && r.Options["access-key"] == "" && r.Options["secret-key"] == ""
+	if !anonymous && !serviceAccount && r.Options["access-key"] == "" {
+		return errorResponse(errBadOption, "access-key option cannot be empty")
+	}
+	if !anonymous && !serviceAccount && r.Options["secret-key"] == "" {
+		return errorResponse(errBadOption, "secret-key cannot be empty.")
+	}
+	if anonymous && (r.Options["access-key"] != "" || r.Options["secret-key"] != "") {
+		return errorResponse(errBadOption, "access-key and secret-key cannot be set for anonymous volumes.")
 	}
 
-	mntInfo := &mountInfo{}
+	mntInfo := &mountInfo{
+		ops:               new(sync.Mutex),
+		name:              r.Name,
+		output:            newOutputTail(r.Name, d.outputLines),
+		clone:             clone,
+		createdAt:         time.Now().UTC(),
+		driver:            driver,
+		credentialsExpire: credentialsExpire,
+	}
 	config := serverConfig{}
 
-	// Additional options passed with `-o` option are parsed here.
-	config.endpoint = r.Options["endpoint"]
+	// Additional options passed with `-o` option are parsed here, and validated once the config is complete,
+	// see `validateConfig`.
+	config.endpoint = endpoints[0]
+	if len(endpoints) > 1 {
+		config.endpoints = endpoints
+	}
 	config.bucket = r.Options["bucket"]
 	config.secretKey = r.Options["secret-key"]
 	config.accessKey = r.Options["access-key"]
-
-	// find out whether the scheme of the URL is HTTPS.
-	enableSSL, err := isSSL(config.endpoint)
+	config.accessKeyFile = r.Options["access-key-file"]
+	config.secretKeyFile = r.Options["secret-key-file"]
+	config.credentialProvider, config.credentialRef = provider, providerRef
+	// region of the bucket, required by S3 compatible servers other than Minio (ex: AWS S3, Ceph RGW, Wasabi).
+	config.region = r.Options["region"]
+	if config.region == "" {
+		config.region = defaultLocation
+	}
+	config.objectLocking, err = parseBoolOption(r.Options, "object-locking")
 	if err != nil {
-		logrus.Error("Please send a valid URL of form http(s)://my-minio.com:9000 <ERROR> ", err.Error())
-		return errorResponse(err.Error())
+		return errorResponse(errBadOption, err.Error())
 	}
-
-	minioHost, err := getHost(config.endpoint)
+	config.anonymous = anonymous
+	config.snapshot, err = parseSnapshotOption(r.Options)
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	// the signature version defaults to `--signature`.
+	config.signature = d.signature
+	if signature, ok := r.Options["signature"]; ok {
+		config.signature = signature
+	}
+	config.addressing = r.Options["addressing"]
+	config.consistency = r.Options["consistency"]
+	config.watchChanges, err = parseBoolOption(r.Options, "watch-changes")
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	config.propagation = r.Options["propagation"]
+	config.selinuxLabel = r.Options["selinux-label"]
+	config.owner, config.mode = r.Options["owner"], r.Options["mode"]
+	config.umask = r.Options["umask"]
+	config.encryptCache, err = parseBoolOption(r.Options, "encrypt-cache")
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	config.cacheType, config.cacheSize, err = parseCacheOption(r.Options["cache"])
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	config.retainCache, err = parseBoolOption(r.Options, "retain-cache")
 	if err != nil {
-		logrus.Error("Please send a valid URL of form http(s)://my-minio.com:9000 <ERROR> ", err.Error())
-		return errorResponse(err.Error())
+		return errorResponse(errBadOption, err.Error())
 	}
-	// Verify if the bucket exists.
-	// If it doesnt exist create the bucket on the remote Minio server.
-	// Initialize minio client object.
-	minioClient, err := minio.New(minioHost, config.accessKey, config.secretKey, enableSSL)
+	config.purgeOnRemove, err = parseBoolOption(r.Options, "purge-on-remove")
 	if err != nil {
-		logrus.Errorf("Error creating new Minio client. <Error> %s", err.Error())
-		return errorResponse(err.Error())
+		return errorResponse(errBadOption, err.Error())
 	}
-	// Create a bucket.
-	err = minioClient.MakeBucket(config.bucket, defaultLocation)
+	config.forcePurge, err = parseBoolOption(r.Options, "force-purge")
 	if err != nil {
-		// Check to see if we already own this bucket.
-		exists, eErr := minioClient.BucketExists(config.bucket)
-		if eErr == nil && exists {
-			// bucket already exists log and return with success.
-			logrus.WithFields(logrus.Fields{
-				"endpoint": config.endpoint,
-				"bucket":   config.bucket,
-			}).Info("Bucket already exisits.")
-		} else {
-			// return with error response to docker daemon.
-			logrus.WithFields(logrus.Fields{
-				"endpoint": config.endpoint,
-				"bucket":   config.bucket,
-			}).Fatal(err.Error())
-			return errorResponse(err.Error())
+		return errorResponse(errBadOption, err.Error())
+	}
+	config.sseKMSKeyID = r.Options["sse-kms-key-id"]
+	config.storageClass = r.Options["storage-class"]
+	config.expiry, err = parseExpiryOptions(r.Options)
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	config.bucketPolicy = r.Options["bucket-policy"]
+	config.minfsLogLevel = r.Options["minfs-log-level"]
+	config.quota, config.quotaAction, err = parseQuotaOptions(r.Options["quota"], r.Options["quota-action"])
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	config.hostOverrides = hostOverrides
+	config.union = union
+	config.proxy, config.noProxy = r.Options["proxy"], r.Options["no-proxy"]
+	s3Trace, err := parseBoolOption(r.Options, "s3-trace")
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	if s3Trace {
+		config.traceFile = d.tracePath(r.Name, config)
+	}
+	config.prefetch, err = parsePrefetchOption(r.Options["prefetch"])
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	config.flushOnUnmount, err = parseBoolOption(r.Options, "flush-on-unmount")
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	config.isolateByMountID, err = parseBoolOption(r.Options, "isolate-by-mount-id")
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	config.memoryLimit, config.cpuQuota = r.Options["memory-limit"], r.Options["cpu-quota"]
+	if root := r.Options["mount-root"]; root != "" {
+		config.mountRoot = filepath.Clean(root)
+	}
+	// with `-o dry-run=true` the volume is validated but not registered.
+	dryRun, err := parseBoolOption(r.Options, "dry-run")
+	if err != nil {
+		return errorResponse(errBadOption, err.Error())
+	}
+	if err := d.validateConfig(r.Name, config, clone != nil); err != nil {
+		return errorResponseOf(err)
+	}
+	// an endpoint of a highly available deployment is selected once the endpoints are validated.
+	if len(config.endpoints) > 0 {
+		err = d.waitForEndpoint(r.Name, wait, func(context.Context) error {
+			endpoint, err := selectEndpoint(config)
+			if err == nil {
+				config.endpoint = endpoint
+			}
+			return err
+		})
+		if err := d.deferCheck(mode, r.Name, err); err != nil {
+			return errorResponseOf(err)
+		}
+	}
+
+	if !config.snapshot.IsZero() {
+		var enabled bool
+		err := d.waitForEndpoint(r.Name, wait, func(context.Context) (err error) {
+			enabled, err = bucketVersioningEnabled(config)
+			return err
+		})
+		if err != nil {
+			if err := d.deferCheck(mode, r.Name, err); err != nil {
+				return errorResponse(errorCodeOf(err), fmt.Sprintf("unable to verify the versioning of bucket %s: %v", config.bucket, err))
+			}
+		} else if !enabled {
+			return errorResponse(errBadOption, fmt.Sprintf("bucket %s is not versioned, snapshots are not available.", config.bucket))
+		}
+	} else if dryRun {
+		// the service account is not minted for a dry run, the bucket is checked with the admin credentials.
+		if serviceAccount {
+			config.accessKey, config.secretKey = d.minioAdmin.accessKey, d.minioAdmin.secretKey
+		}
+		err := d.waitForEndpoint(r.Name, wait, func(ctx context.Context) error {
+			if err := d.checkBucket(ctx, config); err != nil {
+				return err
+			}
+			return d.ensureUnionBuckets(ctx, config, true)
+		})
+		if err != nil {
+			return errorResponseOf(err)
 		}
+	} else {
+		if serviceAccount {
+			var accessKey, secretKey string
+			err := d.waitForEndpoint(r.Name, wait, func(ctx context.Context) (err error) {
+				accessKey, secretKey, err = d.addServiceAccount(ctx, config, clone)
+				return err
+			})
+			if err != nil {
+				return errorResponse(errorCodeOf(err), fmt.Sprintf("unable to create the service account of volume %s: %v", r.Name, err))
+			}
+			config.accessKey, config.secretKey, config.serviceAccount = accessKey, secretKey, true
+			req.log.WithFields(logrus.Fields{"volume": r.Name, "accessKey": accessKey}).Info("Service account created.")
+			// the service account is deleted if the volume isn't created.
+			defer func() {
+				if res.Err == "" {
+					return
+				}
+				if err := d.unlocked(func(ctx context.Context) error { return d.removeServiceAccount(ctx, config) }); err != nil {
+					req.log.WithField("accessKey", accessKey).Errorf("Deleting the service account of the volume failed. <ERROR> %v", err)
+				}
+			}()
+		}
+		// Verify if the bucket exists.
+		// A missing bucket is handled as per the `--on-missing-bucket` policy.
+		validated := time.Now()
+		var exists bool
+		err := d.waitForEndpoint(r.Name, wait, func(ctx context.Context) (err error) {
+			if exists, err = d.ensureBucket(ctx, config); err == nil {
+				err = d.ensureUnionBuckets(ctx, config, false)
+			}
+			return err
+		})
+		observeDuration(metricBucketCheckSeconds, r.Name, validated)
+		if err != nil {
+			if err := d.deferCheck(mode, r.Name, err); err != nil {
+				return errorResponseOf(err)
+			}
+		} else if exists {
+			// mount the bucket read only if the credentials can't write to it.
+			mntInfo.config = config
+			if err := d.deferCheck(mode, r.Name, d.checkWriteAccess(mntInfo)); err != nil {
+				return errorResponseOf(err)
+			}
+		}
+	}
+	if dryRun {
+		req.log.WithFields(logrus.Fields{
+			"volume":   r.Name,
+			"endpoint": config.endpoint,
+			"bucket":   config.bucket,
+		}).Info("Dry run, volume is valid.")
+		return volume.Response{}
 	}
 	// mountpoint is the local path where the remote bucket is mounted.
 	// `mountroot` is passed as an argument while starting the server with `--mountroot` option.
 	// the given bucket is mounted locally at path `mountroot + volume (r.Name is the name of the volume passed by docker when a volume is created).
-	mountpoint := filepath.Join(d.mountRoot, r.Name)
+	// the volume can have a mount root of its own with `-o mount-root`.
+	mountpoint := filepath.Join(d.volumeMountRoot(config), r.Name)
 	// cache the info.
 	mntInfo.mountPoint = mountpoint
 	// `Create` is the only function which has the abiility to pass additional options.
@@ -231,9 +1130,20 @@ func (d *minfsDriver) Create(r volume.Request) volume.Response {
 	// the server config info which is required for the mount later is also passed as an option during create.
 	// This has to be cached for further usage.
 	mntInfo.config = config
+	mntInfo.cacheDir = d.cachePath(mntInfo)
 	// `r.Name` contains the plugin name passed with `--name` in `$ docker volume create -d <plugin-name> --name <volume-name>`.
 	// Name of the volume uniquely identifies the mount.
+	// the lock is released while the endpoint is checked, the volume may have been created in the meantime.
+	if _, ok := d.mounts[r.Name]; ok {
+		return errorResponse(errBadOption, fmt.Sprintf("volume %s was created by another request while waiting for its endpoint.", r.Name))
+	}
 	d.mounts[r.Name] = mntInfo
+	d.countVolumes()
+	d.markDirty()
+	d.notify(eventCreated, mntInfo, "")
+	if mntInfo.clone != nil {
+		d.startClone(mntInfo)
+	}
 	return volume.Response{}
 }
 
@@ -241,56 +1151,115 @@ func (d *minfsDriver) Create(r volume.Request) volume.Response {
 // This request is issued when a user invokes `docker rm -v` to remove volumes associated with a container.
 // Protocol doc: https://docs.docker.com/engine/extend/plugins_volume/#/volumedriverremove
 func (d *minfsDriver) Remove(r volume.Request) volume.Response {
-	logrus.WithField("method", "remove").Debugf("%#v", r)
+	return d.dispatch(r.Name, func() volume.Response { return d.remove(r) })
+}
+
+// serves the Remove request in the worker of the volume.
+func (d *minfsDriver) remove(r volume.Request) (res volume.Response) {
+	req := newRequest("Remove", r.Name)
+	req.log.Debugf("%#v", r)
 
+	// the lock of the volume is held until the request is served, see `lockVolume`.
+	v := d.lockVolume(r.Name)
+	if v != nil {
+		defer v.ops.Unlock()
+	}
 	d.Lock()
 	defer d.Unlock()
+	d.beginRequest(req)
+	defer func() { res = d.endRequest(req, res) }()
 
-	v, ok := d.mounts[r.Name]
 	// volume doesn't exist in the entry.
 	// log and return error to docker daemon.
-	if !ok {
-		logrus.WithFields(logrus.Fields{
+	if v == nil {
+		req.log.WithFields(logrus.Fields{
 			"operation": "Remove",
 			"volume":    r.Name,
 		}).Error("Volume not found.")
-		return errorResponse(fmt.Sprintf("volume %s not found", r.Name))
+		return errorResponse(errNotFound, fmt.Sprintf("volume %s not found", r.Name))
+	}
+	// verify that the request is authorized (`--authz-webhook`).
+	if err := d.unlocked(func(context.Context) error { return d.authorize("Remove", r.Name, nil) }); err != nil {
+		return errorResponse(errAuthFailed, err.Error())
 	}
 	// The volume should be under use by any other containers.
 	// verify if the number of connections is 0.
 	if v.connections == 0 {
+		// the mount kept while the volume was idle is released first.
+		if v.idle() {
+			if err := d.releaseVolume(v); err != nil {
+				return errorResponse(errInternal, err.Error())
+			}
+		}
+		// the copy of the bucket of a cloned volume is stopped.
+		if v.clone != nil {
+			v.clone.cancel()
+		}
+		// the volume is kept if its bucket can't be purged, so that the removal can be retried.
+		if _, err := d.purgeBucket(v); err != nil {
+			return errorResponseOf(err)
+		}
+		// and if its service account can't be deleted.
+		if v.config.serviceAccount {
+			if err := d.unlocked(func(ctx context.Context) error { return d.removeServiceAccount(ctx, v.config) }); err != nil {
+				return errorResponse(errorCodeOf(err), fmt.Sprintf("unable to delete the service account %s of volume %s: %v", v.config.accessKey, r.Name, err))
+			}
+		}
 		// if the count of existing connections is 0, delete the entry for the volume.
-		if err := os.RemoveAll(v.mountPoint); err != nil {
-			return errorResponse(err.Error())
+		// the mountpoint under a mount root of the volume is only removed recursively if it still
+		// resolves under the allowed mount roots, otherwise only the empty directory is removed.
+		if v.config.mountRoot != "" {
+			if err := d.allowedMountRoots.verify(v.config.mountRoot, v.name); err != nil {
+				req.log.WithField("mountpoint", v.mountPoint).Warnf("Mountpoint of the volume not removed. <ERROR> %v", err)
+			} else if err := os.RemoveAll(v.mountPoint); err != nil {
+				return errorResponse(errInternal, err.Error())
+			}
+		} else if err := os.RemoveAll(v.mountPoint); err != nil {
+			return errorResponse(errInternal, err.Error())
 		}
 		// Delete the entry for the mount.
 		delete(d.mounts, r.Name)
+		d.countVolumes()
+		d.flushState()
+		d.notify(eventRemoved, v, "")
+		driverMetrics.forget(labels{"volume": r.Name})
+		// the cache directory would otherwise fill the disk over time, as would the restored objects of a snapshot.
+		removeCacheDir(v)
+		if !v.config.snapshot.IsZero() {
+			os.RemoveAll(d.snapshotPath(v))
+		}
+		if v.config.traceFile != "" {
+			os.Remove(v.config.traceFile)
+		}
 		return volume.Response{}
 	}
 	// volume is being used by one or more containers.
 	// log and return error to docker daemon.
-	logrus.WithFields(logrus.Fields{
+	req.log.WithFields(logrus.Fields{
 		"volume": r.Name,
 	}).Errorf("Volume is currently used by %d containers. ", v.connections)
 
-	return errorResponse(fmt.Sprintf("volume %s is currently under use.", r.Name))
+	return errorResponse(errMountBusy, fmt.Sprintf("volume %s is currently under use.", r.Name))
 }
 
 // *minfsDriver.Path - Respond with the path on the host filesystem where the bucket mount has been made available.
 // protocol doc: https://docs.docker.com/engine/extend/plugins_volume/#/volumedriverpath
-func (d *minfsDriver) Path(r volume.Request) volume.Response {
-	logrus.WithField("method", "path").Debugf("%#v", r)
+func (d *minfsDriver) Path(r volume.Request) (res volume.Response) {
+	req := newRequest("Path", r.Name)
+	req.log.Debugf("%#v", r)
+	defer func() { res = req.response(res) }()
 
+	// only reads the state, see `Get`.
 	d.RLock()
 	defer d.RUnlock()
 
 	v, ok := d.mounts[r.Name]
 	if !ok {
-		logrus.WithFields(logrus.Fields{
+		req.log.WithFields(logrus.Fields{
 			"operation": "path",
 			"volume":    r.Name,
 		}).Error("Volume not found.")
-		return errorResponse(fmt.Sprintf("volume %s not found", r.Name))
+		return errorResponse(errNotFound, fmt.Sprintf("volume %s not found", r.Name))
 	}
 
 	return volume.Response{Mountpoint: v.mountPoint}
@@ -315,122 +1284,325 @@ func (d *minfsDriver) Path(r volume.Request) volume.Response {
 // in the local path of `mountroot + profile-pic-store`.
 // Note: mountroot passed as --mountroot flag while starting the plugin server.
 func (d *minfsDriver) Mount(r volume.MountRequest) volume.Response {
-	logrus.WithField("method", "mount").Debugf("%#v", r)
+	return d.dispatch(r.Name, func() volume.Response { return d.mount(r) })
+}
+
+// serves the Mount request in the worker of the volume.
+func (d *minfsDriver) mount(r volume.MountRequest) (res volume.Response) {
+	req := newRequest("Mount", r.Name)
+	req.log.Debugf("%#v", r)
+	// the time waiting for a mount slot is part of the duration of the mount.
+	start := time.Now()
+
+	// wait for a mount slot (`--max-concurrent-mounts`) before taking the locks.
+	release := d.acquireMountSlot(r.Name)
+	defer release()
 
+	// the lock of the volume is held until the request is served, see `lockVolume`.
+	v := d.lockVolume(r.Name)
+	if v != nil {
+		defer v.ops.Unlock()
+	}
 	d.Lock()
 	defer d.Unlock()
+	d.beginRequest(req)
+	defer func() { res = d.endRequest(req, res) }()
 	// verify if the volume exists.
 	// Mount operation should be performed only after creating the bucket.
-	v, ok := d.mounts[r.Name]
-	if !ok {
-		logrus.WithFields(logrus.Fields{
+	if v == nil {
+		req.log.WithFields(logrus.Fields{
 			"operation": "mount",
 			"volume":    r.Name,
 		}).Error("Volume not found.")
-		return errorResponse(fmt.Sprintf("volume %s not found", r.Name))
+		return errorResponse(errNotFound, fmt.Sprintf("volume %s not found", r.Name))
+	}
+	defer observeDuration(metricMountSeconds, r.Name, start)
+	// every mount is given a subdirectory of its own, named after its ID.
+	if v.config.isolateByMountID && !isValidMountID(r.ID) {
+		return errorResponse(errBadOption, fmt.Sprintf("volume %s is isolated by mount ID, the mount ID %q cannot name a directory.", r.Name, r.ID))
+	}
+	// track the mount once it succeeded.
+	defer func() {
+		if res.Err == "" {
+			d.trackMount(v, r.ID)
+		}
+	}()
+	// runs before the mount is tracked, a mount failing here is not tracked and is rolled back.
+	if v.config.isolateByMountID {
+		defer func() {
+			if res.Err != "" {
+				return
+			}
+			var mountpoint string
+			err := d.unlocked(func(context.Context) (err error) {
+				mountpoint, err = isolatedMountpoint(v, r.ID)
+				return err
+			})
+			if err != nil {
+				d.rollbackMount(v)
+				res = errorResponse(errInternal, err.Error())
+				return
+			}
+			res.Mountpoint = mountpoint
+		}()
 	}
 
+	// the mount root of the volume may have been replaced by a symlink since the volume was created.
+	if v.config.mountRoot != "" {
+		if err := d.allowedMountRoots.verify(v.config.mountRoot, v.name); err != nil {
+			return errorResponse(errBadOption, err.Error())
+		}
+	}
 	// create the directory for the mountpoint.
 	// This will be the directory at which the remote bucket will be mounted.
 	err := createDir(v.mountPoint)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
+		req.log.WithFields(logrus.Fields{
 			"mountpount": v.mountPoint,
-		}).Fatalf("Error creating directory for the mountpoint. <ERROR> %v.", err)
-		return errorResponse(err.Error())
+		}).Errorf("Error creating directory for the mountpoint. <ERROR> %v.", err)
+		return errorResponse(errInternal, err.Error())
+	}
+	// the volumes which are already mounted can still be used while draining.
+	if d.draining && v.connections == 0 {
+		return errorResponse(errUnavailable, fmt.Sprintf("plugin is draining for maintenance, volume %s cannot be mounted, retry later.", r.Name))
 	}
 	// If the mountpoint is already under use just increment the counter of usage and return to docker daemon.
 	if v.connections > 0 {
 		v.connections++
+		v.lastMounted = time.Now()
 		return volume.Response{Mountpoint: v.mountPoint}
 	}
+	// the volume was restored without its credentials, see `stateVolumes`.
+	if v.missingCredentials != "" {
+		return errorResponse(errAuthFailed, fmt.Sprintf("credentials %s of volume %s are missing from the credential store, set them with -o rotate-credentials=true.", v.missingCredentials, r.Name))
+	}
+	// the mount kept while the volume was idle is used again.
+	if v.idle() {
+		req.log.WithFields(logrus.Fields{"volume": r.Name, "idleSince": v.idleSince.Format(time.RFC3339)}).Debug("Idle mount reused.")
+		v.connections = 1
+		v.idleSince = time.Time{}
+		v.lastMounted = time.Now()
+		return volume.Response{Mountpoint: v.mountPoint}
+	}
+	// the bucket is mounted for the first container using the volume.
+	defer func() {
+		if res.Err == "" {
+			d.notify(eventMounted, v, "")
+		}
+	}()
 
-	// set access-key and secret-key as env variables.
-	os.Setenv("MINFS_ACCESS_KEY", v.config.accessKey)
-	os.Setenv("MINFS_SECRET_KEY", v.config.secretKey)
+	// snapshot volumes are served from the objects fetched by the plugin, bind mounted read only without minfs.
+	if !v.config.snapshot.IsZero() {
+		dir := d.snapshotPath(v)
+		if !v.snapshotRestored {
+			err := d.unlocked(func(ctx context.Context) error {
+				_, err := restoreSnapshot(ctx, v, dir)
+				return err
+			})
+			if err != nil {
+				return errorResponse(errorCodeOf(err), fmt.Sprintf("restoring snapshot of volume %s failed: %v", v.name, err))
+			}
+			v.snapshotRestored = true
+		}
+		// the restored objects are relabeled and owned as the volume before the mount makes them read only.
+		if err := d.unlocked(func(ctx context.Context) error { return relabelPath(ctx, v.config, dir) }); err != nil {
+			return errorResponse(errInternal, err.Error())
+		}
+		if err := d.unlocked(func(context.Context) error { return applyOwnershipTo(v.config, dir) }); err != nil {
+			return errorResponse(errInternal, err.Error())
+		}
+		if err := d.unlocked(func(ctx context.Context) error { return mountSnapshot(ctx, v, dir) }); err != nil {
+			return errorResponse(errInternal, err.Error())
+		}
+		v.snapshotMounted = true
+		v.connections = 1
+		v.lastMounted = time.Now()
+		return volume.Response{Mountpoint: v.mountPoint}
+	}
+	// use a reachable endpoint if the volume has several.
+	d.failover(v)
+	// fetch the credentials from the credential provider again if they expired.
+	if err := d.refreshProviderCredentials(v); err != nil {
+		return errorResponseOf(err)
+	}
+	// verify that the bucket still exists, it's created or an empty directory is mounted
+	// as per the `--on-missing-bucket` policy if it doesn't.
+	validated := time.Now()
+	var exists bool
+	err = d.unlocked(func(ctx context.Context) (err error) {
+		exists, err = d.ensureBucket(ctx, v.config)
+		if err == nil && exists {
+			err = d.ensureUnionBuckets(ctx, v.config, false)
+		}
+		return err
+	})
+	observeDuration(metricBucketCheckSeconds, v.name, validated)
+	if err != nil {
+		return errorResponseOf(err)
+	}
+	v.bucketMissing = !exists
+	// mount the bucket read only if the credentials can't write to it.
+	if exists {
+		if err := d.checkWriteAccess(v); err != nil {
+			return errorResponseOf(err)
+		}
+	}
+	if !exists {
+		if err := d.unlocked(func(ctx context.Context) error { return relabelMountpoint(ctx, v) }); err != nil {
+			return errorResponse(errInternal, err.Error())
+		}
+		if err := d.unlocked(func(context.Context) error { return applyOwnership(v) }); err != nil {
+			return errorResponse(errInternal, err.Error())
+		}
+		v.connections = 1
+		v.lastMounted = time.Now()
+		return volume.Response{Mountpoint: v.mountPoint}
+	}
+	// the objects of the source volume are copied before the first mount.
+	if v.clone != nil {
+		if err := d.waitClone(v); err != nil {
+			return errorResponseOf(err)
+		}
+	}
 	// Mount the remote Minio bucket to the local mountpoint.
-	if err := d.mountVolume(*v); err != nil {
-		logrus.WithFields(logrus.Fields{
+	if err := d.mountVolume(v); err != nil {
+		req.log.WithFields(logrus.Fields{
 			"mountpount": v.mountPoint,
 			"endpoint":   v.config.endpoint,
 			"bucket":     v.config.bucket,
-		}).Fatalf("Mount failed: <ERROR> %v", err)
+		}).Errorf("Mount failed: <ERROR> %v", err)
 
-		return errorResponse(err.Error())
+		return errorResponseOf(err)
 	}
+	v.connections = 1
 	// success.
+	v.lastMounted = time.Now()
+	d.startPrefetch(v)
 	return volume.Response{Mountpoint: v.mountPoint}
 }
 
+// gives back the connection of a mount which failed once the volume was mounted, the volume is released
+// if it was its only connection, as on Unmount.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) rollbackMount(v *mountInfo) {
+	if v.connections > 1 {
+		v.connections--
+		return
+	}
+	if d.idleUnmountAfter > 0 && v.mounted() {
+		v.connections = 0
+		v.idleSince = time.Now()
+		return
+	}
+	if err := d.releaseVolume(v); err != nil {
+		d.log().WithField("volume", v.name).Errorf("Releasing the volume after the failed mount failed. <ERROR> %v", err)
+		return
+	}
+	v.connections = 0
+	d.notify(eventUnmounted, v, "mount failed")
+}
+
 // *minfsDriver.Unmount - unmounts the mount at `mountpoint`.
 // protocol doc: https://docs.docker.com/engine/extend/plugins_volume/#/volumedriverunmount
 // Unmount is called when a container using the mounted volume is stopped.
 func (d *minfsDriver) Unmount(r volume.UnmountRequest) volume.Response {
-	logrus.WithField("method", "unmount").Debugf("%#v", r)
+	return d.dispatch(r.Name, func() volume.Response { return d.unmount(r) })
+}
 
+// serves the Unmount request in the worker of the volume.
+func (d *minfsDriver) unmount(r volume.UnmountRequest) (res volume.Response) {
+	req := newRequest("Unmount", r.Name)
+	req.log.Debugf("%#v", r)
+	start := time.Now()
+
+	// the lock of the volume is held until the request is served, see `lockVolume`.
+	v := d.lockVolume(r.Name)
+	if v != nil {
+		defer v.ops.Unlock()
+	}
 	d.Lock()
 	defer d.Unlock()
+	d.beginRequest(req)
+	defer func() { res = d.endRequest(req, res) }()
 	// verify if the mount exists.
-	v, ok := d.mounts[r.Name]
-	if !ok {
+	if v == nil {
 		// mount doesn't exist, return error.
-		logrus.WithFields(logrus.Fields{
+		req.log.WithFields(logrus.Fields{
 			"operation": "unmount",
 			"volume":    r.Name,
 		}).Error("Volume not found.")
 
-		return errorResponse(fmt.Sprintf("volume %s not found", r.Name))
+		return errorResponse(errNotFound, fmt.Sprintf("volume %s not found", r.Name))
+	}
+	defer observeDuration(metricUnmountSeconds, r.Name, start)
+	// wait for the writes of the last container to be uploaded.
+	if v.connections <= 1 && v.config.flushOnUnmount {
+		if err := d.flushUploads(v); err != nil {
+			return errorResponseOf(err)
+		}
 	}
 	// Unmount is done only if no other containers are using the mounted volume.
-	if v.connections <= 1 {
+	// with `--idle-unmount-after` the mount is kept until the volume has been idle for the given duration.
+	if v.connections <= 1 && d.idleUnmountAfter > 0 && v.mounted() {
+		v.connections = 0
+		v.idleSince = time.Now()
+	} else if v.connections <= 1 {
 		// unmount.
-		if err := d.unmountVolume(v.mountPoint); err != nil {
-			return errorResponse(err.Error())
+		if err := d.releaseVolume(v); err != nil {
+			return errorResponse(errInternal, err.Error())
 		}
 		v.connections = 0
+		d.notify(eventUnmounted, v, "")
 	} else {
 		// If the count is > 1, that is if the mounted volume is already being used by
 		// another container, dont't unmount, just decrease the count and return.
 		v.connections--
 	}
+	d.untrackMount(v, r.ID)
 
 	return volume.Response{}
 }
 
 // *minfsDriver.Get - Get the mount info.
 // protocol doc: https://docs.docker.com/engine/extend/plugins_volume/#/volumedriverget
-func (d *minfsDriver) Get(r volume.Request) volume.Response {
-	logrus.WithField("method", "get").Debugf("%#v", r)
+func (d *minfsDriver) Get(r volume.Request) (res volume.Response) {
+	req := newRequest("Get", r.Name)
+	req.log.Debugf("%#v", r)
+	defer func() { res = req.response(res) }()
 
-	d.Lock()
-	defer d.Unlock()
+	// the metadata requests only read the state under the read lock, they aren't serialized behind the mounts
+	// since the driver lock isn't held while minfs is started or the servers requested, see `unlocked`.
+	d.RLock()
+	defer d.RUnlock()
 	// verify if the mount exists.
 	v, ok := d.mounts[r.Name]
 	if !ok {
 		// mount doesn't exist, return error.
-		logrus.WithFields(logrus.Fields{
-			"operation": "unmount",
+		req.log.WithFields(logrus.Fields{
+			"operation": "get",
 			"volume":    r.Name,
 		}).Error("Volume not found.")
-		return errorResponse(fmt.Sprintf("volume %s not found", r.Name))
+		return errorResponse(errNotFound, fmt.Sprintf("volume %s not found", r.Name))
 	}
 
-	return volume.Response{Volume: &volume.Volume{Name: r.Name, Mountpoint: v.mountPoint}}
+	return volume.Response{Volume: &volume.Volume{Name: r.Name, Mountpoint: v.mountPoint, Status: v.status()}}
 }
 
 // *minfsDriver.List - Get the list of existing volumes.
 // protocol doc: https://docs.docker.com/engine/extend/plugins_volume/#/volumedriverlist
 func (d *minfsDriver) List(r volume.Request) volume.Response {
-	logrus.WithField("method", "list").Debugf("%#v", r)
+	req := newRequest("List", "")
+	req.log.Debugf("%#v", r)
 
-	d.Lock()
-	defer d.Unlock()
+	// only reads the state, see `Get`.
+	d.RLock()
+	defer d.RUnlock()
 
 	var vols []*volume.Volume
 	for name, v := range d.mounts {
-		vols = append(vols, &volume.Volume{Name: name, Mountpoint: v.mountPoint})
+		vols = append(vols, &volume.Volume{Name: name, Mountpoint: v.mountPoint, Status: v.listStatus()})
 	}
-	return volume.Response{Volumes: vols}
+	return req.response(volume.Response{Volumes: vols})
 }
 
 // *minfsDriver.Capabilities -  Takes values "local" or "global", more info in protocol doc below.
@@ -442,38 +1614,379 @@ func (d *minfsDriver) Capabilities(r volume.Request) volume.Response {
 }
 
 // mounts minfs to the local mountpoint.
-func (d *minfsDriver) mountVolume(v mountInfo) error {
-	// URL for the bucket (ex: https://play.minio.io:9000/mybucket).
-	var bucketPath string
-	if strings.HasSuffix(v.config.endpoint, "/") {
-		bucketPath = v.config.endpoint + v.config.bucket
+// minfs is run as a child process of the plugin and restarted if it crashes, see `startMinfs`.
+// With `--share-mounts` the mountpoint is a bind mount of a minfs mount shared by the volumes of the bucket.
+// The mountpoint is first given the propagation of the volume, see `setPropagation`,
+// the owner and mode of the volume are set on the root of the mount once mounted.
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while the
+// commands run.
+func (d *minfsDriver) mountVolume(v *mountInfo) error {
+	if err := d.unlocked(func(ctx context.Context) error { return setPropagation(ctx, v) }); err != nil {
+		return err
+	}
+	var err error
+	if len(v.config.union) > 0 {
+		// the buckets of a union volume are never shared.
+		err = d.mountUnion(v)
+	} else if d.shareMounts {
+		err = d.mountShared(v)
 	} else {
-		bucketPath = v.config.endpoint + "/" + v.config.bucket
+		v.failures = 0
+		err = d.startMinfs(v)
+	}
+	if err != nil {
+		clearPropagation(v)
+		return err
+	}
+	if err := d.unlocked(func(context.Context) error { return applyOwnership(v) }); err != nil {
+		d.releaseVolume(v)
+		return fmt.Errorf("setting the owner and mode of the mount failed: %v", err)
 	}
-	// mount command for minfs.
-	// ex:  mount -t minfs https://play.minio.io:9000/testbucket /testbucket
-	cmd := fmt.Sprintf("mount -t minfs %s %s", bucketPath, v.mountPoint)
+	return nil
+}
 
-	logrus.Debug(cmd)
-	return exec.Command("sh", "-c", cmd).Run()
+// returns the root of the mountpoint of the volume, `--mountroot` unless the volume sets `-o mount-root`.
+func (d *minfsDriver) volumeMountRoot(config serverConfig) string {
+	if config.mountRoot != "" {
+		return config.mountRoot
+	}
+	return d.mountRoot
 }
 
-// executes `unmount` on the specified volume.
-func (d *minfsDriver) unmountVolume(target string) error {
-	//  Unmount the volume.
-	cmd := fmt.Sprintf("umount %s", target)
-	logrus.Debug(cmd)
-	return exec.Command("sh", "-c", cmd).Run()
+// stops serving the volume mounted by `mountVolume`.
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while the
+// commands run.
+func (d *minfsDriver) releaseVolume(v *mountInfo) error {
+	stopPrefetch(v)
+	var err error
+	if v.union != nil {
+		err = d.unmountUnion(v)
+	} else if v.shared != nil {
+		err = d.unmountShared(v)
+	} else if v.snapshotMounted {
+		if err = d.unmountVolume(v); err == nil {
+			v.snapshotMounted = false
+		}
+	} else {
+		err = d.stopMinfs(v)
+	}
+	if err == nil {
+		clearPropagation(v)
+	}
+	return err
 }
 
 func main() {
+	// `minfs-docker-volume healthcheck` checks a running plugin, see `runHealthcheck`.
+	if isHealthcheck(os.Args) {
+		os.Exit(runHealthcheck(os.Args[2:]))
+	}
 	// --mountroot flag defines the root folder where are the volumes are mounted.
 	// If the option is not specified '/tmp' is taken as default mount root.
 	mountRoot := flag.String("mountroot", "/tmp", "root for mouting Minio buckets.")
+	// --minfs-binary is the minfs executable used to serve the mounts.
+	minfsBinary := flag.String("minfs-binary", "minfs", "path to the minfs executable.")
+	// --mergerfs-binary is the mergerfs executable merging the buckets of the union volumes (`-o buckets`).
+	mergerfsBinary := flag.String("mergerfs-binary", "mergerfs", "path to the mergerfs executable.")
+	// --mc-binary is the mc executable minting the service accounts of the volumes created without credentials.
+	mcBinary := flag.String("mc-binary", "mc", "path to the mc executable.")
+	// --minfs-output-lines is the number of lines of minfs output retained per volume and reported in its status.
+	outputLines := flag.Int("minfs-output-lines", defaultOutputLines, "number of lines of minfs output kept per volume.")
+	// --minfs-image runs minfs in a helper container of the given image instead of on the host,
+	// for hosts which can't have minfs installed on the root filesystem.
+	minfsImage := flag.String("minfs-image", "", "run minfs in helper containers of this image.")
+	dockerSocket := flag.String("docker-socket", defaultDockerSocket, "path of the Docker daemon API socket.")
+	// --on-missing-bucket controls what happens when the bucket of a volume doesn't exist.
+	onMissingBucket := flag.String("on-missing-bucket", missingBucketCreate, "policy for missing buckets: fail, create or mount-empty.")
+	// --signature is the default S3 signature version of the volumes, for legacy S3 compatible servers.
+	signature := flag.String("signature", "", "default S3 signature version of the volumes, v2 or v4, chosen for the endpoint if empty.")
+	// --mode is strict to fail Create on any error, or permissive to leave the checks of the endpoint and bucket to Mount.
+	mode := flag.String("mode", modeStrict, "operating mode, strict or permissive.")
+	// --allowed-endpoints restricts the endpoints volumes can point to, for multi-tenant hosts.
+	allowedEndpointsList := flag.String("allowed-endpoints", "", "comma separated list of allowed endpoint globs and CIDRs, all endpoints are allowed if empty.")
+	// --allowed-mount-roots lists the directories volumes can be mounted under with `-o mount-root`.
+	allowedMountRootsList := flag.String("allowed-mount-roots", "", "comma separated list of the directories volumes can be mounted under with the mount-root option, the option is refused if empty.")
+	// --auth-token-file requires volumes to be created with `-o auth-token=<token>`.
+	// The token is read from the file to keep it out of the process list.
+	authTokenFile := flag.String("auth-token-file", "", "file holding the token required to create volumes.")
+	// --authz-webhook calls out to an external service to authorize Create and Remove requests.
+	authzWebhook := flag.String("authz-webhook", "", "URL of the webhook authorizing Create and Remove requests.")
+	// --share-mounts serves the volumes mounting the same bucket with a single minfs process.
+	shareMounts := flag.Bool("share-mounts", false, "serve volumes of the same bucket with a single minfs mount.")
+	// --staged-remount mounts the new minfs of a volume whose credentials or options changed aside and moves it
+	// over the mountpoint, rather than unmounting the volume and mounting it again.
+	stagedRemount := flag.Bool("staged-remount", true, "remount the volumes whose credentials or options changed without unmounting them.")
+	// --alias serves an additional named driver on its own socket, with default options for its volumes.
+	// ex: --alias=minfs-prod:endpoint=https://minio.prod:9000,access-key-file=prod-access-key
+	var aliasSpecs aliasFlags
+	flag.Var(&aliasSpecs, "alias", "additional driver <name>:<option>=<value>,... served on /run/docker/plugins/<name>.sock, can be repeated.")
+	// --listen is an address the driver is served on, `unix://<path>` or `tcp://<host>:<port>`, can be repeated.
+	var listen listenFlags
+	flag.Var(&listen, "listen", "address the driver is served on, unix://<path> or tcp://<host>:<port>, can be repeated (default unix://"+socketAddress+").")
+	// --metrics-address is the TCP address on which the metrics are served in Prometheus format.
+	metricsAddress := flag.String("metrics-address", "", "address to serve metrics on (ex: :9100), disabled if empty.")
+	// --admin-address is the TCP address on which the admin API (snapshots) is served.
+	adminAddress := flag.String("admin-address", "", "address to serve the admin API on (ex: 127.0.0.1:9101 or unix:///run/minfs-admin.sock), disabled if empty.")
+	// --pprof serves the runtime profiles of the plugin on the admin API, to debug leaks and deadlocks.
+	enablePprof := flag.Bool("pprof", false, "serve the goroutine, heap and CPU profiles of the plugin on the admin API under /debug/pprof/.")
+	// --state-key-file holds the passphrase encrypting the credentials of the exported volumes (`GET /state` of the admin API).
+	stateKeyFile := flag.String("state-key-file", "", "file holding the passphrase encrypting the credentials of exported volumes.")
+	// --import-state registers the volumes of a state bundle exported on another host at startup.
+	importStateFile := flag.String("import-state", "", "state bundle whose volumes are imported at startup.")
+	// --state-file keeps the volumes across restarts of the plugin, see `checkpoint`.
+	stateFile := flag.String("state-file", "", "file the volumes are kept in across restarts, encrypted with --state-key-file, not kept if empty.")
+	// --credential-store keeps the credentials of the volumes in a secret store of the host rather than in --state-file, see `credentialStore`.
+	credentialStoreName := flag.String("credential-store", credentialStoreState, "where --state-file keeps the credentials of the volumes: state, keyring (kernel user keyring) or secret-service (secret-tool).")
+	checkpointInterval := flag.Duration("checkpoint-interval", 5*time.Second, "interval at which the changes of the volumes are written to --state-file.")
+	// --standby waits for the active instance sharing --state-file to exit and takes over, see `awaitTakeover`.
+	standby := flag.Bool("standby", false, "wait for the active instance sharing --state-file to exit, then take over its volumes and mounts.")
+	// --quota-interval is the interval at which the usage of the volumes with a quota is computed, see `checkQuotas`.
+	quotaInterval := flag.Duration("quota-interval", defaultQuotaInterval, "interval at which the usage of the buckets of the volumes with a quota is computed.")
+	// --usage-report-interval is the interval at which the usage of the volumes is reported, see `reportUsage`.
+	usageReportInterval := flag.Duration("usage-report-interval", 0, "interval at which the usage of the volumes is logged, disabled if 0.")
+	// --usage-webhook is the URL the usage reports are POSTed to.
+	usageWebhook := flag.String("usage-webhook", "", "URL the usage reports of --usage-report-interval are POSTed to as JSON.")
+	// --event-webhook is the URL the events of the volumes are POSTed to, see `notify`.
+	eventWebhook := flag.String("event-webhook", "", "URL the events of the volumes (created, mounted, unmounted, removed, unhealthy, remounted) are POSTed to as JSON.")
+	// --probe-interval is the interval at which the endpoints of the volumes are probed, see `probeEndpoints`.
+	probeInterval := flag.Duration("probe-interval", 30*time.Second, "interval at which the endpoints of the volumes are probed, disabled if 0.")
+	probeDegradedAfter := flag.Int("probe-degraded-after", 1, "number of consecutive failed probes after which an endpoint is degraded.")
+	probeUnreachableAfter := flag.Int("probe-unreachable-after", 3, "number of consecutive failed probes after which an endpoint is unreachable.")
+	// --minio-admin-access-key-file and --minio-admin-secret-key-file hold the credentials of the admin API of the MinIO servers, see `minioAdmin`.
+	minioAdminAccessKeyFile := flag.String("minio-admin-access-key-file", "", "file holding the access key of the admin API of the MinIO servers.")
+	minioAdminSecretKeyFile := flag.String("minio-admin-secret-key-file", "", "file holding the secret key of the admin API of the MinIO servers.")
+	// --max-concurrent-mounts bounds the number of mounts starting minfs at once, the others are queued.
+	maxConcurrentMounts := flag.Int("max-concurrent-mounts", 0, "maximum number of mounts starting minfs at once, unlimited if 0.")
+	// --unmount-timeout is the time given to an unmount before escalating to a lazy and a forced unmount.
+	unmountTimeout := flag.Duration("unmount-timeout", defaultUnmountTimeout, "time given to an unmount before escalating to a lazy, then forced unmount.")
+	// --mount-timeout is the time given to minfs to mount the bucket before the mount is reported as failed.
+	mountTimeout := flag.Duration("mount-timeout", defaultMountTimeout, "time given to minfs to mount the bucket.")
+	mountPollInterval := flag.Duration("mount-poll-interval", defaultMountPollInterval, "interval at which /proc/self/mountinfo is checked for the mount of minfs while it starts.")
+	// --otlp-endpoint exports traces of the requests to an OpenTelemetry collector, see `startSpan`.
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP traces endpoint (ex: http://otel-collector:4318/v1/traces), tracing is disabled if empty.")
+	// --credential-files-dir is the directory the credential files of the volumes (`-o access-key-file`) are read from.
+	credentialFilesDir := flag.String("credential-files-dir", "/run/secrets", "directory the access-key-file and secret-key-file options of the volumes are relative to.")
+	// --watch-credential-files reloads the credential files of the volumes when they change, as on SIGHUP.
+	watchCredentials := flag.Bool("watch-credential-files", true, "reload the credential files of the volumes when they change.")
+	// --vault-address enables fetching the credentials of the volumes from Vault with `-o vault-path=<path>`.
+	vaultAddress := flag.String("vault-address", os.Getenv("VAULT_ADDR"), "address of the Vault server holding the credentials of the volumes (ex: https://vault:8200).")
+	vaultTokenFile := flag.String("vault-token-file", "", "file holding the Vault token of the plugin.")
+	vaultRoleID := flag.String("vault-role-id", "", "AppRole role ID of the plugin, used with --vault-secret-id-file.")
+	vaultSecretIDFile := flag.String("vault-secret-id-file", "", "file holding the AppRole secret ID of the plugin.")
+	// --aws-region is the region of the AWS Secrets Manager secrets and SSM parameters holding the credentials
+	// of the volumes, `-o secret-arn=<arn>` and `-o ssm-param=<name>`.
+	awsRegion := flag.String("aws-region", os.Getenv("AWS_REGION"), "region of the AWS secrets and parameters holding the credentials of the volumes, the region of an ARN takes precedence.")
+	// --credential-refresh-interval is how long the credentials of a provider are cached for if their secret has no lease.
+	credentialRefreshInterval := flag.Duration("credential-refresh-interval", 5*time.Minute, "time the credentials fetched from a credential provider are cached for if their secret has no lease.")
+	// --encrypted-cache-size is the size of the encrypted cache of the volumes mounted with `-o encrypt-cache=true`.
+	encryptedCacheSize := flag.Int64("encrypted-cache-size", 1024, "size in MiB of the encrypted cache of the volumes with the encrypt-cache option.")
+	// --flush-timeout is the time an unmount waits for the pending uploads of a volume with `-o flush-on-unmount=true`.
+	flushTimeout := flag.Duration("flush-timeout", 5*time.Minute, "time an unmount waits for the pending uploads of a volume with the flush-on-unmount option.")
+	// --minfs-memory-limit and --minfs-cpu-quota bound the resources of every minfs process, see `limitResources`.
+	minfsMemoryLimit := flag.String("minfs-memory-limit", "", "memory limit of each minfs process (ex: 512M), unlimited if empty.")
+	minfsCPUQuota := flag.String("minfs-cpu-quota", "", "CPU quota of each minfs process in number of CPUs (ex: 0.5), unlimited if empty.")
+	// --run-as-user drops the privileges of the minfs processes to the user, see `runAsUser`.
+	runAsName := flag.String("run-as-user", "", "user (name or <uid>[:<gid>]) the minfs processes run as, root if empty.")
+	// --check-write-access mounts read only the buckets the credentials of the volume can't write to.
+	// The check writes an object to the buckets, it's opt-in.
+	checkWrites := flag.Bool("check-write-access", false, "verify on create and mount that the volumes can write to their bucket, and mount them read only if they can't.")
+	// --legacy-bucket-names accepts the bucket names with uppercase letters and underscores of legacy S3 servers.
+	legacyBucketNames := flag.Bool("legacy-bucket-names", false, "accept bucket names following the legacy S3 naming rules (uppercase letters, underscores, up to 255 characters).")
+	// --dump-file is the file the state is dumped to on SIGUSR1, see `dumpState`.
+	dumpFile := flag.String("dump-file", "", "file the state of the plugin is written to on SIGUSR1, the log if empty.")
+	// --default-opt sets the default value of an option of the volumes, can be repeated.
+	// ex: --default-opt consistency=strict --default-opt owner=1000:1000
+	defaultOpts := make(defaultOptFlags)
+	flag.Var(defaultOpts, "default-opt", "default <option>=<value> of the volumes, overridden by the options of the create request, can be repeated.")
+	// --max-volumes bounds the number of volumes of the plugin, see `checkVolumeQuota`.
+	maxVolumes := flag.Int("max-volumes", 0, "maximum number of volumes, creating more fails with quota-exceeded, unlimited if 0.")
+	// --idle-unmount-after keeps the mount of the volumes no container uses for the given duration, see `releaseIdleVolumes`.
+	idleUnmountAfter := flag.Duration("idle-unmount-after", 0, "time the mount of a volume no container uses is kept for before it's unmounted, unmounted on the last unmount if 0.")
+	// --reconcile-interval is the interval at which the connections of the volumes are reconciled with the
+	// live containers, see `reconcileConnections`.
+	reconcileInterval := flag.Duration("reconcile-interval", time.Minute, "interval at which leaked connections of the volumes are repaired from the containers listed by the Docker API, disabled if 0.")
+	// --self-test mounts a scratch volume at startup, see `selfTest`.
+	// ex: --self-test=endpoint=https://minio:9000,access-key-file=access-key,secret-key-file=secret-key
+	selfTest := flag.String("self-test", "", "options of a scratch volume (<option>=<value>,...) mounted, written and read at startup, the plugin exits if it fails.")
+	// --check-config validates the flags, the endpoints and the state file, prints a report and exits.
+	checkConfig := flag.Bool("check-config", false, "validate the configuration (mount root, minfs, endpoints, credentials, state file), print a report and exit.")
+	// --resolver resolves the endpoints with the given DNS server, see `resolveHost`.
+	// ex: --resolver=10.0.0.2:53
+	resolver := flag.String("resolver", "", "DNS server (<ip>[:<port>]) the endpoints are resolved with, the resolver of the host if empty.")
+	// --rootless serves a rootless Docker daemon, see `rootlessMode`. The default is detected from the user
+	// the plugin runs as.
+	rootless := flag.Bool("rootless", os.Geteuid() != 0, "serve a rootless Docker daemon: sockets under $XDG_RUNTIME_DIR/docker/plugins/ and unmounts with fusermount.")
+	// --podman-socket serves the driver to Podman on a unix socket, see `servePodman`.
+	// ex: --podman-socket=/run/podman/plugins/minfs.sock
+	podmanSocket := flag.String("podman-socket", "", "unix socket the driver is also served to Podman on, listed in /etc/containers/containers.conf.")
+	// --request-timeout cancels the commands of a request running longer, see `requestContext`.
+	requestTimeout := flag.Duration("request-timeout", defaultRequestTimeout, "time after which the commands run for a request are cancelled, never if 0.")
 	flag.Parse()
+	if *mountPollInterval <= 0 {
+		logrus.Fatalf("Invalid --mount-poll-interval %s, must be positive.", *mountPollInterval)
+	}
+	if err := validateResourceLimits(*minfsMemoryLimit, *minfsCPUQuota); err != nil {
+		logrus.Fatalf("Invalid minfs resource limits. <ERROR> %v", err)
+	}
+	var runAs *runAsUser
+	if *runAsName != "" {
+		var err error
+		if runAs, err = parseRunAsUser(*runAsName); err != nil {
+			logrus.Fatalf("Invalid --run-as-user %q. <ERROR> %v", *runAsName, err)
+		}
+	}
+	if !isValidMissingBucketPolicy(*onMissingBucket) {
+		logrus.Fatalf("Invalid --on-missing-bucket policy %q, must be one of fail, create or mount-empty.", *onMissingBucket)
+	}
+	if !isValidSignature(*signature) {
+		logrus.Fatalf("Invalid --signature %q, must be v2 or v4.", *signature)
+	}
+	if !isValidMode(*mode) {
+		logrus.Fatalf("Invalid --mode %q, must be strict or permissive.", *mode)
+	}
+	if *probeDegradedAfter < 1 || *probeUnreachableAfter < *probeDegradedAfter {
+		logrus.Fatalf("Invalid probe thresholds, --probe-unreachable-after must be at least --probe-degraded-after, which must be at least 1.")
+	}
+	allowedEndpoints, err := parseEndpointAllowlist(*allowedEndpointsList)
+	if err != nil {
+		logrus.Fatalf("Invalid --allowed-endpoints. <ERROR> %v", err)
+	}
+	allowedMountRoots, err := parseMountRootAllowlist(*allowedMountRootsList)
+	if err != nil {
+		logrus.Fatalf("Invalid --allowed-mount-roots. <ERROR> %v", err)
+	}
+	var authz authorizers
+	if *authTokenFile != "" {
+		token, rErr := ioutil.ReadFile(*authTokenFile)
+		if rErr != nil || strings.TrimSpace(string(token)) == "" {
+			logrus.Fatalf("Unable to read the auth token from %s. <ERROR> %v", *authTokenFile, rErr)
+		}
+		authz = append(authz, tokenAuthorizer{token: strings.TrimSpace(string(token))})
+	}
+	if *authzWebhook != "" {
+		authz = append(authz, newWebhookAuthorizer(*authzWebhook))
+	}
+	var events *eventNotifier
+	if *eventWebhook != "" {
+		events = newEventNotifier(*eventWebhook)
+		go events.run()
+	}
+	var aliases []*driverAlias
+	for _, spec := range aliasSpecs {
+		a, aErr := parseDriverAlias(spec)
+		if aErr != nil {
+			logrus.Fatalf("Invalid --alias. <ERROR> %v", aErr)
+		}
+		for _, opt := range []string{"access-key-file", "secret-key-file"} {
+			if name := a.defaults[opt]; name != "" {
+				if err := validateCredentialFileName(name); err != nil {
+					logrus.Fatalf("Invalid --alias %s. <ERROR> %v", a.name, err)
+				}
+			}
+		}
+		aliases = append(aliases, a)
+	}
+	providers := make(map[string]credentialProvider)
+	if *vaultAddress != "" {
+		vault, vErr := newVaultProvider(*vaultAddress, *vaultTokenFile, *vaultRoleID, *vaultSecretIDFile)
+		if vErr != nil {
+			logrus.Fatalf("Invalid Vault configuration. <ERROR> %v", vErr)
+		}
+		providers["vault"] = vault
+	}
+	// the AWS credentials of the plugin are only looked up when a volume uses these providers.
+	providers[awsSecretsManager] = newAWSProvider(awsSecretsManager, *awsRegion)
+	providers[awsSSM] = newAWSProvider(awsSSM, *awsRegion)
+	minioAdmin, err := loadMinioAdmin(*minioAdminAccessKeyFile, *minioAdminSecretKeyFile)
+	if err != nil {
+		logrus.Fatalf("Unable to read the credentials of the MinIO admin API. <ERROR> %v", err)
+	}
+	var stateCipher *stateCipher
+	if *stateKeyFile != "" {
+		if stateCipher, err = loadStateCipher(*stateKeyFile); err != nil {
+			logrus.Fatalf("Unable to read the state key from %s. <ERROR> %v", *stateKeyFile, err)
+		}
+	}
+	credentialStore, err := newCredentialStore(*credentialStoreName)
+	if err != nil {
+		logrus.Fatalf("Invalid --credential-store. <ERROR> %v", err)
+	}
+	if *stateFile != "" && stateCipher == nil && credentialStore == nil {
+		logrus.Fatal("--state-file requires --state-key-file to encrypt the credentials of the volumes, or --credential-store.")
+	}
+	if *standby && *stateFile == "" {
+		logrus.Fatal("--standby requires the --state-file of the active instance.")
+	}
+	if rootlessMode = *rootless; rootlessMode {
+		if *dockerSocket == defaultDockerSocket {
+			*dockerSocket = rootlessDockerSocket()
+		}
+		logrus.Infof("serving a rootless Docker daemon, sockets under %s", filepath.Dir(rootlessSocketPath(pluginName)))
+	}
+	if *resolver != "" {
+		if err = setEndpointResolver(*resolver); err != nil {
+			logrus.Fatalf("Invalid --resolver. <ERROR> %v", err)
+		}
+		if *minfsImage == "" {
+			logrus.Warn("minfs runs on the host and resolves the endpoints with the resolver of the host, --resolver only applies to the requests of the plugin.")
+		}
+	}
+	// if `export DEBUG=1` is set, debug logs will be printed.
+	debug := os.Getenv("DEBUG")
+	if ok, _ := strconv.ParseBool(debug); ok {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+	// Create a new instance MinfsDriver.
+	// The struct implements the `github.com/docker/go-plugins-helpers/volume.Driver` interface.
+	d := newMinfsDriver(pluginConfig{
+		mountRoot:                 *mountRoot,
+		minfsBinary:               *minfsBinary,
+		mergerfsBinary:            *mergerfsBinary,
+		mcBinary:                  *mcBinary,
+		outputLines:               *outputLines,
+		idleUnmountAfter:          *idleUnmountAfter,
+		minfsImage:                *minfsImage,
+		dockerSocket:              *dockerSocket,
+		onMissingBucket:           *onMissingBucket,
+		signature:                 *signature,
+		mode:                      *mode,
+		allowedEndpoints:          allowedEndpoints,
+		allowedMountRoots:         allowedMountRoots,
+		credentialFilesDir:        *credentialFilesDir,
+		authz:                     authz,
+		events:                    events,
+		stateCipher:               stateCipher,
+		credentialStore:           credentialStore,
+		shareMounts:               *shareMounts,
+		stagedRemount:             *stagedRemount && !rootlessMode,
+		probeDegradedAfter:        *probeDegradedAfter,
+		probeUnreachableAfter:     *probeUnreachableAfter,
+		maxConcurrentMounts:       *maxConcurrentMounts,
+		unmountTimeout:            *unmountTimeout,
+		mountTimeout:              *mountTimeout,
+		mountPollInterval:         *mountPollInterval,
+		requestTimeout:            *requestTimeout,
+		providers:                 providers,
+		minioAdmin:                minioAdmin,
+		credentialRefreshInterval: *credentialRefreshInterval,
+		encryptedCacheSize:        *encryptedCacheSize << 20,
+		flushTimeout:              *flushTimeout,
+		minfsMemoryLimit:          *minfsMemoryLimit,
+		minfsCPUQuota:             *minfsCPUQuota,
+		runAs:                     runAs,
+		checkWrites:               *checkWrites,
+		legacyBucketNames:         *legacyBucketNames,
+		defaultOptions:            defaultOpts,
+		maxVolumes:                *maxVolumes,
+		stateFile:                 *stateFile,
+	})
+	// --check-config validates the configuration and exits, see `checkConfig`.
+	if *checkConfig {
+		os.Exit(d.runConfigCheck(os.Stdout, aliases))
+	}
 	// check if the mount root exists.
 	// create if it doesn't exist.
-	err := createDir(*mountRoot)
+	err = createDir(*mountRoot)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"mountroot": mountRoot,
@@ -481,19 +1994,164 @@ func main() {
 
 		return
 	}
-	// if `export DEBUG=1` is set, debug logs will be printed.
-	debug := os.Getenv("DEBUG")
-	if ok, _ := strconv.ParseBool(debug); ok {
-		logrus.SetLevel(logrus.DebugLevel)
+	// only one instance serves the mount root, a standby waits for the active instance to exit.
+	lockPaths := instanceLockPaths(*mountRoot, *stateFile)
+	if *standby {
+		if err := awaitTakeover(lockPaths, *stateFile); err != nil {
+			logrus.Fatalf("Unable to take over the active instance. <ERROR> %v", err)
+		}
+	} else if err := lockInstance(lockPaths); err != nil {
+		if _, held := err.(errLockHeld); held {
+			logrus.Fatalf("Refusing to start, %v. Start this instance with --standby to take over once it exits.", err)
+		}
+		logrus.Fatalf("Unable to lock %s. <ERROR> %v", strings.Join(lockPaths, ", "), err)
 	}
-	// Create a new instance MinfsDriver.
-	// The struct implements the `github.com/docker/go-plugins-helpers/volume.Driver` interface.
-	d := newMinfsDriver(*mountRoot)
+	// restore the volumes of the previous run, or of the active instance once it's gone.
+	if *stateFile != "" {
+		if err := d.restoreState(); err != nil {
+			logrus.Fatalf("Unable to restore the state from %s. <ERROR> %v", *stateFile, err)
+		}
+		d.adoptMounts()
+		go d.checkpointLoop(*checkpointInterval)
+	}
+	// import the volumes exported on another host.
+	if *importStateFile != "" {
+		res, err := d.importStateFile(*importStateFile)
+		if err != nil {
+			logrus.Fatalf("Unable to import the state bundle %s. <ERROR> %v", *importStateFile, err)
+		}
+		if len(res.Skipped) > 0 {
+			logrus.Warnf("Volumes already defined, not imported: %s", strings.Join(res.Skipped, ", "))
+		}
+	}
+	// verify that buckets can be mounted before serving docker.
+	if *selfTest != "" {
+		d.runSelfTest(*selfTest)
+	}
+	// serve the metrics if enabled.
+	if *metricsAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", driverMetrics)
+			logrus.Infof("serving metrics on %s", *metricsAddress)
+			logrus.Error(http.ListenAndServe(*metricsAddress, mux))
+		}()
+	}
+	// export the traces if enabled.
+	if *otlpEndpoint != "" {
+		tracer = newSpanExporter(*otlpEndpoint)
+		go tracer.run()
+	}
+	// reload the credential files of the volumes on change.
+	if *watchCredentials {
+		go d.watchCredentialFiles()
+	}
+	// fetch the expired credentials of the credential providers again.
+	if len(providers) > 0 {
+		go d.refreshCredentialsLoop()
+	}
+	// report the pending uploads of the volumes in the metrics.
+	go d.sampleUploadQueues()
+	// probe the endpoints of the volumes.
+	if *probeInterval > 0 {
+		go d.probeEndpoints(*probeInterval)
+	}
+	// enforce the quotas of the volumes.
+	if *quotaInterval > 0 {
+		go d.checkQuotas(*quotaInterval)
+	}
+	// report the usage of the volumes.
+	if *usageReportInterval > 0 {
+		go d.reportUsage(*usageReportInterval, *usageWebhook)
+	} else if *usageWebhook != "" {
+		logrus.Fatal("--usage-webhook requires --usage-report-interval.")
+	}
+	// release the mounts of the idle volumes.
+	if *idleUnmountAfter > 0 {
+		go d.unmountIdleVolumes()
+	}
+	// repair the connections leaked by killed containers.
+	if *reconcileInterval > 0 {
+		go d.reconcileConnections(*reconcileInterval)
+	}
+	// serve the admin API if enabled.
+	if *adminAddress != "" {
+		go func() {
+			logrus.Infof("serving admin API on %s", *adminAddress)
+			logrus.Error(serveAdmin(*adminAddress, d.adminHandler(*enablePprof)))
+		}()
+	}
+	// reload the credential files of the volumes on SIGHUP.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logrus.Info("SIGHUP received, reloading the credential files.")
+			d.reloadCredentials()
+		}
+	}()
+	// dump the state of the plugin on SIGUSR1.
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			d.writeStateDump(*dumpFile)
+		}
+	}()
+	// cancel the commands being run and write the pending changes of the state before exiting.
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-term
+		logrus.Infof("%s received, exiting.", sig)
+		d.stop()
+		if *stateFile != "" {
+			d.checkpoint()
+		}
+		unlockInstance()
+		os.Exit(0)
+	}()
+	// toggle drain mode on SIGUSR2, for hosts without the admin API.
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	go func() {
+		for range usr2 {
+			d.RLock()
+			draining := d.draining
+			d.RUnlock()
+			d.setDraining(!draining)
+		}
+	}()
 	// register it with the `go-plugin-helper`.
 	// `go-plugin-helper` is a tool built to make development of docker plugins easier, visit https://github.com/docker/go-plugins-helpers/.
-	// The registration is done using https://godoc.org/github.com/docker/go-plugins-helpers/volume#NewHandler .
-	h := volume.NewHandler(d)
-	// create a server on unix socket.
-	logrus.Infof("listening on %s", socketAddress)
-	logrus.Error(h.ServeUnix(socketAddress, 0))
+	// The requests are served with the handler of the plugin, see `newVolumeHandler`.
+	// every alias is served on its own socket.
+	for _, a := range aliases {
+		a.minfsDriver = d
+		go func(a *driverAlias) {
+			logrus.Infof("serving driver alias %s", a.name)
+			logrus.Error(serveListener(newVolumeHandler(a), a.name))
+		}(a)
+	}
+	h := newVolumeHandler(&driverAlias{minfsDriver: d})
+	// serve the driver on every listen address, the unix socket by default.
+	if len(listen) == 0 {
+		listen = listenFlags{socketAddress}
+		if rootlessMode {
+			listen = listenFlags{rootlessSocketPath(pluginName)}
+		}
+	}
+	errs := make(chan error, len(listen)+1)
+	for _, address := range listen {
+		go func(address string) {
+			logrus.Infof("listening on %s", address)
+			errs <- serveListener(h, address)
+		}(address)
+	}
+	if *podmanSocket != "" {
+		go func() {
+			errs <- servePodman(&driverAlias{minfsDriver: d}, *podmanSocket)
+		}()
+	}
+	logrus.Error(<-errs)
 }
@@ -0,0 +1,323 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric types supported by the registry, named after the Prometheus text exposition format.
+const (
+	counterMetric   = "counter"
+	gaugeMetric     = "gauge"
+	histogramMetric = "histogram"
+)
+
+// upper bounds in seconds of the buckets of the duration histograms, from a fast local mount to Docker
+// giving up on the request.
+var durationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// labels attached to a metric sample (ex: {"volume": "profile-pic-store"}).
+type labels map[string]string
+
+// renders the labels in the Prometheus text format, keys sorted for stable output.
+func (l labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, l[k]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// returns a copy of the labels with the label `key` set to `value`.
+func (l labels) with(key, value string) labels {
+	c := make(labels, len(l)+1)
+	for k, v := range l {
+		c[k] = v
+	}
+	c[key] = value
+	return c
+}
+
+// A metric family, its samples are keyed by the rendered label set.
+type metricFamily struct {
+	help       string
+	metricType string
+	samples    map[string]float64
+	// upper bounds of the buckets and the observations of a histogram, keyed by the rendered label set.
+	buckets    []float64
+	histograms map[string]*histogram
+}
+
+// histogram - Observations of a histogram for a label set.
+type histogram struct {
+	labels labels
+	// number of observations less than or equal to the upper bound of each bucket.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// metricsRegistry - In-memory store of the plugin metrics.
+// The metrics are served in the Prometheus text format on `--metrics-address`.
+type metricsRegistry struct {
+	sync.Mutex
+	families map[string]*metricFamily
+}
+
+// return a new, empty metrics registry.
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{families: make(map[string]*metricFamily)}
+}
+
+// metrics of the plugin process.
+var driverMetrics = newMetricsRegistry()
+
+// Metrics exported by the driver.
+const (
+	metricMinfsRestarts       = "minfs_volume_restarts_total"
+	metricEndpointHealth      = "minfs_volume_endpoint_health"
+	metricProbeFailures       = "minfs_volume_endpoint_probe_failures_total"
+	metricMountsQueued        = "minfs_mounts_queued"
+	metricPendingUploads      = "minfs_volume_pending_uploads"
+	metricVolumes             = "minfs_volumes"
+	metricConnectionsRepaired = "minfs_volume_connections_repaired_total"
+	metricRequests            = "minfs_requests_total"
+	metricRequestErrors       = "minfs_request_errors_total"
+	metricActiveMounts        = "minfs_active_mounts"
+	metricExecs               = "minfs_execs_total"
+	metricExecSeconds         = "minfs_exec_seconds_total"
+	metricQuotaBytes          = "minfs_volume_quota_bytes"
+	metricUsageBytes          = "minfs_volume_usage_bytes"
+	metricMountSeconds        = "minfs_mount_duration_seconds"
+	metricUnmountSeconds      = "minfs_unmount_duration_seconds"
+	metricBucketCheckSeconds  = "minfs_bucket_validation_duration_seconds"
+)
+
+func init() {
+	// the metrics are also published with expvar, served at `/debug/vars` on the admin API.
+	expvar.Publish("minfs", expvar.Func(func() interface{} { return driverMetrics.snapshot() }))
+	driverMetrics.register(metricMinfsRestarts, counterMetric, "Number of times minfs was restarted after exiting unexpectedly.")
+	driverMetrics.register(metricEndpointHealth, gaugeMetric, "Health of the endpoint of the volume: 0 healthy, 1 degraded, 2 unreachable.")
+	driverMetrics.register(metricProbeFailures, counterMetric, "Number of failed probes of the endpoint of the volume.")
+	driverMetrics.register(metricMountsQueued, gaugeMetric, "Number of mounts waiting for a slot, see --max-concurrent-mounts.")
+	driverMetrics.register(metricPendingUploads, gaugeMetric, "Number of files written to the volume and not uploaded by minfs yet.")
+	driverMetrics.register(metricVolumes, gaugeMetric, "Number of volumes of the plugin, see --max-volumes.")
+	driverMetrics.register(metricConnectionsRepaired, counterMetric, "Number of times leaked connections of the volume were repaired, see --reconcile-interval.")
+	driverMetrics.register(metricRequests, counterMetric, "Number of requests of docker served, by method.")
+	driverMetrics.register(metricRequestErrors, counterMetric, "Number of requests of docker which failed, by method.")
+	driverMetrics.register(metricActiveMounts, gaugeMetric, "Number of volumes mounted by containers.")
+	driverMetrics.register(metricExecs, counterMetric, "Number of commands run by the plugin (minfs mounts and unmounts), by command.")
+	driverMetrics.register(metricExecSeconds, counterMetric, "Total time spent running the commands of the plugin in seconds, by command.")
+	driverMetrics.register(metricQuotaBytes, gaugeMetric, "Quota of the bucket of the volume in bytes, see -o quota.")
+	driverMetrics.register(metricUsageBytes, gaugeMetric, "Total size of the objects of the bucket of the volume with a quota in bytes.")
+	driverMetrics.registerHistogram(metricMountSeconds, "Duration of the Mount requests in seconds, by volume and overall.", durationBuckets)
+	driverMetrics.registerHistogram(metricUnmountSeconds, "Duration of the Unmount requests in seconds, by volume and overall.", durationBuckets)
+	driverMetrics.registerHistogram(metricBucketCheckSeconds, "Duration of the validations of the bucket of the volumes in seconds, by volume and overall.", durationBuckets)
+}
+
+// registers a metric family with its type and help text.
+func (m *metricsRegistry) register(name, metricType, help string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.families[name] = &metricFamily{
+		help:       help,
+		metricType: metricType,
+		samples:    make(map[string]float64),
+	}
+}
+
+// registers a histogram family with the upper bounds of its buckets, in increasing order.
+func (m *metricsRegistry) registerHistogram(name, help string, buckets []float64) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.families[name] = &metricFamily{
+		help:       help,
+		metricType: histogramMetric,
+		buckets:    buckets,
+		histograms: make(map[string]*histogram),
+	}
+}
+
+// adds `value` to the observations of the histogram `name`.
+func (m *metricsRegistry) observe(name string, l labels, value float64) {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.families[name]
+	if !ok || f.histograms == nil {
+		return
+	}
+	key := l.String()
+	h, ok := f.histograms[key]
+	if !ok {
+		h = &histogram{labels: l, counts: make([]uint64, len(f.buckets))}
+		f.histograms[key] = h
+	}
+	for i, bound := range f.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// records the time elapsed since `start` in the histogram `name`, for the volume and overall.
+func observeDuration(name, volume string, start time.Time) {
+	elapsed := time.Since(start).Seconds()
+	driverMetrics.observe(name, labels{"volume": volume}, elapsed)
+	driverMetrics.observe(name, labels{}, elapsed)
+}
+
+// increments the counter `name` by `delta`.
+func (m *metricsRegistry) add(name string, l labels, delta float64) {
+	m.Lock()
+	defer m.Unlock()
+
+	if f, ok := m.families[name]; ok {
+		f.samples[l.String()] += delta
+	}
+}
+
+// increments the counter `name` by one.
+func (m *metricsRegistry) inc(name string, l labels) {
+	m.add(name, l, 1)
+}
+
+// sets the gauge `name` to `value`.
+func (m *metricsRegistry) set(name string, l labels, value float64) {
+	m.Lock()
+	defer m.Unlock()
+
+	if f, ok := m.families[name]; ok {
+		f.samples[l.String()] = value
+	}
+}
+
+// records a command run by the plugin and the time it took, see `metricExecSeconds`.
+func observeExec(command string, start time.Time) {
+	l := labels{"command": command}
+	driverMetrics.inc(metricExecs, l)
+	driverMetrics.add(metricExecSeconds, l, time.Since(start).Seconds())
+}
+
+// returns the samples of all the metrics keyed by the rendered label set, the empty set for the
+// samples without labels. Published with expvar on the admin API.
+func (m *metricsRegistry) snapshot() map[string]map[string]float64 {
+	m.Lock()
+	defer m.Unlock()
+
+	snapshot := make(map[string]map[string]float64, len(m.families))
+	for name, f := range m.families {
+		samples := make(map[string]float64, len(f.samples))
+		for k, v := range f.samples {
+			samples[k] = v
+		}
+		snapshot[name] = samples
+		if f.histograms == nil {
+			continue
+		}
+		counts, sums := make(map[string]float64), make(map[string]float64)
+		for k, h := range f.histograms {
+			counts[k], sums[k] = float64(h.count), h.sum
+		}
+		snapshot[name+"_count"], snapshot[name+"_sum"] = counts, sums
+	}
+	return snapshot
+}
+
+// drops the samples of all the metric families carrying the given label set.
+// Used to clean up the per volume samples once the volume is removed.
+func (m *metricsRegistry) forget(l labels) {
+	m.Lock()
+	defer m.Unlock()
+
+	key := l.String()
+	for _, f := range m.families {
+		delete(f.samples, key)
+		delete(f.histograms, key)
+	}
+}
+
+// writes all the metrics in the Prometheus text exposition format.
+func (m *metricsRegistry) writeTo(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := m.families[name]
+		fmt.Fprintf(w, "# HELP %s %s\n", name, f.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, f.metricType)
+		keys := make([]string, 0, len(f.samples))
+		for k := range f.samples {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s%s %v\n", name, k, f.samples[k])
+		}
+		writeHistograms(w, name, f)
+	}
+}
+
+// writes the observations of the histograms of the family, `<name>_bucket`, `<name>_sum` and `<name>_count`.
+func writeHistograms(w io.Writer, name string, f *metricFamily) {
+	keys := make([]string, 0, len(f.histograms))
+	for k := range f.histograms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h := f.histograms[k]
+		for i, bound := range f.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, h.labels.with("le", strconv.FormatFloat(bound, 'g', -1, 64)), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, h.labels.with("le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %v\n", name, k, h.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", name, k, h.count)
+	}
+}
+
+// ServeHTTP - serves the metrics at `/metrics`.
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.writeTo(w)
+}
@@ -0,0 +1,186 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go/pkg/s3signer"
+)
+
+// MinIO admin API - With `--minio-admin-access-key-file` and `--minio-admin-secret-key-file`, the plugin
+// reaches the admin API of the endpoints which are MinIO servers. The probes of the endpoints (see
+// `probeEndpoints`) fetch the server info of the deployment: the version of the servers, the state of
+// their drives and the drives being healed. A reachable endpoint with offline servers or drives, or
+// healing drives, is `server-degraded` rather than `healthy`, telling a degraded deployment from a network
+// failure. The endpoints which aren't MinIO servers are probed as before.
+
+// path of the admin API of the MinIO servers.
+const minioAdminPath = "/minio/admin/v3"
+
+// minioAdmin - Credentials of the admin API of the MinIO servers.
+type minioAdmin struct {
+	accessKey string
+	secretKey string
+}
+
+// returned when the endpoint doesn't serve the MinIO admin API.
+var errNotMinio = fmt.Errorf("endpoint is not a MinIO server")
+
+// reads the credentials of the admin API from the files, nil if the files are not set.
+func loadMinioAdmin(accessKeyFile, secretKeyFile string) (*minioAdmin, error) {
+	if accessKeyFile == "" && secretKeyFile == "" {
+		return nil, nil
+	}
+	if accessKeyFile == "" || secretKeyFile == "" {
+		return nil, fmt.Errorf("--minio-admin-access-key-file and --minio-admin-secret-key-file must be set together")
+	}
+	accessKey, secretKey, err := readCredentialFiles(accessKeyFile, secretKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &minioAdmin{accessKey: accessKey, secretKey: secretKey}, nil
+}
+
+// sends a signed request to the admin API of the endpoint of the volume, returns the body of the response.
+func (a *minioAdmin) do(config serverConfig, method, path string, query url.Values, body []byte) ([]byte, error) {
+	u, err := url.Parse(strings.TrimSuffix(config.endpoint, "/") + minioAdminPath + path)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	sha := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sha[:]))
+	region := config.region
+	if region == "" {
+		region = defaultLocation
+	}
+	req = s3signer.SignV4(*req, a.accessKey, a.secretKey, region)
+
+	client := &http.Client{Transport: endpointTransport(config)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		// the other S3 servers don't know the path, or answer with an S3 error for a missing bucket.
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNotImplemented || !strings.Contains(resp.Header.Get("Server"), "MinIO") {
+			return nil, errNotMinio
+		}
+		return nil, fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// minioServerInfo - response of the server info request of the admin API, only the fields in use.
+type minioServerInfo struct {
+	Servers []struct {
+		State    string `json:"state"`
+		Endpoint string `json:"endpoint"`
+		Version  string `json:"version"`
+		Drives   []struct {
+			Endpoint string `json:"endpoint"`
+			State    string `json:"state"`
+			Healing  bool   `json:"healing"`
+		} `json:"drives"`
+	} `json:"servers"`
+}
+
+// serverHealth - Health of the MinIO deployment behind an endpoint, from its server info.
+type serverHealth struct {
+	version        string
+	serversOnline  int
+	serversOffline int
+	drivesOnline   int
+	drivesOffline  int
+	drivesHealing  int
+}
+
+// returns true if servers or drives of the deployment are offline or drives are being healed.
+func (h *serverHealth) degraded() bool {
+	return h.serversOffline > 0 || h.drivesOffline > 0 || h.drivesHealing > 0
+}
+
+// returns the health reported in the status of the volumes.
+func (h *serverHealth) status() map[string]interface{} {
+	return map[string]interface{}{
+		"version":        h.version,
+		"serversOnline":  h.serversOnline,
+		"serversOffline": h.serversOffline,
+		"drivesOnline":   h.drivesOnline,
+		"drivesOffline":  h.drivesOffline,
+		"drivesHealing":  h.drivesHealing,
+	}
+}
+
+// describes why the deployment is degraded.
+func (h *serverHealth) String() string {
+	return fmt.Sprintf("%d/%d servers offline, %d/%d drives offline, %d drives healing",
+		h.serversOffline, h.serversOnline+h.serversOffline, h.drivesOffline, h.drivesOnline+h.drivesOffline, h.drivesHealing)
+}
+
+// returns the health of the MinIO deployment behind the endpoint of the volume.
+func (a *minioAdmin) serverHealth(config serverConfig) (*serverHealth, error) {
+	data, err := a.do(config, "GET", "/info", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var info minioServerInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("invalid server info: %v", err)
+	}
+	h := &serverHealth{}
+	for _, s := range info.Servers {
+		if s.State == "online" {
+			h.serversOnline++
+		} else {
+			h.serversOffline++
+		}
+		if h.version == "" {
+			h.version = s.Version
+		}
+		for _, drive := range s.Drives {
+			if drive.State == "ok" {
+				h.drivesOnline++
+			} else {
+				h.drivesOffline++
+			}
+			if drive.Healing {
+				h.drivesHealing++
+			}
+		}
+	}
+	return h, nil
+}
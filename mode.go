@@ -0,0 +1,66 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operating modes - `--mode` sets how Create handles the volumes which don't pass validation. In `strict` mode
+// (the default) Create fails on any error: unknown options, unreachable endpoint, missing bucket, denied
+// credentials. In `permissive` mode the unknown options are ignored, and the volume is created even though its
+// endpoint or bucket can't be checked: the checks are made again by Mount, which fails if they still don't
+// pass. The invalid values of the options are rejected in both modes. `-o create-mode=<mode>` overrides `--mode`
+// for a volume, so that a driver alias (`--alias`) can give a team the mode of its choice. The option isn't named
+// `mode`, which sets the mode of the root of the volume, see `validateOwnership`.
+
+// operating modes of the plugin.
+const (
+	modeStrict     = "strict"
+	modePermissive = "permissive"
+)
+
+// modes accepted by `--mode` and `-o create-mode`.
+var modes = []string{modeStrict, modePermissive}
+
+// returns true if `mode` is a valid operating mode.
+func isValidMode(mode string) bool {
+	return containsString(modes, mode)
+}
+
+// returns the operating mode of the create request, `--mode` unless set with `-o create-mode`.
+func (d *minfsDriver) createMode(options map[string]string) (string, error) {
+	mode, ok := options["create-mode"]
+	if !ok || mode == "" {
+		return d.mode, nil
+	}
+	if !isValidMode(mode) {
+		return "", fmt.Errorf("invalid value %q for create-mode option, must be one of %s.", mode, strings.Join(modes, ", "))
+	}
+	return mode, nil
+}
+
+// returns the error of a check of the volume at Create, nil if the volume is created in permissive mode
+// and the check is left to Mount. The errors of the options are always returned.
+func (d *minfsDriver) deferCheck(mode, name string, err error) error {
+	if err == nil || mode != modePermissive || errorCodeOf(err) == errBadOption {
+		return err
+	}
+	d.log().WithField("volume", name).Warnf("Check of the volume failed, left to Mount in permissive mode. <ERROR> %v", err)
+	return nil
+}
@@ -0,0 +1,65 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// The mode of the root of the volume and the operating mode of Create are set by options of their own.
+func TestCreateModeOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		options map[string]string
+		// substring of the expected error, empty if the volume is created.
+		err  string
+		root string
+	}{
+		{name: "root-mode", mode: modePermissive, options: map[string]string{"mode": "0770"}, root: "0770"},
+		{name: "create-mode", mode: modeStrict, options: map[string]string{"create-mode": modePermissive}},
+		{name: "both", mode: modeStrict, options: map[string]string{"create-mode": modePermissive, "mode": "0750"}, root: "0750"},
+		{name: "invalid-create-mode", mode: modePermissive, options: map[string]string{"create-mode": "0770"}, err: "for create-mode option"},
+		{name: "invalid-root-mode", mode: modePermissive, options: map[string]string{"mode": modePermissive}, err: "octal mode"},
+		{name: "strict", mode: modeStrict, options: map[string]string{"mode": "0770"}, err: "127.0.0.1:1"},
+	}
+	for _, test := range tests {
+		d := newTestDriver(t)
+		d.mode = test.mode
+		options := map[string]string{"endpoint": "http://127.0.0.1:1", "bucket": "test-bucket", "access-key": "access", "secret-key": "secret"}
+		for name, value := range test.options {
+			options[name] = value
+		}
+		res := d.Create(volume.Request{Name: test.name, Options: options})
+		if test.err != "" {
+			if !strings.Contains(res.Err, test.err) {
+				t.Errorf("%s: expected an error about %s, got %q", test.name, test.err, res.Err)
+			}
+			continue
+		}
+		if res.Err != "" {
+			t.Errorf("%s: unexpected error %q", test.name, res.Err)
+			continue
+		}
+		if v := d.mounts[test.name]; v == nil || v.config.mode != test.root {
+			t.Errorf("%s: expected the volume to be created with the mode %q of its root.", test.name, test.root)
+		}
+	}
+}
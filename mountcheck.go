@@ -0,0 +1,111 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// default of `--mount-timeout`.
+	defaultMountTimeout = 30 * time.Second
+	// default of `--mount-poll-interval`, the interval at which the mountpoint is checked while minfs starts.
+	defaultMountPollInterval = 100 * time.Millisecond
+	// table of the mounts of the mount namespace of the plugin.
+	mountInfoFile = "/proc/self/mountinfo"
+)
+
+// returns true if a FUSE filesystem is mounted at `target`.
+// The mount table is read rather than the mountpoint, which would hang on an unresponsive FUSE server.
+func isFuseMounted(target string) bool {
+	f, err := os.Open(mountInfoFile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	target = filepath.Clean(target)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// ex: 36 35 0:31 / /tmp/profile-pic-store rw,nosuid,nodev - fuse.minfs minfs rw,user_id=0
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || unescapeMountPath(fields[4]) != target {
+			continue
+		}
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) {
+				fsType := fields[i+1]
+				if fsType == "fuse" || strings.HasPrefix(fsType, "fuse.") {
+					return true
+				}
+				break
+			}
+		}
+	}
+	return false
+}
+
+// decodes the octal escapes of the paths of the mount table (ex: `\040` for a space).
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if n, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// waits for the FUSE mount of minfs to appear at the mountpoint of the volume, minfs mounts
+// asynchronously and may exit before mounting (ex: invalid credentials).
+// Returns an error telling whether minfs exited, or is still initializing after `--mount-timeout`.
+func (d *minfsDriver) waitMounted(ctx context.Context, v *mountInfo, p *minfsProcess) error {
+	deadline := time.After(d.mountTimeout)
+	ticker := time.NewTicker(d.mountPollInterval)
+	defer ticker.Stop()
+	for !isFuseMounted(v.mountPoint) {
+		select {
+		case <-p.done:
+			if p.exitErr != nil {
+				return fmt.Errorf("minfs exited with an error before mounting %s: %v", v.mountPoint, p.exitErr)
+			}
+			return fmt.Errorf("minfs exited before mounting %s", v.mountPoint)
+		case <-deadline:
+			return fmt.Errorf("minfs is still initializing, %s not mounted within %s", v.mountPoint, d.mountTimeout)
+		case <-ctx.Done():
+			return fmt.Errorf("mount of %s cancelled: %v", v.mountPoint, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
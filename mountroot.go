@@ -0,0 +1,109 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mountRootAllowlist - Directories volumes are allowed to be mounted under with `-o mount-root`, set with
+// `--allowed-mount-roots`. The mountpoint of a volume is removed with the volume, so the mount root has to be
+// one of the directories or a directory under it, and the mountpoint has to resolve under it once the
+// symlinks are followed. The option is refused if the list is empty.
+type mountRootAllowlist []string
+
+// parses the comma separated list of `--allowed-mount-roots`.
+func parseMountRootAllowlist(list string) (mountRootAllowlist, error) {
+	var a mountRootAllowlist
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !filepath.IsAbs(entry) || filepath.Clean(entry) == "/" {
+			return nil, fmt.Errorf("invalid allowed mount root %q, must be an absolute path other than /", entry)
+		}
+		a = append(a, filepath.Clean(entry))
+	}
+	return a, nil
+}
+
+// verifies the mount root of the volume `name`, the root has to be an absolute path outside of the
+// pseudo filesystems of the host, and the mountpoint of the volume has to resolve under an allowed root.
+// Nothing is created, the directories are created when the volume is mounted.
+func (a mountRootAllowlist) verify(root, name string) error {
+	if !filepath.IsAbs(root) || filepath.Clean(root) == "/" {
+		return fmt.Errorf("invalid value %q for mount-root option, must be an absolute path other than /.", root)
+	}
+	for _, dir := range []string{"/proc", "/sys", "/dev"} {
+		if clean := filepath.Clean(root); clean == dir || strings.HasPrefix(clean, dir+"/") {
+			return fmt.Errorf("invalid value %q for mount-root option, must not be under %s.", root, dir)
+		}
+	}
+	if len(a) == 0 {
+		return fmt.Errorf("mount-root option is not allowed, the plugin has no --allowed-mount-roots.")
+	}
+	mountPoint, err := resolvePath(filepath.Join(root, name))
+	if err != nil {
+		return fmt.Errorf("invalid value %q for mount-root option: %v", root, err)
+	}
+	for _, allowed := range a {
+		if !isUnder(filepath.Clean(root), allowed, true) {
+			continue
+		}
+		resolved, err := resolvePath(allowed)
+		if err != nil {
+			return fmt.Errorf("allowed mount root %s can't be resolved: %v", allowed, err)
+		}
+		if isUnder(mountPoint, resolved, false) {
+			return nil
+		}
+		return fmt.Errorf("invalid value %q for mount-root option, the mountpoint of volume %s resolves to %s outside of %s.", root, name, mountPoint, allowed)
+	}
+	return fmt.Errorf("invalid value %q for mount-root option, must be under one of the allowed mount roots %s.", root, strings.Join(a, ", "))
+}
+
+// reports whether the cleaned path is under dir, or is dir itself if `orEqual` is set.
+func isUnder(path, dir string, orEqual bool) bool {
+	return (orEqual && path == dir) || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// returns the path with its symlinks followed, the components which don't exist yet are kept as they are.
+// A dangling symlink is an error, it would be followed once the directories are created.
+func resolvePath(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	}
+	if _, lErr := os.Lstat(path); lErr == nil {
+		return "", err
+	} else if !os.IsNotExist(lErr) {
+		return "", lErr
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	dir, err := resolvePath(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(path)), nil
+}
@@ -0,0 +1,167 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options schema - Every `-o` option supported by the plugin is described in `volumeOptions`, with its
+// type, default and description. Create rejects the options which are not in the schema, so that a typo
+// isn't silently ignored, and the values of the boolean and enumerated options are checked against it
+// before the options are parsed. The schema is served by the admin API (`GET /options`) so that tooling
+// can discover the options of the running version of the plugin.
+
+// types of the options.
+const (
+	optionString   = "string"
+	optionBool     = "bool"
+	optionEnum     = "enum"
+	optionSize     = "size"
+	optionList     = "list"
+	optionTime     = "time"
+	optionOctal    = "octal"
+	optionEndpoint = "endpoint"
+	optionDuration = "duration"
+)
+
+// volumeOption - Description of a `-o` option.
+type volumeOption struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// accepted values of an enumerated option.
+	Values      []string `json:"values,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Description string   `json:"description"`
+}
+
+// schema of the `-o` options, keep it in sync with the options of `createVolume` and the Readme.
+var volumeOptions = []volumeOption{
+	{Name: "endpoint", Type: optionEndpoint, Description: "URL of the Minio server, several comma separated endpoints of a highly available deployment can be set."},
+	{Name: "bucket", Type: optionString, Description: "bucket mounted by the volume, defaults to the name of the volume made a valid bucket name."},
+	{Name: "buckets", Type: optionList, Description: "<bucket>[:ro|:rw] buckets merged into a union volume with mergerfs, instead of bucket."},
+	{Name: "access-key", Type: optionString, Description: "access key of the Minio server."},
+	{Name: "secret-key", Type: optionString, Description: "secret key of the Minio server."},
+	{Name: "access-key-file", Type: optionString, Description: "file holding the access key, relative to --credential-files-dir, instead of access-key."},
+	{Name: "secret-key-file", Type: optionString, Description: "file holding the secret key, relative to --credential-files-dir, instead of secret-key."},
+	{Name: "anonymous", Type: optionBool, Default: "false", Description: "access a public bucket without credentials."},
+	{Name: "clone-from", Type: optionString, Description: "name of an existing volume whose objects are copied into the bucket before the first mount."},
+	{Name: "region", Type: optionString, Default: defaultLocation, Description: "region of the bucket."},
+	{Name: "object-locking", Type: optionBool, Default: "false", Description: "enable object locking on the bucket, if the plugin creates it."},
+	{Name: "vault-path", Type: optionString, Description: "path of the Vault secret holding the credentials."},
+	{Name: "secret-arn", Type: optionString, Description: "ARN of the AWS Secrets Manager secret holding the credentials."},
+	{Name: "ssm-param", Type: optionString, Description: "name of the AWS SSM parameter holding the credentials."},
+	{Name: authTokenOption, Type: optionString, Description: "token required to create volumes with --auth-token-file."},
+	{Name: "rotate-credentials", Type: optionBool, Default: "false", Description: "rotate the credentials of the existing volume of the same name."},
+	{Name: "create-mode", Type: optionEnum, Values: modes, Description: "strict fails Create on any error, permissive ignores the unknown options and leaves the checks of the endpoint and bucket to Mount, defaults to --mode."},
+	{Name: "dry-run", Type: optionBool, Default: "false", Description: "validate the volume without creating it."},
+	{Name: "wait-for-endpoint", Type: optionDuration, Description: "retry the checks of the endpoint and bucket at Create for the given duration (ex: 60s)."},
+	{Name: "signature", Type: optionEnum, Values: []string{signatureV2, signatureV4}, Description: "S3 signature version, defaults to --signature or the version chosen for the endpoint."},
+	{Name: "addressing", Type: optionEnum, Values: []string{addressingPath, addressingVirtualHost}, Default: addressingPath, Description: "bucket addressing style."},
+	{Name: "consistency", Type: optionEnum, Values: []string{consistencyStrict, consistencyCached}, Description: "cache consistency of minfs."},
+	{Name: "watch-changes", Type: optionBool, Default: "false", Description: "see the changes made by other clients, with the strict consistency."},
+	{Name: "propagation", Type: optionEnum, Values: []string{propagationPrivate, propagationRShared, propagationRSlave}, Description: "mount propagation of the mountpoint, inherited from the mount root if empty."},
+	{Name: "selinux-label", Type: optionString, Description: "SELinux context of the mount, auto for the context shared by all the containers."},
+	{Name: "owner", Type: optionString, Description: "owner <uid>[:<gid>] set on the root of the volume."},
+	{Name: "mode", Type: optionOctal, Description: "mode set on the root of the volume."},
+	{Name: "umask", Type: optionOctal, Description: "umask of the files and directories created in the volume."},
+	{Name: "encrypt-cache", Type: optionBool, Default: "false", Description: "keep the minfs cache on an ephemeral dm-crypt device."},
+	{Name: "cache", Type: optionString, Description: "tmpfs[,size=<size>] keeps the minfs cache on a tmpfs."},
+	{Name: "prefetch", Type: optionList, Description: "prefixes of objects read in the background once the volume is mounted."},
+	{Name: "isolate-by-mount-id", Type: optionBool, Default: "false", Description: "give every mount a subdirectory of the bucket named after its ID."},
+	{Name: "flush-on-unmount", Type: optionBool, Default: "false", Description: "wait for the pending uploads before unmounting."},
+	{Name: "memory-limit", Type: optionSize, Description: "memory limit of the minfs process, defaults to --minfs-memory-limit."},
+	{Name: "cpu-quota", Type: optionString, Description: "CPU quota of the minfs process in number of CPUs, defaults to --minfs-cpu-quota."},
+	{Name: "mount-root", Type: optionString, Description: "directory the volume is mounted under, defaults to --mountroot."},
+	{Name: "retain-cache", Type: optionBool, Default: "false", Description: "keep the cache of the volume when it's removed."},
+	{Name: "purge-on-remove", Type: optionBool, Default: "false", Description: "delete the bucket when the volume is removed."},
+	{Name: "force-purge", Type: optionBool, Default: "false", Description: "delete the objects of a non empty bucket on remove."},
+	{Name: "sse-kms-key-id", Type: optionString, Description: "KMS key encrypting the objects written through the volume."},
+	{Name: "expiry-days", Type: optionString, Description: "number of days after which the objects of the bucket expire, set as its lifecycle if the plugin creates it."},
+	{Name: "expiry-rules", Type: optionList, Description: "<prefix>=<days> expiration rules of the objects under the prefixes, set as the lifecycle of the bucket if the plugin creates it."},
+	{Name: "bucket-policy", Type: optionEnum, Values: []string{bucketPolicyPrivate, bucketPolicyDownload, bucketPolicyUpload, bucketPolicyPublic}, Default: bucketPolicyPrivate, Description: "anonymous access policy set on the bucket if the plugin creates it."},
+	{Name: "storage-class", Type: optionString, Description: "storage class of the objects written through the volume."},
+	{Name: "minfs-log-level", Type: optionEnum, Values: []string{minfsLogLevelInfo, minfsLogLevelDebug}, Default: minfsLogLevelInfo, Description: "log level of minfs, debug logs every FUSE operation of the volume."},
+	{Name: "quota", Type: optionSize, Description: "quota of the bucket."},
+	{Name: "quota-action", Type: optionEnum, Values: []string{quotaActionReadOnly, quotaActionWarn}, Default: quotaActionReadOnly, Description: "action taken once the quota is exceeded."},
+	{Name: "proxy", Type: optionString, Description: "HTTP(S) proxy of the requests to the endpoint."},
+	{Name: "no-proxy", Type: optionList, Description: "hosts, domains or CIDRs reached directly rather than through the proxy."},
+	{Name: "s3-trace", Type: optionBool, Default: "false", Description: "trace the requests sent to the endpoint for the volume to <mountroot>/.trace/<volume>.log."},
+	{Name: "host-override", Type: optionList, Description: "<host>=<address> pairs used instead of resolving the hosts, requires --minfs-image."},
+	{Name: "snapshot", Type: optionTime, Description: "serve the bucket read only as it was at the given RFC 3339 time."},
+}
+
+// returns the description of the named option, false if the option is not supported.
+func lookupOption(name string) (volumeOption, bool) {
+	for _, o := range volumeOptions {
+		if o.Name == name {
+			return o, true
+		}
+	}
+	return volumeOption{}, false
+}
+
+// returns the sorted names of the options which are not in the schema.
+func unknownOptions(options map[string]string) []string {
+	var unknown []string
+	for name := range options {
+		if _, ok := lookupOption(name); !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// validates the options of a create request against the schema: the unknown options, and the values of
+// the boolean and enumerated options, are rejected. The values of the other options are validated when
+// they are parsed.
+func validateOptions(options map[string]string) error {
+	if unknown := unknownOptions(options); len(unknown) > 0 {
+		return fmt.Errorf("unknown option(s) %s, see the supported options in the Readme or GET /options of the admin API.", strings.Join(unknown, ", "))
+	}
+	for name, value := range options {
+		if value == "" {
+			continue
+		}
+		o, _ := lookupOption(name)
+		switch o.Type {
+		case optionBool:
+			if _, err := strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("invalid value %q for %s option, must be true or false.", value, name)
+			}
+		case optionEnum:
+			if !containsString(o.Values, value) {
+				return fmt.Errorf("invalid value %q for %s option, must be one of %s.", value, name, strings.Join(o.Values, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// returns true if `s` is in `list`.
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+// Every option of the schema has a name of its own, `lookupOption` only finds the first one.
+func TestVolumeOptionsUniqueNames(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, o := range volumeOptions {
+		if seen[o.Name] {
+			t.Errorf("option %s is described more than once in the schema.", o.Name)
+		}
+		seen[o.Name] = true
+	}
+}
@@ -0,0 +1,191 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Log files of minfs - minfs writes its output to a log file of its own under `<mountroot>/.minfs`, rather
+// than to pipes of the plugin, so that it can outlive the plugin: an adopted minfs (see `adoptMount`) would
+// get SIGPIPE on its next write to the pipes of the dead instance. The plugin follows the file while the
+// process runs, and removes it once the process has exited.
+
+// default number of lines of minfs output retained per volume.
+const defaultOutputLines = 20
+
+// directory of the log files of minfs, under the mount root of the volume.
+const minfsLogDir = ".minfs"
+
+// interval at which the log file of minfs is read.
+const minfsLogPollInterval = 200 * time.Millisecond
+
+// size above which the log file of minfs is truncated once it's read, the lines written in between are lost.
+const minfsLogMaxSize = 1 << 20
+
+// outputTail - Keeps the last lines written by the minfs process of a volume.
+// Every complete line is also logged with the volume name, so that mount
+// errors reported by minfs end up in the plugin log.
+// The tail is reported in the volume status (`docker volume inspect`).
+type outputTail struct {
+	sync.Mutex
+	volume string
+	// maximum number of lines retained.
+	max   int
+	lines []string
+}
+
+// return a new outputTail retaining the last `max` lines written for the volume.
+func newOutputTail(volume string, max int) *outputTail {
+	return &outputTail{volume: volume, max: max}
+}
+
+// records a complete line of output.
+func (t *outputTail) add(stream, line string) {
+	logrus.WithFields(logrus.Fields{
+		"volume": t.volume,
+		"stream": stream,
+	}).Info(line)
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.max <= 0 {
+		return
+	}
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+}
+
+// returns a copy of the retained lines.
+func (t *outputTail) tail() []string {
+	t.Lock()
+	defer t.Unlock()
+
+	return append([]string(nil), t.lines...)
+}
+
+// returns an io.Writer splitting the output of `stream` (stdout/stderr, minfs for its log file) into lines.
+// A new writer has to be used for every process since it buffers partial lines.
+func (t *outputTail) writer(stream string) *lineWriter {
+	return &lineWriter{tail: t, stream: stream}
+}
+
+// lineWriter - io.Writer feeding the complete lines written to it to the outputTail.
+type lineWriter struct {
+	tail   *outputTail
+	stream string
+	buf    bytes.Buffer
+}
+
+// Write - splits `p` into lines, the trailing partial line is kept until it's completed.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := string(bytes.TrimRight(w.buf.Next(i+1), "\r\n"))
+		if line != "" {
+			w.tail.add(w.stream, line)
+		}
+	}
+	return len(p), nil
+}
+
+// records the remaining partial line, called once the process has exited.
+func (w *lineWriter) flush() {
+	if w.buf.Len() > 0 {
+		w.tail.add(w.stream, w.buf.String())
+		w.buf.Reset()
+	}
+}
+
+// creates the log file of a new minfs process of the volume, opened for appending.
+func (d *minfsDriver) createMinfsLog(v *mountInfo) (*os.File, error) {
+	dir := filepath.Join(d.volumeMountRoot(v.config), minfsLogDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	name := filepath.Join(dir, v.name+"."+strconv.FormatInt(time.Now().UnixNano(), 10)+".log")
+	return os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+// returns true if the file is a log file of minfs.
+func isMinfsLog(path string) bool {
+	return filepath.Base(filepath.Dir(path)) == minfsLogDir && filepath.Ext(path) == ".log"
+}
+
+// feeds the lines appended to the log file of minfs after `offset` to the output of the volume, until `exited`
+// is closed. The file is then read a last time and removed, and `followed` is closed.
+func (t *outputTail) follow(path string, offset int64, exited <-chan struct{}) (followed chan struct{}) {
+	followed = make(chan struct{})
+	go func() {
+		defer close(followed)
+		defer os.Remove(path)
+		f, err := os.Open(path)
+		if err != nil {
+			logrus.WithField("volume", t.volume).Errorf("Reading the log file of minfs failed. <ERROR> %v", err)
+			<-exited
+			return
+		}
+		defer f.Close()
+		w := t.writer("minfs")
+		defer w.flush()
+		ticker := time.NewTicker(minfsLogPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-exited:
+				readMinfsLog(f, w, offset)
+				return
+			case <-ticker.C:
+				offset = readMinfsLog(f, w, offset)
+			}
+		}
+	}()
+	return followed
+}
+
+// copies the log file of minfs from `offset` to `w`, returns the offset of the end of the file.
+// The file is truncated once it grew over `minfsLogMaxSize`, minfs appends to it.
+func readMinfsLog(f *os.File, w io.Writer, offset int64) int64 {
+	fi, err := f.Stat()
+	if err != nil {
+		return offset
+	}
+	if fi.Size() < offset {
+		offset = 0
+	}
+	n, _ := io.Copy(w, io.NewSectionReader(f, offset, fi.Size()-offset))
+	offset += n
+	if offset > minfsLogMaxSize && os.Truncate(f.Name(), 0) == nil {
+		offset = 0
+	}
+	return offset
+}
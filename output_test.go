@@ -0,0 +1,71 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The lines appended to the log file of minfs reach the output of the volume, and the file is removed once
+// minfs exited.
+func TestFollowMinfsLog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), minfsLogDir)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "vol.1.log")
+	// the lines written before the log is followed from its end are not reported again.
+	if err := ioutil.WriteFile(path, []byte("previous instance\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !isMinfsLog(path) {
+		t.Fatalf("expected %s to be a log file of minfs.", path)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail := newOutputTail("vol", defaultOutputLines)
+	exited := make(chan struct{})
+	followed := tail.follow(path, int64(len("previous instance\n")), exited)
+	f.WriteString("mounted\n")
+	f.WriteString("exiting")
+	f.Close()
+	close(exited)
+	<-followed
+
+	if lines := tail.tail(); strings.Join(lines, "|") != "mounted|exiting" {
+		t.Errorf("expected the lines written once followed, got %q", lines)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the log file to be removed, got %v", err)
+	}
+}
+
+// A process is running while its command line is unchanged.
+func TestIsProcessRunning(t *testing.T) {
+	if !isProcessRunning(os.Getpid(), os.Args) {
+		t.Error("expected the test process to be running.")
+	}
+	if isProcessRunning(os.Getpid(), []string{"minfs"}) {
+		t.Error("expected a process running another command line not to be reported as running.")
+	}
+}
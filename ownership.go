@@ -0,0 +1,110 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parses the owner option of the form `<uid>[:<gid>]`, the group is left unchanged (-1) if it's not set.
+func parseOwner(owner string) (int, int, error) {
+	parts := strings.SplitN(owner, ":", 2)
+	uid, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid value %q for owner option, must be <uid>[:<gid>].", owner)
+	}
+	gid := -1
+	if len(parts) == 2 {
+		g, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid value %q for owner option, must be <uid>[:<gid>].", owner)
+		}
+		gid = int(g)
+	}
+	return int(uid), gid, nil
+}
+
+// parses the octal mode option (ex: 0770).
+func parseMode(mode string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 07777 {
+		return 0, fmt.Errorf("invalid value %q for mode option, must be an octal mode (ex: 0770).", mode)
+	}
+	// the setuid, setgid and sticky bits have their own os.FileMode flags.
+	fm := os.FileMode(m & 0777)
+	if m&04000 != 0 {
+		fm |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		fm |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		fm |= os.ModeSticky
+	}
+	return fm, nil
+}
+
+// validates the octal umask option (ex: 0022).
+func isValidUmask(umask string) bool {
+	m, err := strconv.ParseUint(umask, 8, 32)
+	return err == nil && m <= 0777
+}
+
+// validates the owner and mode options of the volume.
+func validateOwnership(config serverConfig) error {
+	if config.owner != "" {
+		if _, _, err := parseOwner(config.owner); err != nil {
+			return err
+		}
+	}
+	if config.mode != "" {
+		if _, err := parseMode(config.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applies the owner and mode options to the root of the mountpoint, once the volume is mounted,
+// so that containers running as a specific user can use the volume.
+func applyOwnership(v *mountInfo) error {
+	return applyOwnershipTo(v.config, v.mountPoint)
+}
+
+// sets the owner and mode of the volume on the directory.
+func applyOwnershipTo(config serverConfig, dir string) error {
+	if config.owner != "" {
+		uid, gid, err := parseOwner(config.owner)
+		if err != nil {
+			return err
+		}
+		if err = os.Chown(dir, uid, gid); err != nil {
+			return err
+		}
+	}
+	if config.mode != "" {
+		mode, err := parseMode(config.mode)
+		if err != nil {
+			return err
+		}
+		return os.Chmod(dir, mode)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/sdk"
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// Podman - Podman speaks the volume plugin protocol of Docker, but finds the plugins through the
+// `[engine.volume_plugins]` table of `/etc/containers/containers.conf` rather than in /run/docker/plugins/.
+// With `--podman-socket=<path>` the driver is also served on the given socket, to be listed in that table,
+// with a handler tolerating the differences of the requests of Podman: empty bodies, options under
+// `Options` rather than `Opts` and option values which are not strings. The volumes are shared with the
+// Docker sockets of the plugin.
+
+// largest body of a request of Podman.
+const podmanMaxRequestSize = 1 << 20
+
+// podmanRequest - Union of the volume requests of Podman, see `decodePodmanRequest`.
+type podmanRequest struct {
+	Name    string
+	ID      string
+	Opts    map[string]interface{}
+	Options map[string]interface{}
+}
+
+// podmanHandler - Serves the volume plugin protocol to Podman.
+type podmanHandler struct {
+	driver volume.Driver
+}
+
+// returns the handler serving the driver to Podman.
+func newPodmanHandler(driver volume.Driver) *podmanHandler {
+	return &podmanHandler{driver: driver}
+}
+
+func (h *podmanHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/Plugin.Activate" {
+		w.Header().Set("Content-Type", sdk.DefaultContentTypeV1_1)
+		fmt.Fprintln(w, volumeManifest)
+		return
+	}
+	req, err := decodePodmanRequest(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var res volume.Response
+	switch r.URL.Path {
+	case "/VolumeDriver.Create":
+		res = h.driver.Create(req.volumeRequest())
+	case "/VolumeDriver.Get":
+		res = h.driver.Get(req.volumeRequest())
+	case "/VolumeDriver.List":
+		res = h.driver.List(req.volumeRequest())
+	case "/VolumeDriver.Remove":
+		res = h.driver.Remove(req.volumeRequest())
+	case "/VolumeDriver.Path":
+		res = h.driver.Path(req.volumeRequest())
+	case "/VolumeDriver.Mount":
+		res = h.driver.Mount(volume.MountRequest{Name: req.Name, ID: req.ID})
+	case "/VolumeDriver.Unmount":
+		res = h.driver.Unmount(volume.UnmountRequest{Name: req.Name, ID: req.ID})
+	case "/VolumeDriver.Capabilities":
+		res = h.driver.Capabilities(req.volumeRequest())
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	encodeVolumeResponse(w, res)
+}
+
+// decodes a request of Podman, an empty body is an empty request.
+func decodePodmanRequest(body io.Reader) (podmanRequest, error) {
+	var req podmanRequest
+	data, err := ioutil.ReadAll(io.LimitReader(body, podmanMaxRequestSize))
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return req, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// numbers are kept as written rather than converted to floats.
+	decoder.UseNumber()
+	if err := decoder.Decode(&req); err != nil {
+		return req, fmt.Errorf("invalid request: %v", err)
+	}
+	return req, nil
+}
+
+// returns the request of the driver, the option values are converted to strings.
+func (req podmanRequest) volumeRequest() volume.Request {
+	r := volume.Request{Name: req.Name}
+	for _, opts := range []map[string]interface{}{req.Options, req.Opts} {
+		for k, v := range opts {
+			if r.Options == nil {
+				r.Options = make(map[string]string)
+			}
+			switch v := v.(type) {
+			case nil:
+				r.Options[k] = ""
+			case string:
+				r.Options[k] = v
+			default:
+				r.Options[k] = fmt.Sprint(v)
+			}
+		}
+	}
+	return r
+}
+
+// serves the driver to Podman on the unix socket at the path until it fails.
+func servePodman(driver volume.Driver, path string) error {
+	l, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("serving Podman on %s, add it to /etc/containers/containers.conf:\n[engine.volume_plugins]\n%s = %q", path, pluginName, path)
+	return http.Serve(l, newPodmanHandler(driver))
+}
@@ -0,0 +1,91 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+func TestDecodePodmanRequest(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    string
+		want    volume.Request
+		id      string
+		invalid bool
+	}{
+		{name: "empty body", body: ""},
+		{name: "blank body", body: " \n\t"},
+		{name: "empty object", body: "{}"},
+		{
+			name: "docker options",
+			body: `{"Name": "v", "Opts": {"bucket": "b"}}`,
+			want: volume.Request{Name: "v", Options: map[string]string{"bucket": "b"}},
+		},
+		{
+			name: "podman options",
+			body: `{"Name": "v", "Options": {"bucket": "b"}}`,
+			want: volume.Request{Name: "v", Options: map[string]string{"bucket": "b"}},
+		},
+		{
+			name: "both options",
+			body: `{"Name": "v", "Options": {"bucket": "b"}, "Opts": {"region": "r"}}`,
+			want: volume.Request{Name: "v", Options: map[string]string{"bucket": "b", "region": "r"}},
+		},
+		{
+			name: "non string values",
+			body: `{"Name": "v", "Options": {"quota": 1073741824, "ratio": 0.5, "readonly": true, "owner": null}}`,
+			want: volume.Request{Name: "v", Options: map[string]string{
+				"quota":    "1073741824",
+				"ratio":    "0.5",
+				"readonly": "true",
+				"owner":    "",
+			}},
+		},
+		{
+			name: "mount",
+			body: `{"Name": "v", "ID": "abc"}`,
+			want: volume.Request{Name: "v"},
+			id:   "abc",
+		},
+		{name: "invalid json", body: `{"Name": `, invalid: true},
+		{name: "invalid options", body: `{"Name": "v", "Options": ["bucket"]}`, invalid: true},
+	}
+	for _, c := range cases {
+		req, err := decodePodmanRequest(strings.NewReader(c.body))
+		if c.invalid {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := req.volumeRequest(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.want, got)
+		}
+		if req.ID != c.id {
+			t.Errorf("%s: expected ID %q, got %q", c.name, c.id, req.ID)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Prefetch - With `-o prefetch=<prefix>[,<prefix>...]` the objects under the prefixes are read through
+// the mount in the background once the volume is mounted, so that minfs fetches them into its cache
+// before the containers first read them. The progress is reported in the status of the volume.
+
+// Prefetch states.
+const (
+	prefetchRunning = "running"
+	prefetchDone    = "done"
+	prefetchFailed  = "failed"
+	prefetchStopped = "stopped"
+)
+
+// returned by the prefetch when the volume is unmounted before it's done.
+var errPrefetchStopped = errors.New("prefetch stopped")
+
+// prefetchProgress - Progress of the prefetch of a mount of the volume.
+type prefetchProgress struct {
+	// closed to stop the prefetch, when the volume is unmounted.
+	stop chan struct{}
+
+	sync.Mutex
+	state    string
+	started  time.Time
+	finished time.Time
+	files    int
+	bytes    int64
+	err      string
+}
+
+// parses the prefetch option into the list of prefixes, relative to the root of the bucket.
+func parsePrefetchOption(option string) ([]string, error) {
+	if option == "" {
+		return nil, nil
+	}
+	var prefixes []string
+	for _, prefix := range strings.Split(option, ",") {
+		prefix = strings.TrimPrefix(strings.TrimSpace(prefix), "/")
+		if prefix == "" {
+			// the whole bucket.
+			prefixes = append(prefixes, "")
+			continue
+		}
+		if strings.HasPrefix(path.Clean(prefix), "..") {
+			return nil, fmt.Errorf("invalid value %q for prefetch option, the prefixes must be within the bucket.", option)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// starts prefetching the prefixes of the volume in the background, once it's mounted.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) startPrefetch(v *mountInfo) {
+	if len(v.config.prefetch) == 0 {
+		return
+	}
+	p := &prefetchProgress{
+		stop:    make(chan struct{}),
+		state:   prefetchRunning,
+		started: time.Now(),
+	}
+	v.prefetch = p
+	go func() {
+		err := prefetchPrefixes(v.mountPoint, v.config.prefetch, p)
+		p.Lock()
+		defer p.Unlock()
+		p.finished = time.Now()
+		switch err {
+		case nil:
+			p.state = prefetchDone
+		case errPrefetchStopped:
+			p.state = prefetchStopped
+		default:
+			p.state = prefetchFailed
+			p.err = err.Error()
+			logrus.WithField("volume", v.name).Errorf("Prefetching the volume failed. <ERROR> %v", err)
+			return
+		}
+		logrus.WithFields(logrus.Fields{
+			"volume": v.name,
+			"files":  p.files,
+			"bytes":  p.bytes,
+		}).Infof("Prefetch %s.", p.state)
+	}()
+}
+
+// stops the prefetch of the volume, when it's unmounted.
+// Has to be called with the driver lock held.
+func stopPrefetch(v *mountInfo) {
+	if v.prefetch == nil {
+		return
+	}
+	select {
+	case <-v.prefetch.stop:
+	default:
+		close(v.prefetch.stop)
+	}
+}
+
+// reads the files under the prefixes through the mountpoint.
+func prefetchPrefixes(mountPoint string, prefixes []string, p *prefetchProgress) error {
+	for _, prefix := range prefixes {
+		// the prefix may end in the middle of a name (ex: `models/v1-`), the directory holding it is walked.
+		root := filepath.Join(mountPoint, path.Dir(prefix+"_"))
+		err := filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+			select {
+			case <-p.stop:
+				return errPrefetchStopped
+			default:
+			}
+			// nothing to prefetch if there are no objects under the prefix.
+			if os.IsNotExist(err) && name == root {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(mountPoint, name)
+			rel = filepath.ToSlash(rel)
+			if info.IsDir() {
+				// skip the directories which are neither a parent of the prefix nor under it.
+				if rel != "." && !strings.HasPrefix(prefix, rel+"/") && !strings.HasPrefix(rel, prefix) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasPrefix(rel, prefix) || !info.Mode().IsRegular() {
+				return nil
+			}
+			n, err := prefetchFile(name, p.stop)
+			p.Lock()
+			p.files++
+			p.bytes += n
+			p.Unlock()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reads the file so that minfs caches it, returns the number of bytes read.
+func prefetchFile(name string, stop chan struct{}) (int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var total int64
+	for {
+		select {
+		case <-stop:
+			return total, errPrefetchStopped
+		default:
+		}
+		n, err := io.CopyN(ioutil.Discard, f, 1<<20)
+		total += n
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// status - progress of the prefetch reported in the status of the volume.
+func (p *prefetchProgress) status() map[string]interface{} {
+	p.Lock()
+	defer p.Unlock()
+
+	status := map[string]interface{}{
+		"state":   p.state,
+		"started": p.started.Format(time.RFC3339),
+		"files":   p.files,
+		"bytes":   p.bytes,
+	}
+	if !p.finished.IsZero() {
+		status["finished"] = p.finished.Format(time.RFC3339)
+	}
+	if p.err != "" {
+		status["error"] = p.err
+	}
+	return status
+}
@@ -0,0 +1,70 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Mount propagation modes of the volumes, set with `-o propagation=<mode>`.
+// The mountpoint is bind mounted onto itself with the requested propagation before minfs mounts
+// the bucket on top of it, so that the FUSE mount doesn't inherit the propagation of the mount root.
+const (
+	propagationPrivate = "private"
+	propagationRShared = "rshared"
+	propagationRSlave  = "rslave"
+)
+
+// validates the propagation mode, empty keeps the propagation of the mount root.
+func isValidPropagation(propagation string) bool {
+	switch propagation {
+	case "", propagationPrivate, propagationRShared, propagationRSlave:
+		return true
+	}
+	return false
+}
+
+// makes the mountpoint of the volume a mount of its own with the propagation of the volume.
+func setPropagation(ctx context.Context, v *mountInfo) error {
+	if v.config.propagation == "" {
+		return nil
+	}
+	if err := bindMount(ctx, v.mountPoint, v.mountPoint); err != nil {
+		return err
+	}
+	out, err := exec.CommandContext(ctx, "mount", "--make-"+v.config.propagation, v.mountPoint).CombinedOutput()
+	if err != nil {
+		lazyUnmount(v.mountPoint)
+		return fmt.Errorf("setting %s propagation on %s failed: %v %s", v.config.propagation, v.mountPoint, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// removes the bind mount of the mountpoint made by `setPropagation`, once the volume is unmounted.
+func clearPropagation(v *mountInfo) {
+	if v.config.propagation == "" {
+		return
+	}
+	if err := lazyUnmount(v.mountPoint); err != nil {
+		logrus.WithField("volume", v.name).Debugf("Unmounting the propagation bind mount failed. <ERROR> %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/docker/go-plugins-helpers/sdk"
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// Volume plugin protocol - The requests of Docker are decoded into the types of go-plugins-helpers, whose
+// vendored revision predates the `CreatedAt` field of the volumes. The responses are encoded by the plugin,
+// with the creation time the driver reports as `createdAt` in the status of the volumes, so that Docker
+// shows it in `docker volume inspect`.
+
+// manifest of the plugin returned on activation.
+const volumeManifest = `{"Implements": ["VolumeDriver"]}`
+
+// protocolVolume - volume.Volume with its creation time.
+type protocolVolume struct {
+	Name       string
+	Mountpoint string
+	CreatedAt  string `json:",omitempty"`
+	Status     map[string]interface{}
+}
+
+// protocolResponse - volume.Response whose volumes carry their creation time.
+type protocolResponse struct {
+	Mountpoint   string
+	Err          string
+	Volumes      []*protocolVolume
+	Volume       *protocolVolume
+	Capabilities volume.Capability
+}
+
+// returns the volume of a response with its creation time.
+func newProtocolVolume(v *volume.Volume) *protocolVolume {
+	if v == nil {
+		return nil
+	}
+	createdAt, _ := v.Status["createdAt"].(string)
+	return &protocolVolume{Name: v.Name, Mountpoint: v.Mountpoint, CreatedAt: createdAt, Status: v.Status}
+}
+
+// writes the response of the driver.
+func encodeVolumeResponse(w http.ResponseWriter, res volume.Response) {
+	out := protocolResponse{
+		Mountpoint:   res.Mountpoint,
+		Err:          res.Err,
+		Volume:       newProtocolVolume(res.Volume),
+		Capabilities: res.Capabilities,
+	}
+	for _, v := range res.Volumes {
+		out.Volumes = append(out.Volumes, newProtocolVolume(v))
+	}
+	sdk.EncodeResponse(w, out, res.Err)
+}
+
+// returns the handler serving the driver to Docker.
+func newVolumeHandler(driver volume.Driver) sdk.Handler {
+	h := sdk.NewHandler(volumeManifest)
+	requests := map[string]func(volume.Request) volume.Response{
+		"/VolumeDriver.Create":       driver.Create,
+		"/VolumeDriver.Get":          driver.Get,
+		"/VolumeDriver.List":         driver.List,
+		"/VolumeDriver.Remove":       driver.Remove,
+		"/VolumeDriver.Path":         driver.Path,
+		"/VolumeDriver.Capabilities": driver.Capabilities,
+	}
+	for path, serve := range requests {
+		serve := serve
+		h.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			var req volume.Request
+			if err := sdk.DecodeRequest(w, r, &req); err != nil {
+				return
+			}
+			encodeVolumeResponse(w, serve(req))
+		})
+	}
+	h.HandleFunc("/VolumeDriver.Mount", func(w http.ResponseWriter, r *http.Request) {
+		var req volume.MountRequest
+		if err := sdk.DecodeRequest(w, r, &req); err != nil {
+			return
+		}
+		encodeVolumeResponse(w, driver.Mount(req))
+	})
+	h.HandleFunc("/VolumeDriver.Unmount", func(w http.ResponseWriter, r *http.Request) {
+		var req volume.UnmountRequest
+		if err := sdk.DecodeRequest(w, r, &req); err != nil {
+			return
+		}
+		encodeVolumeResponse(w, driver.Unmount(req))
+	})
+	return h
+}
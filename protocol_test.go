@@ -0,0 +1,49 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// The volumes returned to Docker carry their creation time.
+func TestEncodeVolumeResponseCreatedAt(t *testing.T) {
+	status := map[string]interface{}{"createdAt": "2017-01-30T15:04:05Z"}
+	responses := map[string]volume.Response{
+		"get":  {Volume: &volume.Volume{Name: "dated", Status: status}},
+		"list": {Volumes: []*volume.Volume{{Name: "dated", Status: status}}},
+	}
+	for name, r := range responses {
+		w := httptest.NewRecorder()
+		encodeVolumeResponse(w, r)
+		var res protocolResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		vol := res.Volume
+		if len(res.Volumes) == 1 {
+			vol = res.Volumes[0]
+		}
+		if vol == nil || vol.CreatedAt != "2017-01-30T15:04:05Z" {
+			t.Errorf("%s: expected the creation time of the volume, got %+v", name, res)
+		}
+	}
+}
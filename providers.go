@@ -0,0 +1,149 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Credential providers - The credentials of a volume can be fetched from a secret store configured on
+// the plugin (ex: `-o vault-path=secret/data/minio/team-a`) instead of being passed as options.
+// The credentials are cached for the lease of the secret, or `--credential-refresh-interval` if the
+// secret has no lease, and fetched again once expired. The volumes whose credentials changed are
+// remounted one at a time with the new credentials.
+
+// interval at which the expiry of the cached credentials is checked.
+const credentialExpiryCheckInterval = 30 * time.Second
+
+// credentialProvider - A secret store holding the credentials of volumes.
+type credentialProvider interface {
+	// returns the credentials stored at `ref` and how long they can be cached, 0 if the secret has no lease.
+	fetch(ref string) (accessKey, secretKey string, ttl time.Duration, err error)
+}
+
+// options referring to the credentials of a secret store, mapped to the name of the provider.
+var credentialProviderOptions = map[string]string{
+	"vault-path": "vault",
+	"secret-arn": awsSecretsManager,
+	"ssm-param":  awsSSM,
+}
+
+// resolves the option referring to a credential provider of the create request into the
+// `access-key` and `secret-key` options. Returns the provider, the reference of the secret
+// and the time until which the credentials can be cached, the provider is empty if not used.
+func (d *minfsDriver) credentialProviderOptions(options map[string]string) (string, string, time.Time, error) {
+	var provider, ref string
+	for option, name := range credentialProviderOptions {
+		if options[option] == "" {
+			continue
+		}
+		if provider != "" {
+			return "", "", time.Time{}, newCodedError(errBadOption, "only one credential provider option can be set.")
+		}
+		provider, ref = name, options[option]
+	}
+	if provider == "" {
+		return "", "", time.Time{}, nil
+	}
+	if options["access-key"] != "" || options["secret-key"] != "" || options["access-key-file"] != "" {
+		return "", "", time.Time{}, newCodedError(errBadOption, "access-key, secret-key and credential files cannot be set with a credential provider.")
+	}
+	accessKey, secretKey, expire, err := d.fetchCredentials(provider, ref)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	options["access-key"], options["secret-key"] = accessKey, secretKey
+	return provider, ref, expire, nil
+}
+
+// fetches the credentials from the provider, returns them with the time until which they can be cached.
+func (d *minfsDriver) fetchCredentials(provider, ref string) (string, string, time.Time, error) {
+	p, ok := d.providers[provider]
+	if !ok {
+		return "", "", time.Time{}, newCodedError(errBadOption, "credential provider %s is not configured on the plugin", provider)
+	}
+	accessKey, secretKey, ttl, err := p.fetch(ref)
+	if err != nil {
+		return "", "", time.Time{}, newCodedError(errAuthFailed, "unable to fetch the credentials %s from %s: %v", ref, provider, err)
+	}
+	if accessKey == "" || secretKey == "" {
+		return "", "", time.Time{}, newCodedError(errAuthFailed, "credentials %s of %s are missing the access or secret key", ref, provider)
+	}
+	if ttl <= 0 {
+		ttl = d.credentialRefreshInterval
+	}
+	return accessKey, secretKey, time.Now().Add(ttl), nil
+}
+
+// sets the credentials of the volume from its provider if the cached credentials expired,
+// the volume is remounted if the credentials changed while it's mounted.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) refreshProviderCredentials(v *mountInfo) error {
+	if v.config.credentialProvider == "" || time.Now().Before(v.credentialsExpire) {
+		return nil
+	}
+	var accessKey, secretKey string
+	var expire time.Time
+	err := d.unlocked(func(context.Context) (err error) {
+		accessKey, secretKey, expire, err = d.fetchCredentials(v.config.credentialProvider, v.config.credentialRef)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	v.credentialsExpire = expire
+	if accessKey == v.config.accessKey && secretKey == v.config.secretKey {
+		return nil
+	}
+	return d.rotateCredentials(v, accessKey, secretKey)
+}
+
+// fetches the expired credentials of the volumes again, until the plugin exits.
+// The volumes are processed one at a time, releasing the driver lock in between.
+func (d *minfsDriver) refreshCredentialsLoop() {
+	ticker := time.NewTicker(credentialExpiryCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.RLock()
+		var names []string
+		now := time.Now()
+		for name, v := range d.mounts {
+			if v.config.credentialProvider != "" && !now.Before(v.credentialsExpire) {
+				names = append(names, name)
+			}
+		}
+		d.RUnlock()
+		sort.Strings(names)
+
+		for _, name := range names {
+			v := d.lockVolume(name)
+			if v == nil {
+				continue
+			}
+			d.Lock()
+			if err := d.refreshProviderCredentials(v); err != nil {
+				logrus.WithField("volume", name).Errorf("Refreshing the credentials failed. <ERROR> %v", err)
+			}
+			d.Unlock()
+			v.ops.Unlock()
+		}
+	}
+}
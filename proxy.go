@@ -0,0 +1,131 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Proxies - With `-o proxy=<url>`, the requests to the endpoint of a volume go through the given HTTP(S)
+// proxy, except for the hosts of `-o no-proxy=<host>,...`. The requests of the plugin use the proxy of the
+// volume, and minfs is given it as HTTP_PROXY, HTTPS_PROXY and NO_PROXY. Volumes without a proxy use the
+// proxy of the environment of the plugin, if any.
+
+// validates the proxy option, an http or https URL.
+func validateProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("invalid value %q for proxy option, must be http(s)://[<user>:<password>@]<host>[:<port>] (ex: http://proxy.corp:3128).", proxy)
+	}
+	return nil
+}
+
+// validates the no-proxy option, a comma separated list of hosts, domains (ex: .corp) or CIDRs.
+func validateNoProxy(noProxy string) error {
+	for _, entry := range splitNoProxy(noProxy) {
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return fmt.Errorf("invalid no-proxy entry %q, must be a host, a domain (ex: .corp) or a CIDR.", entry)
+			}
+		} else if strings.ContainsAny(entry, " \t\"") {
+			return fmt.Errorf("invalid no-proxy entry %q, must be a host, a domain (ex: .corp) or a CIDR.", entry)
+		}
+	}
+	return nil
+}
+
+// returns the entries of the no-proxy option.
+func splitNoProxy(noProxy string) []string {
+	var entries []string
+	for _, entry := range strings.Split(noProxy, ",") {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// returns true if the host, with or without its port, is reached directly as per the no-proxy entries.
+func bypassesProxy(host string, noProxy []string) bool {
+	hostname := strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = strings.ToLower(h)
+	}
+	ip := net.ParseIP(hostname)
+	for _, entry := range noProxy {
+		switch {
+		case entry == "*":
+			return true
+		case strings.Contains(entry, "/"):
+			_, network, err := net.ParseCIDR(entry)
+			if err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+		case entry == hostname || entry == strings.ToLower(host):
+			return true
+		// a domain matches its subdomains, with or without the leading period.
+		case strings.HasSuffix(hostname, "."+strings.TrimPrefix(entry, ".")):
+			return true
+		}
+	}
+	return false
+}
+
+// returns the proxy function of the requests to the endpoint of the volume, the proxy of the environment
+// of the plugin if the volume has none.
+func proxyFunc(config serverConfig) func(*http.Request) (*url.URL, error) {
+	if config.proxy == "" {
+		return http.ProxyFromEnvironment
+	}
+	// the proxy is validated by the create request and on import.
+	proxy, _ := url.Parse(config.proxy)
+	noProxy := splitNoProxy(config.noProxy)
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassesProxy(req.URL.Host, noProxy) {
+			return nil, nil
+		}
+		return proxy, nil
+	}
+}
+
+// returns the environment of minfs using the proxy of the volume, none if it has no proxy.
+func proxyEnv(config serverConfig) []string {
+	if config.proxy == "" {
+		return nil
+	}
+	var env []string
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		env = append(env, name+"="+config.proxy)
+	}
+	return append(env, "NO_PROXY="+config.noProxy, "no_proxy="+config.noProxy)
+}
+
+// returns the proxy of the volume without its password, to be reported in the status.
+func redactProxy(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil || u.User == nil {
+		return proxy
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
@@ -0,0 +1,88 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Purging buckets - With `-o purge-on-remove=true`, the bucket of the volume is deleted when the volume
+// is removed. As a guardrail against wiping production data with `docker volume rm`, only empty buckets
+// are deleted unless the volume is created with `-o force-purge=true`, which deletes the objects of the
+// bucket first. Non empty buckets, and buckets used by other volumes, are kept and logged, the volume
+// is removed anyway.
+
+// deletes the bucket of the removed volume if the volume purges it, returns the number of deleted objects.
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while the
+// bucket is purged.
+func (d *minfsDriver) purgeBucket(v *mountInfo) (n int, err error) {
+	if !v.config.purgeOnRemove {
+		return 0, nil
+	}
+	fields := logrus.Fields{
+		"volume":   v.name,
+		"endpoint": v.config.endpoint,
+		"bucket":   v.config.bucket,
+	}
+	// the bucket is kept while other volumes use it.
+	for _, other := range d.mounts {
+		if other != v && other.config.endpoint == v.config.endpoint && other.config.bucket == v.config.bucket {
+			d.log().WithFields(fields).Warnf("Bucket not purged, it's used by volume %s.", other.name)
+			return 0, nil
+		}
+	}
+	err = d.unlocked(func(ctx context.Context) (err error) {
+		n, err = purgeObjects(ctx, v.config, fields)
+		return err
+	})
+	return n, err
+}
+
+// deletes the objects of the bucket, if it's empty or the volume was created with force-purge,
+// and the bucket. Returns the number of deleted objects.
+func purgeObjects(ctx context.Context, config serverConfig, fields logrus.Fields) (int, error) {
+	minioClient, err := newMinioClient(config)
+	if err != nil {
+		return 0, err
+	}
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	var objects []string
+	for object := range minioClient.ListObjectsV2(config.bucket, "", true, doneCh) {
+		if object.Err != nil {
+			return 0, newCodedError(errorCodeOf(object.Err), "listing bucket %s failed: %v", config.bucket, object.Err)
+		}
+		objects = append(objects, object.Key)
+	}
+	if len(objects) > 0 && !config.forcePurge {
+		requestLog(ctx).WithFields(fields).Warnf("Bucket not purged, it holds %d objects and the volume wasn't created with force-purge.", len(objects))
+		return 0, nil
+	}
+	for i, object := range objects {
+		if err := minioClient.RemoveObject(config.bucket, object); err != nil {
+			return i, newCodedError(errorCodeOf(err), "deleting %s/%s failed: %v", config.bucket, object, err)
+		}
+	}
+	if err := minioClient.RemoveBucket(config.bucket); err != nil {
+		return len(objects), newCodedError(errorCodeOf(err), "deleting bucket %s failed: %v", config.bucket, err)
+	}
+	requestLog(ctx).WithFields(fields).Infof("Bucket purged, %d objects deleted.", len(objects))
+	return len(objects), nil
+}
@@ -0,0 +1,159 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Quotas - With `-o quota=<size>`, the usage of the bucket of the volume (the total size of its objects)
+// is computed every `--quota-interval` and compared to the quota. Once the quota is exceeded, the volume
+// is remounted read only (`-o quota-action=readonly`, the default) or a warning is logged
+// (`-o quota-action=warn`). The volume is remounted read-write once its usage is under the quota again.
+// The usage and the quota are reported in the status of the volume and as metrics.
+// A volume bound to a shared mount (`--share-mounts`) is never remounted, only warned about.
+
+// actions taken once the quota of a volume is exceeded, set with `-o quota-action`.
+const (
+	quotaActionReadOnly = "readonly"
+	quotaActionWarn     = "warn"
+)
+
+// default of `--quota-interval`.
+const defaultQuotaInterval = time.Minute
+
+// volumeQuota - Usage of the bucket of a volume with a quota, updated every `--quota-interval`.
+type volumeQuota struct {
+	// total size of the objects of the bucket, in bytes.
+	usage int64
+	// time and error of the last computation of the usage.
+	checked time.Time
+	lastErr string
+	// set while the usage is over the quota.
+	exceeded bool
+}
+
+// parses the quota and quota-action options, returns the quota in bytes, zero without quota.
+func parseQuotaOptions(quota, action string) (int64, string, error) {
+	if quota == "" {
+		if action != "" {
+			return 0, "", fmt.Errorf("quota-action option requires the quota option.")
+		}
+		return 0, "", nil
+	}
+	size, err := parseMemoryLimit(quota)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid value %q for quota option, must be a size in bytes with an optional K, M or G suffix (ex: 50G).", quota)
+	}
+	switch action {
+	case "":
+		action = quotaActionReadOnly
+	case quotaActionReadOnly, quotaActionWarn:
+	default:
+		return 0, "", fmt.Errorf("invalid value %q for quota-action option, must be readonly or warn.", action)
+	}
+	return size, action, nil
+}
+
+// returns true if the volume is mounted read only since it exceeded its quota.
+func (v *mountInfo) quotaReadOnly() bool {
+	return v.quota.exceeded && v.config.quotaAction == quotaActionReadOnly && v.shared == nil
+}
+
+// computes the usage of the volumes with a quota every `interval`, until the plugin exits.
+func (d *minfsDriver) checkQuotas(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		// list the buckets without holding the lock, the volumes may be used in the meantime.
+		d.RLock()
+		configs := make(map[string]serverConfig)
+		for name, v := range d.mounts {
+			if v.config.quota > 0 && v.config.snapshot.IsZero() {
+				configs[name] = v.config
+			}
+		}
+		d.RUnlock()
+
+		names := make([]string, 0, len(configs))
+		for name := range configs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			stats, err := bucketStats(configs[name], time.Time{})
+			d.recordUsage(name, stats.bytes, err)
+		}
+	}
+}
+
+// updates the usage of the volume, remounting it if it crossed its quota.
+func (d *minfsDriver) recordUsage(name string, usage int64, err error) {
+	v := d.lockVolume(name)
+	if v == nil {
+		return
+	}
+	defer v.ops.Unlock()
+	d.Lock()
+	defer d.Unlock()
+
+	q := &v.quota
+	q.checked = time.Now()
+	if err != nil {
+		q.lastErr = err.Error()
+		logrus.WithField("volume", name).Warnf("Computing the usage of the bucket failed. <ERROR> %v", err)
+		return
+	}
+	q.lastErr = ""
+	q.usage = usage
+	driverMetrics.set(metricQuotaBytes, labels{"volume": name}, float64(v.config.quota))
+	driverMetrics.set(metricUsageBytes, labels{"volume": name}, float64(usage))
+
+	exceeded := usage > v.config.quota
+	if exceeded == q.exceeded {
+		return
+	}
+	fields := logrus.Fields{
+		"volume": name,
+		"usage":  usage,
+		"quota":  v.config.quota,
+	}
+	wasReadOnly := v.quotaReadOnly()
+	q.exceeded = exceeded
+	if exceeded {
+		logrus.WithFields(fields).Warn("Quota of the volume exceeded.")
+	} else {
+		logrus.WithFields(fields).Info("Usage of the volume is under its quota again.")
+	}
+	// the volumes not served by minfs are mounted read only, or not, on their next mount.
+	if v.quotaReadOnly() == wasReadOnly || v.proc == nil {
+		return
+	}
+	reason := "quota exceeded"
+	if !exceeded {
+		reason = "usage under quota"
+	}
+	if err := d.remountVolume(v, reason); err != nil {
+		logrus.WithFields(fields).Errorf("Remounting the volume failed. <ERROR> %v", err)
+		return
+	}
+	logrus.WithFields(fields).WithField("readOnly", v.quotaReadOnly()).Info("Volume remounted.")
+}
@@ -0,0 +1,108 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Automatic read only mounts - With `--check-write-access`, the plugin verifies on create and on mount
+// that the credentials of the volume can write to its bucket, by writing and removing an empty object.
+// The check is opt-in since it modifies the buckets: it triggers their notifications, adds a version to
+// the versioned ones and can't be undone in the buckets with object locking. If the writes are denied, the bucket is mounted read only and the status of the volume
+// tells why, rather than the containers failing with EIO on their first write.
+
+// object written to verify the write access to the bucket.
+const writeCheckObject = ".minfs-write-check"
+
+// returns true if the credentials of the volume can write to its bucket, false if the writes are denied.
+func hasWriteAccess(config serverConfig) (bool, error) {
+	err := putWriteCheck(config)
+	if err != nil {
+		if errorCodeOf(err) == errAuthFailed {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, removeWriteCheck(config)
+}
+
+// writes the empty object verifying the write access to the bucket.
+func putWriteCheck(config serverConfig) error {
+	if useS3Request(config) {
+		resp, err := s3Request(config, "PUT", writeCheckObject, nil, config.region, writeHeaders(config), nil)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	minioClient, err := newMinioClient(config)
+	if err != nil {
+		return err
+	}
+	metadata := map[string][]string{"Content-Type": {"application/octet-stream"}}
+	for k, v := range writeHeaders(config) {
+		metadata[k] = []string{v}
+	}
+	_, err = minioClient.PutObjectWithMetadata(config.bucket, writeCheckObject, bytes.NewReader(nil), metadata, nil)
+	return err
+}
+
+// removes the object written by `putWriteCheck`.
+func removeWriteCheck(config serverConfig) error {
+	if useS3Request(config) {
+		resp, err := s3Request(config, "DELETE", writeCheckObject, nil, config.region, nil, nil)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	minioClient, err := newMinioClient(config)
+	if err != nil {
+		return err
+	}
+	return minioClient.RemoveObject(config.bucket, writeCheckObject)
+}
+
+// verifies the write access of the volume to its bucket, the volume is mounted read only if it
+// has none. Nothing is verified with `--check-write-access=false`.
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while
+// the access is verified.
+func (d *minfsDriver) checkWriteAccess(v *mountInfo) error {
+	if !d.checkWrites {
+		return nil
+	}
+	var writable bool
+	err := d.unlocked(func(context.Context) (err error) {
+		writable, err = hasWriteAccess(v.config)
+		return err
+	})
+	if err != nil {
+		return newCodedError(errorCodeOf(err), "unable to verify the write access to bucket %s: %v", v.config.bucket, err)
+	}
+	if !writable && !v.readOnly {
+		d.log().WithFields(logrus.Fields{
+			"volume": v.name,
+			"bucket": v.config.bucket,
+		}).Warn("Writes to the bucket are denied, mounting it read only.")
+	}
+	v.readOnly = !writable
+	return nil
+}
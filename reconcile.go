@@ -0,0 +1,94 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Connection repair - A container killed with SIGKILL or a crash of the Docker daemon can leave the
+// connections of a volume above the number of containers actually using it, and the volume can then
+// never be removed. Every `--reconcile-interval`, the plugin lists the live containers using the mounted
+// volumes with the Docker API and lowers the connections of the volumes to that number, unmounting the
+// volumes no container uses anymore. The volumes mounted during the last interval are left alone, their
+// containers may not be listed yet.
+
+// reconciles the connections of the volumes with the live containers every interval.
+func (d *minfsDriver) reconcileConnections(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		// the containers are listed without holding the lock.
+		d.RLock()
+		var names []string
+		for name, v := range d.mounts {
+			if v.connections > 0 && time.Since(v.lastMounted) > interval {
+				names = append(names, name)
+			}
+		}
+		d.RUnlock()
+
+		sort.Strings(names)
+		for _, name := range names {
+			containers, err := d.dockerAPI.volumeContainers(name)
+			if err != nil {
+				// the daemon is unreachable, the connections are reconciled on the next interval.
+				logrus.Debugf("Unable to list the containers using the volumes. <ERROR> %v", err)
+				break
+			}
+			d.repairConnections(name, containers, interval)
+		}
+	}
+}
+
+// lowers the connections of the volume to the number of live containers using it.
+func (d *minfsDriver) repairConnections(name string, containers []string, interval time.Duration) {
+	v := d.lockVolume(name)
+	if v == nil {
+		return
+	}
+	defer v.ops.Unlock()
+	d.Lock()
+	defer d.Unlock()
+
+	// the volume may have been mounted again while the containers were listed.
+	if len(containers) >= v.connections || time.Since(v.lastMounted) <= interval {
+		return
+	}
+	log := logrus.WithFields(logrus.Fields{
+		"volume":      name,
+		"connections": v.connections,
+		"containers":  len(containers),
+	})
+	if len(containers) == 0 {
+		if err := d.releaseVolume(v); err != nil {
+			log.Errorf("Unmounting the volume no container uses failed. <ERROR> %v", err)
+			return
+		}
+	}
+	log.Warn("Leaked connections of the volume repaired.")
+	v.connections = len(containers)
+	v.containers = containers
+	if v.connections == 0 {
+		v.mountIDs = nil
+	}
+	driverMetrics.inc(metricConnectionsRepaired, labels{"volume": name})
+	d.countVolumes()
+}
@@ -0,0 +1,122 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// request - A request of docker being served. Every request gets a random ID, logged with every
+// log line of the request and appended to the error returned to docker, so that a failed
+// `docker run` can be correlated with the log of the plugin on busy hosts.
+type request struct {
+	id string
+	// method of the volume plugin protocol (ex: Mount).
+	method string
+	// span tracing the request, nil if tracing is disabled.
+	span *span
+	// logger of the request, logs the method, volume and ID of the request.
+	log *logrus.Entry
+	// context the commands run for the request are bound to, see `requestContext`.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// requests traced with `--otlp-endpoint`, the other requests are frequent and cheap.
+var tracedMethods = map[string]bool{"Create": true, "Mount": true, "Unmount": true, "Remove": true}
+
+// returns a new request of docker for the method on the volume.
+func newRequest(method, name string) *request {
+	req := &request{id: randomHex(8), method: method}
+	if tracedMethods[method] {
+		req.span = startSpan(method)
+	}
+	req.span.set("volume", name)
+	req.span.set("request.id", req.id)
+	req.log = logrus.WithFields(logrus.Fields{
+		"method":  method,
+		"volume":  name,
+		"request": req.id,
+	})
+	return req
+}
+
+// appends the ID of the request to the error of the response, and counts the request in the metrics.
+func (req *request) response(res volume.Response) volume.Response {
+	driverMetrics.inc(metricRequests, labels{"method": req.method})
+	if res.Err != "" {
+		driverMetrics.inc(metricRequestErrors, labels{"method": req.method})
+		res.Err = fmt.Sprintf("%s (request %s)", res.Err, req.id)
+	}
+	return res
+}
+
+// key of the request in its context, see `requestLog`.
+type requestKey struct{}
+
+// attaches the request to the driver, the log lines and child spans of the functions called
+// during the request (ex: the bucket checks and the start of minfs) are attached to the request
+// holding the driver lock. The request is detached while the lock is released, see `unlocked`.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) beginRequest(req *request) {
+	ctx, cancel := d.requestContext()
+	req.ctx, req.cancel = context.WithValue(ctx, requestKey{}, req), cancel
+	d.req = req
+	d.requests++
+}
+
+// ends the request with its response, returns the response to send to docker.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) endRequest(req *request, res volume.Response) volume.Response {
+	d.req = nil
+	d.requests--
+	req.cancel()
+	req.span.finishWithError(res.Err)
+	return req.response(res)
+}
+
+// returns the span of the request holding the lock, nil if there's none.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) span() *span {
+	return requestSpan(d.context())
+}
+
+// returns the logger of the request holding the lock, the plain logger if there's none.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) log() *logrus.Entry {
+	return requestLog(d.context())
+}
+
+// returns the span of the request the context belongs to, nil if there's none.
+func requestSpan(ctx context.Context) *span {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		return req.span
+	}
+	return nil
+}
+
+// returns the logger of the request the context belongs to, the plain logger if there's none.
+func requestLog(ctx context.Context) *logrus.Entry {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		return req.log
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
@@ -0,0 +1,204 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Endpoint resolution - In air-gapped or split-DNS environments the endpoints of the volumes may not
+// resolve with the resolver of the host. With `--resolver=<ip>[:<port>]` the plugin resolves them with
+// the given DNS server instead, and with `-o host-override=<name>=<ip>[,<name>=<ip>...]` a volume maps
+// names to addresses itself, like entries of /etc/hosts which are never written.
+// The overrides take precedence over the resolver. The requests of the plugin to the endpoint are
+// dialed to the resolved addresses, and the helper containers of `--minfs-image` are given the resolved
+// addresses of the endpoint as host entries. minfs running on the host resolves the endpoint with the
+// resolver of the host, so the overrides require `--minfs-image`.
+
+// time allowed to resolve a name and to connect to the endpoint.
+const (
+	resolveTimeout = 10 * time.Second
+	dialTimeout    = 30 * time.Second
+)
+
+// DNS server the endpoints are resolved with, the resolver of the host if nil. Set with `--resolver`.
+var endpointResolver *net.Resolver
+
+// transports of the requests to the endpoints, by host overrides and proxy.
+var (
+	endpointTransportsLock sync.Mutex
+	endpointTransports     = make(map[string]*http.Transport)
+)
+
+// sets the DNS server the endpoints are resolved with, of the form `<ip>[:<port>]`, the port defaults to 53.
+func setEndpointResolver(address string) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(strings.Trim(address, "[]"), "53")
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid resolver %q, must be <ip>[:<port>] (ex: 10.0.0.2:53)", address)
+	}
+	endpointResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+	return nil
+}
+
+// parses the host-override option of the form `<name>=<ip>[,<name>=<ip>...]`.
+func parseHostOverrides(option string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, entry := range strings.Split(option, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || net.ParseIP(parts[1]) == nil {
+			return nil, fmt.Errorf("invalid value %q for host-override option, must be <name>=<ip>[,<name>=<ip>...] (ex: minio.internal=10.1.2.3).", option)
+		}
+		overrides[strings.ToLower(parts[0])] = parts[1]
+	}
+	return overrides, nil
+}
+
+// validates the host overrides of a volume.
+func validateHostOverrides(overrides map[string]string) error {
+	for name, ip := range overrides {
+		if name == "" || net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid host override %s=%s, must be <name>=<ip>", name, ip)
+		}
+	}
+	return nil
+}
+
+// formats the host overrides as the host-override option, sorted by name.
+func formatHostOverrides(overrides map[string]string) string {
+	entries := make([]string, 0, len(overrides))
+	for name, ip := range overrides {
+		entries = append(entries, name+"="+ip)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// returns the addresses of the host, from the overrides, the resolver of `--resolver`, or the resolver
+// of the host in that order.
+func resolveHost(ctx context.Context, host string, overrides map[string]string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	if ip, ok := overrides[strings.ToLower(host)]; ok {
+		return []string{ip}, nil
+	}
+	resolver := endpointResolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	return resolver.LookupHost(ctx, host)
+}
+
+// returns the transport of the requests to the endpoint of the volume, dialing the addresses resolved
+// by `resolveHost` through the proxy of the volume, see `proxyFunc`. The default transport is used when
+// neither the overrides, the resolver nor a proxy are set.
+func endpointTransport(config serverConfig) http.RoundTripper {
+	// the requests of the volumes with `-o s3-trace=true` are written to their trace file.
+	if config.traceFile != "" {
+		c := config
+		c.traceFile = ""
+		return &tracingTransport{next: endpointTransport(c), path: config.traceFile, source: "plugin"}
+	}
+	if endpointResolver == nil && len(config.hostOverrides) == 0 && config.proxy == "" {
+		return http.DefaultTransport
+	}
+	// the transports are shared by the volumes with the same overrides and proxy, keeping the connections alive.
+	key := strings.Join([]string{formatHostOverrides(config.hostOverrides), config.proxy, config.noProxy}, "|")
+	endpointTransportsLock.Lock()
+	defer endpointTransportsLock.Unlock()
+	if t, ok := endpointTransports[key]; ok {
+		return t
+	}
+	overrides := config.hostOverrides
+	t := &http.Transport{
+		Proxy: proxyFunc(config),
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			addrs, err := resolveHost(ctx, host, overrides)
+			if err != nil {
+				return nil, err
+			}
+			dialer := net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+			for _, addr := range addrs {
+				var conn net.Conn
+				if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr, port)); err == nil {
+					return conn, nil
+				}
+			}
+			if err == nil {
+				err = fmt.Errorf("no address found for %s", host)
+			}
+			return nil, err
+		},
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	endpointTransports[key] = t
+	return t
+}
+
+// returns the host entries (`<name>:<ip>`) of the endpoint of the volume given to the helper container
+// running minfs, none if the endpoint is resolved with the resolver of the host.
+func endpointHostEntries(config serverConfig) []string {
+	if endpointResolver == nil && len(config.hostOverrides) == 0 {
+		return nil
+	}
+	u, err := url.Parse(config.endpoint)
+	if err != nil || net.ParseIP(u.Hostname()) != nil {
+		return nil
+	}
+	names := []string{u.Hostname()}
+	if config.addressing == addressingVirtualHost {
+		names = append(names, config.bucket+"."+u.Hostname())
+	}
+	var entries []string
+	for _, name := range names {
+		addrs, err := resolveHost(context.Background(), name, config.hostOverrides)
+		if err != nil || len(addrs) == 0 {
+			logrus.WithField("host", name).Warnf("Unable to resolve the endpoint, minfs resolves it with the resolver of the host. <ERROR> %v", err)
+			continue
+		}
+		entries = append(entries, name+":"+addrs[0])
+	}
+	return entries
+}
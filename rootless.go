@@ -0,0 +1,98 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/docker/go-plugins-helpers/sdk"
+)
+
+// Rootless Docker - With `--rootless` (the default when the plugin doesn't run as root), the plugin serves
+// a rootless Docker daemon of the same user: its sockets are created under `$XDG_RUNTIME_DIR/docker/plugins/`
+// where the daemon discovers them, the Docker API is reached at `$XDG_RUNTIME_DIR/docker.sock`, and the
+// mounts of minfs are unmounted with the setuid `fusermount` helper rather than with `umount`, which
+// requires root. minfs is passed `allow_other` so that the containers, running as the subordinate ids of
+// the user, can use the mounts, which requires `user_allow_other` in /etc/fuse.conf.
+// The managed caches (`-o encrypt-cache`, `-o cache=tmpfs`) require root and are not available.
+
+// set with `--rootless`, the plugin serves a rootless Docker daemon.
+var rootlessMode bool
+
+// returns the runtime directory of the user, `$XDG_RUNTIME_DIR` or `/run/user/<uid>`.
+func rootlessRuntimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	return fmt.Sprintf("/run/user/%d", os.Getuid())
+}
+
+// returns the path of the socket of the named driver in rootless mode.
+func rootlessSocketPath(name string) string {
+	return filepath.Join(rootlessRuntimeDir(), "docker", "plugins", name+".sock")
+}
+
+// returns the path of the API socket of the rootless Docker daemon.
+func rootlessDockerSocket() string {
+	return filepath.Join(rootlessRuntimeDir(), "docker.sock")
+}
+
+// serves the handler on the unix socket at the path until it fails. The socket is only accessible to the
+// user, the sockets of `ServeUnix` are given to root which a rootless plugin can't do.
+func serveRootlessUnix(h sdk.Handler, path string) error {
+	l, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	return h.Serve(l)
+}
+
+// listens on a unix socket at the path, only accessible to the user of the plugin.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := syscall.Unlink(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// returns the command and arguments unmounting the target, `fusermount -u` in rootless mode.
+// The lazy (`-l`) and forced (`-f`) unmounts are both a lazy `fusermount -u -z`.
+func unmountCommand(target string, flags ...string) (string, []string) {
+	if !rootlessMode {
+		return "umount", append(flags, target)
+	}
+	args := []string{"-u"}
+	if len(flags) > 0 {
+		args = append(args, "-z")
+	}
+	return "fusermount", append(args, target)
+}
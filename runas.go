@@ -0,0 +1,92 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// Running minfs as a non root user - With `--run-as-user`, minfs is started as the given user rather
+// than as root, and mounts the bucket through the setuid `fusermount` helper. The mountpoints are
+// given to the user before minfs starts, and minfs is passed `allow_other` so that the containers
+// can use the mounts, which requires `user_allow_other` in /etc/fuse.conf.
+
+// runAsUser - User the minfs processes run as.
+type runAsUser struct {
+	name string
+	uid  uint32
+	gid  uint32
+}
+
+// looks up the user given by name or as `<uid>[:<gid>]`, the group defaults to the primary group of the user.
+func parseRunAsUser(name string) (*runAsUser, error) {
+	if uid, gid, err := parseOwner(name); err == nil {
+		if gid < 0 {
+			gid = uid
+			if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+				gid, _ = strconv.Atoi(u.Gid)
+			}
+		}
+		return &runAsUser{name: name, uid: uint32(uid), gid: uint32(gid)}, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %s has a non numeric uid %s", name, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %s has a non numeric gid %s", name, u.Gid)
+	}
+	return &runAsUser{name: name, uid: uint32(uid), gid: uint32(gid)}, nil
+}
+
+// returns the credential the minfs process is started with, nil to run it as root.
+func (u *runAsUser) credential() *syscall.Credential {
+	if u == nil {
+		return nil
+	}
+	return &syscall.Credential{Uid: u.uid, Gid: u.gid}
+}
+
+// returns the user of the helper containers running minfs, empty to run it as root.
+func (u *runAsUser) containerUser() string {
+	if u == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", u.uid, u.gid)
+}
+
+// gives the directories minfs uses (the mountpoint and its cache) to the user.
+func (u *runAsUser) chown(paths ...string) error {
+	if u == nil {
+		return nil
+	}
+	for _, path := range paths {
+		if err := os.Chown(path, int(u.uid), int(u.gid)); err != nil {
+			return fmt.Errorf("giving %s to user %s failed: %v", path, u.name, err)
+		}
+	}
+	return nil
+}
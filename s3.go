@@ -0,0 +1,178 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/minio/minio-go/pkg/s3signer"
+)
+
+// Bucket addressing styles, set with `-o addressing=<style>`.
+const (
+	// https://play.minio.io:9000/bucket/object
+	addressingPath = "path"
+	// https://bucket.play.minio.io:9000/object
+	addressingVirtualHost = "virtual-host"
+)
+
+// S3 signature versions, set with `-o signature=<version>` or `--signature`.
+const (
+	signatureV2 = "v2"
+	signatureV4 = "v4"
+)
+
+// validates the signature version, empty lets minio-go choose the version for the endpoint.
+func isValidSignature(signature string) bool {
+	return signature == "" || signature == signatureV2 || signature == signatureV4
+}
+
+// returns true if the requests of the volume are sent with s3Request rather than with minio-go.
+// The vendored minio-go only uses virtual host style requests for Amazon S3 and Google Cloud Storage,
+// and looks up the location of the bucket before signing the requests (GET ?location, which generic
+// S3 compatible servers may redirect or refuse), the requests of the volumes using virtual host
+// addressing or an explicit region are signed for the region of the volume instead.
+func useS3Request(config serverConfig) bool {
+	return config.addressing == addressingVirtualHost || (config.region != "" && config.region != defaultLocation)
+}
+
+// s3Error - error response of the S3 API.
+type s3Error struct {
+	Code       string
+	Message    string
+	statusCode int
+}
+
+func (e s3Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// s3Do - Sends a signed request to the S3 API of the remote server of the volume
+// and returns the body of the response.
+// Used for the bucket level APIs which aren't supported by the vendored minio-go.
+// `query` holds the sub resource (ex: "object-lock") and `region` the region the request is signed for.
+func s3Do(config serverConfig, method string, query url.Values, region string, headers map[string]string, body []byte) ([]byte, error) {
+	return s3DoContext(context.Background(), config, method, query, region, headers, body)
+}
+
+// s3DoContext - s3Do cancelled with `ctx`.
+func s3DoContext(ctx context.Context, config serverConfig, method string, query url.Values, region string, headers map[string]string, body []byte) ([]byte, error) {
+	resp, err := s3RequestContext(ctx, config, method, "", query, region, headers, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// s3Request - Sends a signed request for `object` of the bucket of the volume,
+// the bucket itself if `object` is empty, and returns the response.
+// Non 2xx responses are returned as s3Error.
+func s3Request(config serverConfig, method, object string, query url.Values, region string, headers map[string]string, body []byte) (*http.Response, error) {
+	return s3RequestContext(context.Background(), config, method, object, query, region, headers, body)
+}
+
+// s3RequestContext - s3Request cancelled with `ctx`.
+func s3RequestContext(ctx context.Context, config serverConfig, method, object string, query url.Values, region string, headers map[string]string, body []byte) (*http.Response, error) {
+	u, err := objectURL(config, object)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+	// sub resources without a value are sent as `?versioning` rather than `?versioning=`.
+	if len(query) == 1 {
+		for k, v := range query {
+			if len(v) == 1 && v[0] == "" {
+				u.RawQuery = url.QueryEscape(k)
+			}
+		}
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = int64(len(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	sha := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sha[:]))
+	if len(body) > 0 {
+		sum := md5.Sum(body)
+		req.Header.Set("Content-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	if region == "" {
+		region = defaultLocation
+	}
+	if config.signature == signatureV2 {
+		req = s3signer.SignV2(*req, config.accessKey, config.secretKey)
+	} else {
+		req = s3signer.SignV4(*req, config.accessKey, config.secretKey, region)
+	}
+
+	client := &http.Client{Transport: endpointTransport(config)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		e := s3Error{Code: resp.Status, statusCode: resp.StatusCode}
+		xml.Unmarshal(data, &e)
+		return nil, e
+	}
+	return resp, nil
+}
+
+// createBucketConfiguration - body of the PUT bucket request holding the region of the bucket.
+type createBucketConfiguration struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CreateBucketConfiguration"`
+	Location string   `xml:"LocationConstraint"`
+}
+
+// creates the bucket of the volume, with object locking enabled (WORM) if it's set for the volume.
+func s3MakeBucket(config serverConfig) error {
+	var body []byte
+	if config.region != "" && config.region != defaultLocation {
+		var err error
+		body, err = xml.Marshal(createBucketConfiguration{Location: config.region})
+		if err != nil {
+			return err
+		}
+	}
+	headers := map[string]string{}
+	if config.objectLocking {
+		headers["X-Amz-Bucket-Object-Lock-Enabled"] = "true"
+	}
+	// signed for the region of the bucket, regional endpoints of S3 compatible servers refuse other regions.
+	_, err := s3Do(config, "PUT", nil, config.region, headers, body)
+	return err
+}
@@ -0,0 +1,208 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// S3 traces - With `-o s3-trace=true`, every request sent to the endpoint on behalf of the volume is
+// written as a JSON line to `<mountroot>/.trace/<volume>.log`: its method, URL, status, duration and
+// error. The requests of the plugin (bucket validation, quotas, snapshots...) are traced by the transport
+// of the volume. The requests of minfs are traced when the endpoint is a plain HTTP one and minfs runs on
+// the host: minfs is then given a proxy of the plugin (HTTP_PROXY) which traces the requests and forwards
+// them. The requests of minfs to HTTPS endpoints are encrypted end to end and can't be traced.
+// The trace file is removed with the volume.
+
+// directory under the mount root holding the trace files of the volumes.
+const traceDir = ".trace"
+
+// query parameters of presigned URLs redacted from the traces.
+var redactedQueryParams = []string{"X-Amz-Signature", "X-Amz-Credential", "AWSAccessKeyId", "Signature"}
+
+// serializes the writes to the trace files.
+var traceLock sync.Mutex
+
+// returns the trace file of the volume.
+func (d *minfsDriver) tracePath(name string, config serverConfig) string {
+	return filepath.Join(d.volumeMountRoot(config), traceDir, name+".log")
+}
+
+// s3TraceEntry - request written to the trace file.
+type s3TraceEntry struct {
+	Time time.Time `json:"time"`
+	// `plugin` or `minfs`.
+	Source        string  `json:"source"`
+	Method        string  `json:"method"`
+	URL           string  `json:"url"`
+	Status        int     `json:"status,omitempty"`
+	Duration      float64 `json:"durationSeconds"`
+	RequestBytes  int64   `json:"requestBytes,omitempty"`
+	ResponseBytes int64   `json:"responseBytes,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// appends the entry to the trace file.
+func writeTrace(path string, entry s3TraceEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		logrus.WithField("path", path).Errorf("Creating the trace directory failed. <ERROR> %v", err)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		logrus.WithField("path", path).Errorf("Opening the trace file failed. <ERROR> %v", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// returns the URL of the request without the signature of a presigned URL.
+func redactedURL(req *http.Request) string {
+	u := *req.URL
+	query := u.Query()
+	redacted := false
+	for _, param := range redactedQueryParams {
+		if query.Get(param) != "" {
+			query.Set(param, "xxxxx")
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// tracingTransport - Writes the requests sent through the transport to the trace file of the volume.
+type tracingTransport struct {
+	next   http.RoundTripper
+	path   string
+	source string
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := s3TraceEntry{
+		Time:         time.Now().UTC(),
+		Source:       t.source,
+		Method:       req.Method,
+		URL:          redactedURL(req),
+		RequestBytes: req.ContentLength,
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	entry.Duration = time.Since(start).Seconds()
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Status = resp.StatusCode
+		entry.ResponseBytes = resp.ContentLength
+	}
+	writeTrace(t.path, entry)
+	return resp, err
+}
+
+// headers of the connection to the proxy, not forwarded to the endpoint.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// traceProxy - HTTP proxy tracing the requests of minfs to a plain HTTP endpoint, see `startTraceProxy`.
+type traceProxy struct {
+	listener  net.Listener
+	transport http.RoundTripper
+}
+
+// starts the proxy tracing the requests of the minfs process of the volume, nil if the requests of
+// minfs can't be traced. The proxy forwards the requests with the transport of the volume, through
+// its proxy if it has one.
+func (d *minfsDriver) startTraceProxy(v *mountInfo) *traceProxy {
+	if v.config.traceFile == "" || !strings.HasPrefix(v.config.endpoint, "http://") {
+		return nil
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		d.log().WithField("volume", v.name).Errorf("Starting the trace proxy failed, the requests of minfs are not traced. <ERROR> %v", err)
+		return nil
+	}
+	c := v.config
+	c.traceFile = ""
+	p := &traceProxy{
+		listener:  l,
+		transport: &tracingTransport{next: endpointTransport(c), path: v.config.traceFile, source: "minfs"},
+	}
+	go http.Serve(l, p)
+	return p
+}
+
+// returns the environment of minfs sending its requests through the proxy.
+func (p *traceProxy) env() []string {
+	url := "http://" + p.listener.Addr().String()
+	return []string{"HTTP_PROXY=" + url, "http_proxy=" + url, "NO_PROXY=", "no_proxy="}
+}
+
+// stops the proxy.
+func (p *traceProxy) close() {
+	p.listener.Close()
+}
+
+// forwards the request of minfs to the endpoint.
+func (p *traceProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect || !r.URL.IsAbs() {
+		http.Error(w, "only plain HTTP requests are proxied", http.StatusMethodNotAllowed)
+		return
+	}
+	out := r.WithContext(r.Context())
+	out.RequestURI = ""
+	out.Header = make(http.Header, len(r.Header))
+	for k, vv := range r.Header {
+		out.Header[k] = vv
+	}
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for k, vv := range resp.Header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
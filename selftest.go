@@ -0,0 +1,100 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// Self-test - With `--self-test=<option>=<value>,...` (the options of a volume, ex: the endpoint and the
+// credential files), the plugin creates, mounts, writes, reads, unmounts and removes a scratch volume
+// at startup, and exits if any step fails. This catches a missing FUSE module, bad credentials or an
+// incompatible minfs before docker routes volumes to the plugin.
+// Without a bucket, a temporary bucket is created and deleted with the volume. With a bucket, only the
+// file written by the test is deleted from it.
+
+// runs the self-test with the options of the scratch volume, returns the step which failed.
+// Must not be called with the driver lock held.
+func (d *minfsDriver) selfTest(options map[string]string) (err error) {
+	name := "minfs-self-test-" + randomHex(4)
+	opts := make(map[string]string, len(options)+2)
+	for k, v := range options {
+		opts[k] = v
+	}
+	if opts["bucket"] == "" {
+		opts["bucket"] = name
+		opts["purge-on-remove"] = "true"
+		opts["force-purge"] = "true"
+	}
+	log := logrus.WithFields(logrus.Fields{
+		"volume": name,
+		"bucket": opts["bucket"],
+	})
+	log.Info("Running the self-test.")
+
+	if res := d.Create(volume.Request{Name: name, Options: opts}); res.Err != "" {
+		return fmt.Errorf("create: %s", res.Err)
+	}
+	defer func() {
+		if res := d.Remove(volume.Request{Name: name}); res.Err != "" && err == nil {
+			err = fmt.Errorf("remove: %s", res.Err)
+		}
+	}()
+	res := d.Mount(volume.MountRequest{Name: name, ID: name})
+	if res.Err != "" {
+		return fmt.Errorf("mount: %s", res.Err)
+	}
+	defer func() {
+		if res := d.Unmount(volume.UnmountRequest{Name: name, ID: name}); res.Err != "" && err == nil {
+			err = fmt.Errorf("unmount: %s", res.Err)
+		}
+	}()
+
+	file := filepath.Join(res.Mountpoint, "."+name)
+	data := []byte(randomHex(32))
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+	defer os.Remove(file)
+	read, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read: %v", err)
+	}
+	if !bytes.Equal(read, data) {
+		return fmt.Errorf("read: the file read back differs from the file written")
+	}
+	return nil
+}
+
+// runs the self-test and exits if it fails.
+func (d *minfsDriver) runSelfTest(spec string) {
+	options, err := parseOptionList(spec)
+	if err != nil {
+		logrus.Fatalf("Invalid --self-test. <ERROR> %v", err)
+	}
+	if err := d.selfTest(options); err != nil {
+		logrus.Fatalf("Self-test FAILED. <ERROR> %v", err)
+	}
+	logrus.Info("Self-test PASSED.")
+}
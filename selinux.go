@@ -0,0 +1,71 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// SELinux labels - On SELinux enforcing hosts containers can only access files labeled for containers.
+// The FUSE mount of the volume is labeled with `-o selinux-label=<context>` (passed to minfs as the
+// `context` mount option, FUSE files can't be relabeled after the mount). `-o selinux-label=auto` uses
+// the label shared by all the containers, like the `:z` suffix of bind mounts.
+// The mountpoints which are not served by minfs (snapshots and missing buckets) are relabeled with chcon.
+
+const (
+	// value of the selinux-label option selecting the label shared by all the containers.
+	selinuxAuto = "auto"
+	// SELinux context of the files shared by all the containers.
+	selinuxSharedLabel = "system_u:object_r:container_file_t:s0"
+)
+
+// valid SELinux contexts, user:role:type:level where the level may list categories (ex: s0:c1,c2).
+var selinuxLabelRegexp = regexp.MustCompile(`^[a-zA-Z0-9_]+:[a-zA-Z0-9_]+:[a-zA-Z0-9_]+:[a-zA-Z0-9_.,:-]+$`)
+
+// validates the selinux-label option.
+func isValidSELinuxLabel(label string) bool {
+	return label == "" || label == selinuxAuto || selinuxLabelRegexp.MatchString(label)
+}
+
+// returns the SELinux context of the volume, empty if the volume isn't labeled.
+func selinuxLabel(config serverConfig) string {
+	if config.selinuxLabel == selinuxAuto {
+		return selinuxSharedLabel
+	}
+	return config.selinuxLabel
+}
+
+// relabels the files under the mountpoint of a volume which is not served by minfs.
+func relabelMountpoint(ctx context.Context, v *mountInfo) error {
+	return relabelPath(ctx, v.config, v.mountPoint)
+}
+
+// relabels the directory and its content with the SELinux label of the volume.
+func relabelPath(ctx context.Context, config serverConfig, dir string) error {
+	label := selinuxLabel(config)
+	if label == "" {
+		return nil
+	}
+	if out, err := exec.CommandContext(ctx, "chcon", "-R", label, dir).CombinedOutput(); err != nil {
+		return fmt.Errorf("relabeling %s failed: %v %s", dir, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
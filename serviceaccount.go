@@ -0,0 +1,168 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Service accounts - With the MinIO admin credentials set on the plugin (`--minio-admin-access-key-file` and
+// `--minio-admin-secret-key-file`), a volume created without credentials gets a service account of its own:
+// Create mints a service account of the admin user with a policy allowing only the bucket(s) of the volume,
+// and the volume is mounted with its credentials. The service account is deleted with the volume. The
+// accounts are managed with `mc admin user svcacct`, `--mc-binary` sets the path of the mc executable.
+
+// alias of the endpoint in the mc commands, set with MC_HOST_<alias>.
+const mcAlias = "minfs"
+
+// serviceAccountPolicy - IAM policy of the service account of a volume.
+type serviceAccountPolicy struct {
+	Version   string                    `json:"Version"`
+	Statement []serviceAccountStatement `json:"Statement"`
+}
+
+// serviceAccountStatement - statement of the policy of the service account.
+type serviceAccountStatement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// actions allowed on the buckets the volume only reads: the read only branches of a union and the source
+// of a clone.
+var readOnlyActions = []string{"s3:GetBucketLocation", "s3:ListBucket", "s3:ListBucketVersions", "s3:GetObject", "s3:GetObjectVersion"}
+
+// returns the resources of the buckets and their objects.
+func bucketResources(buckets []string) []string {
+	var resources []string
+	for _, bucket := range buckets {
+		resources = append(resources, "arn:aws:s3:::"+bucket, "arn:aws:s3:::"+bucket+"/*")
+	}
+	return resources
+}
+
+// returns the policy of the service account of the volume, allowing its buckets and their objects only,
+// and reading the source bucket of a clone.
+func volumeAccountPolicy(config serverConfig, clone *cloneSource) serviceAccountPolicy {
+	var buckets, readOnly []string
+	if config.bucket != "" {
+		buckets = append(buckets, config.bucket)
+	}
+	for _, b := range config.union {
+		if b.readOnly {
+			readOnly = append(readOnly, b.bucket)
+		} else {
+			buckets = append(buckets, b.bucket)
+		}
+	}
+	if clone != nil {
+		readOnly = append(readOnly, clone.config.bucket)
+	}
+	p := serviceAccountPolicy{Version: bucketPolicyVersion}
+	if len(buckets) > 0 {
+		p.Statement = append(p.Statement, serviceAccountStatement{Effect: "Allow", Action: []string{"s3:*"}, Resource: bucketResources(buckets)})
+	}
+	if len(readOnly) > 0 {
+		p.Statement = append(p.Statement, serviceAccountStatement{Effect: "Allow", Action: readOnlyActions, Resource: bucketResources(readOnly)})
+	}
+	return p
+}
+
+// runs mc against the endpoint of the volume with the admin credentials, returns the JSON output of mc.
+func (d *minfsDriver) runMc(ctx context.Context, config serverConfig, args ...string) ([]byte, error) {
+	u, err := url.Parse(config.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.User = url.UserPassword(d.minioAdmin.accessKey, d.minioAdmin.secretKey)
+	u.Path = ""
+	// mc writes its config, it's kept away from the config of the host.
+	configDir, err := ioutil.TempDir("", "minfs-mc")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(configDir)
+
+	defer observeExec("mc", time.Now())
+	cmd := exec.CommandContext(ctx, d.mcBinary, append([]string{"--config-dir", configDir, "--json"}, args...)...)
+	cmd.Env = append(os.Environ(), "MC_HOST_"+mcAlias+"="+u.String())
+	cmd.Env = append(cmd.Env, proxyEnv(config)...)
+	out, err := cmd.Output()
+	if err != nil {
+		// mc reports its errors as JSON on stdout.
+		var result struct {
+			Error struct {
+				Message string `json:"message"`
+				Cause   struct {
+					Message string `json:"message"`
+				} `json:"cause"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(out, &result) == nil && result.Error.Message != "" {
+			return nil, fmt.Errorf("mc %s failed: %s %s", strings.Join(args[:4], " "), result.Error.Message, result.Error.Cause.Message)
+		}
+		return nil, fmt.Errorf("mc %s failed: %v %s", strings.Join(args[:4], " "), err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// mints the service account of the volume, returns its credentials.
+func (d *minfsDriver) addServiceAccount(ctx context.Context, config serverConfig, clone *cloneSource) (string, string, error) {
+	policy, err := json.Marshal(volumeAccountPolicy(config, clone))
+	if err != nil {
+		return "", "", err
+	}
+	f, err := ioutil.TempFile("", "minfs-policy")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(policy)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", "", err
+	}
+	out, err := d.runMc(ctx, config, "admin", "user", "svcacct", "add", "--policy", f.Name(), mcAlias, d.minioAdmin.accessKey)
+	if err != nil {
+		return "", "", err
+	}
+	var account struct {
+		AccessKey string `json:"accessKey"`
+		SecretKey string `json:"secretKey"`
+	}
+	if err := json.Unmarshal(out, &account); err != nil || account.AccessKey == "" || account.SecretKey == "" {
+		// the output holds the secret key, it's not reported.
+		return "", "", fmt.Errorf("unexpected output of mc admin user svcacct add")
+	}
+	return account.AccessKey, account.SecretKey, nil
+}
+
+// deletes the service account of the volume.
+func (d *minfsDriver) removeServiceAccount(ctx context.Context, config serverConfig) error {
+	_, err := d.runMc(ctx, config, "admin", "user", "svcacct", "rm", mcAlias, config.accessKey)
+	return err
+}
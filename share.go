@@ -0,0 +1,210 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// directory under the mount root holding the shared minfs mounts.
+const sharedMountsDir = ".shared"
+
+// Shared mounts - With `--share-mounts`, the volumes mounting the same bucket of the same server with the
+// same credentials and minfs options are served by a single minfs process.
+// minfs mounts the bucket once under `<mountroot>/.shared/` and the mountpoint of every volume is a
+// bind mount of it. The shared mount is tracked as an internal mountInfo, supervised like the mount
+// of a volume, whose connections are the number of volumes bound to it.
+
+// returns the key identifying the volumes which can share a minfs mount, derived from the arguments and
+// the environment minfs is started with for the volume, and from the settings applied around minfs.
+func (d *minfsDriver) sharedMountKey(v *mountInfo) string {
+	// the mountpoint and the cache directory are those of the shared mount.
+	probe := &mountInfo{config: v.config, readOnly: v.readOnly}
+	h := sha256.New()
+	fmt.Fprintf(h, "%q\n%q\n", d.minfsArgs(probe), minfsEnv(probe))
+	fmt.Fprintf(h, "%s\n%s\n%s\n", strings.Join(v.config.endpoints, ","), formatHostOverrides(v.config.hostOverrides), v.config.traceFile)
+	fmt.Fprintf(h, "%v\n%v\n%s\n%s\n", v.config.retainCache, v.config.encryptCache, v.config.cacheType, v.config.cacheSize)
+	// the owner and mode are set on the root of the shared mount.
+	fmt.Fprintf(h, "%s\n%s\n", v.config.owner, v.config.mode)
+	fmt.Fprintf(h, "%s\n%s\n", v.config.memoryLimit, v.config.cpuQuota)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// mounts the volume as a bind mount of the shared minfs mount of its bucket,
+// minfs is started if the bucket isn't mounted yet.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) mountShared(v *mountInfo) error {
+	key := d.sharedMountKey(v)
+	s, created := d.lockShared(key, v)
+	defer s.ops.Unlock()
+	if created {
+		err := createDir(s.mountPoint)
+		if err == nil {
+			err = d.startMinfs(s)
+		}
+		if err != nil {
+			delete(d.shared, key)
+			return err
+		}
+		d.log().WithFields(logrus.Fields{
+			"mountpoint": s.mountPoint,
+			"bucket":     s.config.bucket,
+		}).Info("Shared minfs mount started.")
+	}
+	if err := d.unlocked(func(ctx context.Context) error { return bindMount(ctx, s.mountPoint, v.mountPoint) }); err != nil {
+		if s.connections == 0 {
+			d.stopShared(key, s)
+		}
+		return err
+	}
+	s.connections++
+	v.shared = s
+	return nil
+}
+
+// returns the shared mount of the key with its lock held, a new shared mount whose minfs has to be started
+// is registered if there's none. The driver lock is released while waiting for the lock of the shared mount.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) lockShared(key string, v *mountInfo) (*mountInfo, bool) {
+	for {
+		s, ok := d.shared[key]
+		if !ok {
+			s = &mountInfo{
+				ops:        new(sync.Mutex),
+				name:       "shared-" + key[:12],
+				config:     v.config,
+				readOnly:   v.readOnly,
+				mountPoint: filepath.Join(d.mountRoot, sharedMountsDir, key[:16]),
+				output:     newOutputTail("shared-"+key[:12], d.outputLines),
+			}
+			s.cacheDir = d.cachePath(s)
+			s.ops.Lock()
+			d.shared[key] = s
+			return s, true
+		}
+		d.unlocked(func(context.Context) error {
+			s.ops.Lock()
+			return nil
+		})
+		// the shared mount may have been stopped in the meantime.
+		if d.shared[key] == s {
+			return s, false
+		}
+		s.ops.Unlock()
+	}
+}
+
+// removes the bind mount of the volume, the shared minfs mount is stopped once no volume is bound to it.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) unmountShared(v *mountInfo) error {
+	s := v.shared
+	d.unlocked(func(context.Context) error {
+		s.ops.Lock()
+		return nil
+	})
+	defer s.ops.Unlock()
+	if err := d.unmountVolume(v); err != nil {
+		return err
+	}
+	v.shared = nil
+	s.connections--
+	if s.connections <= 0 {
+		// the key isn't computed again, the endpoint of the shared mount may have failed over.
+		for key, m := range d.shared {
+			if m == s {
+				d.stopShared(key, s)
+			}
+		}
+	}
+	return nil
+}
+
+// stops the shared minfs mount.
+// Has to be called with the driver lock held, and the lock of the shared mount.
+func (d *minfsDriver) stopShared(key string, s *mountInfo) {
+	if err := d.stopMinfs(s); err != nil {
+		d.log().WithField("mountpoint", s.mountPoint).Errorf("Unmounting the shared mount failed. <ERROR> %v", err)
+		return
+	}
+	delete(d.shared, key)
+	os.Remove(s.mountPoint)
+}
+
+// re-creates the bind mounts of the volumes bound to the shared mount after minfs was restarted,
+// the previous bind mounts still refer to the mount of the crashed process.
+// Has to be called with the driver lock held, and the lock of the shared mount.
+func (d *minfsDriver) rebindShared(s *mountInfo) {
+	// the volumes are bound to the shared mount, and unbound, with its lock held.
+	mountPoints := make(map[string]string)
+	for _, v := range d.mounts {
+		if v.shared == s {
+			mountPoints[v.name] = v.mountPoint
+		}
+	}
+	if len(mountPoints) == 0 {
+		return
+	}
+	d.unlocked(func(ctx context.Context) error {
+		for name, mountPoint := range mountPoints {
+			lazyUnmount(mountPoint)
+			if err := bindMount(ctx, s.mountPoint, mountPoint); err != nil {
+				logrus.WithField("volume", name).Errorf("Re-binding the shared mount failed. <ERROR> %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// returns true if the mount is the mount of a volume, a shared mount of the driver or the mount of a bucket
+// of a union volume.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) isTracked(v *mountInfo) bool {
+	if d.mounts[v.name] == v {
+		return true
+	}
+	for _, s := range d.shared {
+		if s == v {
+			return true
+		}
+	}
+	for _, u := range d.mounts {
+		for _, m := range u.union {
+			if m == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bind mounts `source` at `target`.
+func bindMount(ctx context.Context, source, target string) error {
+	if out, err := exec.CommandContext(ctx, "mount", "--bind", source, target).CombinedOutput(); err != nil {
+		return fmt.Errorf("bind mount of %s failed: %v %s", source, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import "testing"
+
+// The volumes whose minfs would be started differently don't share a mount.
+func TestSharedMountKey(t *testing.T) {
+	d := newTestDriver(t)
+	base := serverConfig{
+		endpoint:  "http://127.0.0.1:9000",
+		bucket:    "shared-bucket",
+		accessKey: "access",
+		secretKey: "secret",
+		region:    defaultLocation,
+	}
+	key := d.sharedMountKey(&mountInfo{name: "a", mountPoint: "/mnt/a", config: base})
+	if other := d.sharedMountKey(&mountInfo{name: "b", mountPoint: "/mnt/b", cacheDir: "/cache/b", config: base}); other != key {
+		t.Fatal("volumes of the same bucket and options don't share their mount.")
+	}
+
+	cases := map[string]func(v *mountInfo){
+		"secret key":     func(v *mountInfo) { v.config.secretKey = "other" },
+		"read only":      func(v *mountInfo) { v.readOnly = true },
+		"proxy":          func(v *mountInfo) { v.config.proxy = "http://proxy:3128" },
+		"host overrides": func(v *mountInfo) { v.config.hostOverrides = map[string]string{"minio": "10.0.0.1"} },
+		"trace file":     func(v *mountInfo) { v.config.traceFile = "/trace/b.log" },
+		"consistency":    func(v *mountInfo) { v.config.consistency = consistencyStrict },
+		"owner":          func(v *mountInfo) { v.config.owner = "1000:1000" },
+	}
+	for name, change := range cases {
+		v := &mountInfo{name: "b", mountPoint: "/mnt/b", config: base}
+		change(v)
+		if d.sharedMountKey(v) == key {
+			t.Errorf("%s: volumes share their mount.", name)
+		}
+	}
+}
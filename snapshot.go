@@ -0,0 +1,265 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Snapshots - A snapshot of a volume is a point in time of its bucket, the bucket has to be versioned.
+// Snapshots are taken on the admin endpoint (`POST /snapshots?volume=<volume>`), which enables versioning
+// on the bucket and records the time of the snapshot.
+// A volume created with `-o snapshot=<time>` serves the objects of the bucket as they were at that time,
+// read only. The object versions are fetched into `<mountroot>/.snapshots/<volume>` on the first mount,
+// which is bind mounted read only at the mountpoint of the volume.
+
+// directory under the mount root holding the objects restored for the snapshot volumes.
+const snapshotsDir = ".snapshots"
+
+// versioningConfiguration - body of the bucket versioning requests.
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ VersioningConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+// objectVersion - version or delete marker of an object in the ListObjectVersions response.
+type objectVersion struct {
+	Key          string
+	VersionID    string `xml:"VersionId"`
+	LastModified time.Time
+	deleted      bool
+}
+
+// listVersionsResult - response of the ListObjectVersions request.
+type listVersionsResult struct {
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIDMarker string          `xml:"NextVersionIdMarker"`
+	Versions            []objectVersion `xml:"Version"`
+	DeleteMarkers       []objectVersion `xml:"DeleteMarker"`
+}
+
+// parses the `snapshot` option of the create request, zero if the option isn't set.
+func parseSnapshotOption(options map[string]string) (time.Time, error) {
+	value := options["snapshot"]
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid value %q for snapshot option, must be a RFC 3339 time (ex: 2017-01-30T15:04:05Z).", value)
+	}
+	return t, nil
+}
+
+// returns true if versioning is enabled on the bucket of the volume.
+func bucketVersioningEnabled(config serverConfig) (bool, error) {
+	data, err := s3Do(config, "GET", url.Values{"versioning": {""}}, config.region, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	var v versioningConfiguration
+	if err := xml.Unmarshal(data, &v); err != nil {
+		return false, err
+	}
+	return v.Status == "Enabled", nil
+}
+
+// enables versioning on the bucket of the volume, required to take snapshots.
+func enableBucketVersioning(config serverConfig) error {
+	body, err := xml.Marshal(versioningConfiguration{Status: "Enabled"})
+	if err != nil {
+		return err
+	}
+	_, err = s3Do(config, "PUT", url.Values{"versioning": {""}}, config.region, nil, body)
+	return err
+}
+
+// takes a snapshot of the volume, returns the time of the snapshot.
+// Versioning is enabled on the bucket if it isn't, only the changes made after that can be rolled back.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) takeSnapshot(v *mountInfo) (time.Time, error) {
+	if v.config.anonymous {
+		return time.Time{}, fmt.Errorf("snapshots of anonymous volumes are not supported")
+	}
+	if !v.config.snapshot.IsZero() {
+		return time.Time{}, fmt.Errorf("volume %s is a snapshot", v.name)
+	}
+	err := d.unlocked(func(context.Context) error {
+		enabled, err := bucketVersioningEnabled(v.config)
+		if err != nil || enabled {
+			return err
+		}
+		if err := enableBucketVersioning(v.config); err != nil {
+			return err
+		}
+		logrus.WithFields(logrus.Fields{
+			"volume": v.name,
+			"bucket": v.config.bucket,
+		}).Info("Versioning enabled on the bucket.")
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	// the snapshot is taken at the precision of the `snapshot` option.
+	t := time.Now().UTC().Truncate(time.Second)
+	v.snapshots = append(v.snapshots, t)
+	return t, nil
+}
+
+// returns the versions of the objects of the bucket which were current at time `t`.
+func snapshotVersions(ctx context.Context, config serverConfig, t time.Time) ([]objectVersion, error) {
+	latest := make(map[string]objectVersion)
+	query := url.Values{"versions": {""}}
+	for {
+		data, err := s3DoContext(ctx, config, "GET", query, config.region, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var res listVersionsResult
+		if err := xml.Unmarshal(data, &res); err != nil {
+			return nil, err
+		}
+		for _, m := range res.DeleteMarkers {
+			m.deleted = true
+			res.Versions = append(res.Versions, m)
+		}
+		for _, o := range res.Versions {
+			if o.LastModified.After(t) {
+				continue
+			}
+			if cur, ok := latest[o.Key]; !ok || o.LastModified.After(cur.LastModified) {
+				latest[o.Key] = o
+			}
+		}
+		if !res.IsTruncated {
+			break
+		}
+		query = url.Values{
+			"versions":          {""},
+			"key-marker":        {res.NextKeyMarker},
+			"version-id-marker": {res.NextVersionIDMarker},
+		}
+	}
+
+	var versions []objectVersion
+	for _, o := range latest {
+		if !o.deleted {
+			versions = append(versions, o)
+		}
+	}
+	return versions, nil
+}
+
+// returns the directory the objects of the snapshot volume are restored into.
+func (d *minfsDriver) snapshotPath(v *mountInfo) string {
+	return filepath.Join(d.volumeMountRoot(v.config), snapshotsDir, v.name)
+}
+
+// fetches the objects of the bucket as they were at the snapshot time of the volume into `dir`, the
+// objects of a previous, interrupted restore are removed first. The files are made read only.
+// The restore stops when `ctx` is cancelled (timeout of the request, shutdown).
+// Returns the number of restored objects.
+func restoreSnapshot(ctx context.Context, v *mountInfo, dir string) (int, error) {
+	versions, err := snapshotVersions(ctx, v.config, v.config.snapshot)
+	if err != nil {
+		return 0, err
+	}
+	fields := logrus.Fields{
+		"volume":   v.name,
+		"bucket":   v.config.bucket,
+		"snapshot": v.config.snapshot.Format(time.RFC3339),
+	}
+	logrus.WithFields(fields).Infof("Restoring %d objects of the snapshot.", len(versions))
+
+	if err := os.RemoveAll(dir); err != nil {
+		return 0, err
+	}
+	if err := createDir(dir); err != nil {
+		return 0, err
+	}
+	for _, o := range versions {
+		if ctx.Err() != nil {
+			return 0, fmt.Errorf("restore cancelled: %v", ctx.Err())
+		}
+		if err := restoreObjectVersion(ctx, v, dir, o); err != nil {
+			return 0, newCodedError(errorCodeOf(err), "restoring %s failed: %v", o.Key, err)
+		}
+	}
+	return len(versions), nil
+}
+
+// downloads a version of an object into `dir`.
+func restoreObjectVersion(ctx context.Context, v *mountInfo, dir string, o objectVersion) error {
+	// keys escaping the directory (ex: "../x") are not restored.
+	path := filepath.Join(dir, filepath.FromSlash(o.Key))
+	if !strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+		logrus.WithField("volume", v.name).Warnf("Skipping object %q outside of the mountpoint.", o.Key)
+		return nil
+	}
+	// "directory" objects.
+	if strings.HasSuffix(o.Key, "/") {
+		return createDir(path)
+	}
+	if err := createDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	resp, err := s3RequestContext(ctx, v.config, "GET", o.Key, url.Values{"versionId": {o.VersionID}}, v.config.region, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0444)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(path, o.LastModified, o.LastModified)
+}
+
+// bind mounts the restored objects of the snapshot volume at its mountpoint, read only.
+// The bind mount is made read only by remounting it, `mount --bind -o ro` ignores the flag on older kernels.
+func mountSnapshot(ctx context.Context, v *mountInfo, dir string) error {
+	if err := bindMount(ctx, dir, v.mountPoint); err != nil {
+		return err
+	}
+	if out, err := exec.CommandContext(ctx, "mount", "-o", "remount,bind,ro", v.mountPoint).CombinedOutput(); err != nil {
+		// the objects of the snapshot are never served writable.
+		lazyUnmount(v.mountPoint)
+		return fmt.Errorf("remounting %s read only failed: %v %s", v.mountPoint, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SSE-KMS - With `-o sse-kms-key-id=<key>`, all the objects written through the volume are encrypted by
+// the server with the given KMS key: minfs is passed the key with `sse_kms_key_id`, and the objects
+// written by the plugin itself (ex: the write check) are sent with the SSE-KMS headers.
+
+// headers requesting the server side encryption of the written objects with a KMS key.
+const (
+	sseHeader         = "X-Amz-Server-Side-Encryption"
+	sseKMSKeyIDHeader = "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"
+	sseKMS            = "aws:kms"
+)
+
+// validates the id of a KMS key (ex: an ARN or a key alias), passed as a mount option to minfs.
+func validateSSEKMSKeyID(id string) error {
+	if strings.TrimSpace(id) == "" || strings.ContainsAny(id, ", \t\n\"") {
+		return fmt.Errorf("invalid value %q for sse-kms-key-id option, must be the id, ARN or alias of a KMS key.", id)
+	}
+	return nil
+}
+
+// returns the headers of the objects written to the bucket of the volume, nil without server side encryption.
+func sseHeaders(config serverConfig) map[string]string {
+	if config.sseKMSKeyID == "" {
+		return nil
+	}
+	return map[string]string{
+		sseHeader:         sseKMS,
+		sseKMSKeyIDHeader: config.sseKMSKeyID,
+	}
+}
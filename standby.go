@@ -0,0 +1,241 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Active/standby - Two instances of the plugin can share `--state-file`: the active instance holds the locks
+// of the mount root and of the state file while it runs (see `lockInstance`), and the instance started with
+// `--standby` follows the updates of the state file until the locks are released, when the active instance
+// exits or dies. The standby then restores the volumes of the state file, adopts the mounts of minfs left
+// by the active instance and serves the sockets of the plugin. Both instances have to run on the same host,
+// in the same mount and PID namespaces, so that the mounts and the minfs processes can be adopted.
+
+// Adopting the mounts - When the plugin starts with `--state-file`, the live minfs mounts at the
+// mountpoints of the restored volumes are supervised again rather than left behind: the minfs process
+// serving the mount is looked up in /proc, and the connections of the volume are set to the number of
+// containers using it. The plugin follows the log file minfs writes its output to again (see
+// `createMinfsLog`), a minfs writing to pipes of the previous instance would die on its next write and
+// isn't adopted. The stale mounts of dead minfs processes are detached.
+
+// interval at which the standby checks the lock of the active instance and the state file.
+const standbyPollInterval = time.Second
+
+// interval at which an adopted minfs process is checked, it's not a child of the plugin and can't be waited for.
+const adoptedPollInterval = time.Second
+
+// waits until the locks of the active instance are released and takes them, following the updates of the
+// state file written by the active instance in the meantime.
+func awaitTakeover(paths []string, stateFile string) error {
+	logrus.Infof("Standing by until the active instance releases %s.", strings.Join(paths, ", "))
+	var modified time.Time
+	for {
+		err := lockInstance(paths)
+		if err == nil {
+			logrus.Info("Active instance is gone, taking over.")
+			return nil
+		}
+		if _, held := err.(errLockHeld); !held {
+			return err
+		}
+		if fi, err := os.Stat(stateFile); err == nil && !fi.ModTime().Equal(modified) {
+			modified = fi.ModTime()
+			// the bundle is read again on takeover, it's only checked here so that a state the standby
+			// couldn't restore is reported before it's needed.
+			if bundle, err := readStateBundle(stateFile); err != nil {
+				logrus.Warnf("The state written by the active instance can't be read. <ERROR> %v", err)
+			} else {
+				logrus.WithField("volumes", len(bundle.Volumes)).Debug("State of the active instance updated.")
+			}
+		}
+		time.Sleep(standbyPollInterval)
+	}
+}
+
+// supervises the live minfs mounts at the mountpoints of the restored volumes again.
+func (d *minfsDriver) adoptMounts() {
+	d.RLock()
+	var names []string
+	for name := range d.mounts {
+		names = append(names, name)
+	}
+	d.RUnlock()
+
+	for _, name := range names {
+		if v := d.lockVolume(name); v != nil {
+			d.Lock()
+			d.adoptMount(v)
+			d.Unlock()
+			v.ops.Unlock()
+		}
+	}
+}
+
+// supervises the live minfs mount at the mountpoint of the volume again, if there's one.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) adoptMount(v *mountInfo) {
+	name := v.name
+	if v.mounted() || !isFuseMounted(v.mountPoint) {
+		return
+	}
+	log := logrus.WithFields(logrus.Fields{"volume": name, "mountpoint": v.mountPoint})
+	pid, args := findMinfsProcess(d.minfsBinary, v.mountPoint)
+	var output string
+	if pid != 0 {
+		output = minfsOutput(pid)
+	}
+	// only the mounts of a single minfs process running on the host are adopted, whose output doesn't go
+	// to the previous instance, and whose requests aren't traced through its proxy (`-o s3-trace`).
+	if pid == 0 || output == "" || d.docker != nil || d.shareMounts || len(v.config.union) > 0 || v.config.cacheType != "" ||
+		v.config.encryptCache || v.config.traceFile != "" {
+		if err := lazyUnmount(v.mountPoint); err != nil {
+			log.Errorf("Detaching the mount left by the previous instance failed. <ERROR> %v", err)
+			return
+		}
+		log.Warn("Mount left by the previous instance can't be adopted, detached.")
+		return
+	}
+	if dir := minfsCacheOption(args); dir != "" {
+		v.cacheDir = dir
+	}
+	p := adoptedProcess(pid, args, v.output, output)
+	p.started = time.Now()
+	p.watch()
+	d.supervise(v, p)
+	v.lastMounted = time.Now()
+	var containers []string
+	err := d.unlocked(func(context.Context) (err error) {
+		containers, err = d.dockerAPI.volumeContainers(name)
+		return err
+	})
+	if err != nil {
+		// the connections are repaired once the containers can be listed, see `reconcileConnections`.
+		log.Warnf("Unable to list the containers using the adopted mount. <ERROR> %v", err)
+		v.connections = 1
+	} else if v.connections = len(containers); v.connections == 0 {
+		if err := d.releaseVolume(v); err != nil {
+			log.Errorf("Unmounting the adopted mount no container uses failed. <ERROR> %v", err)
+			v.connections = 1
+		}
+		return
+	}
+	log.WithFields(p.fields()).WithField("connections", v.connections).Info("Mount of the previous instance adopted.")
+}
+
+// returns true while the process runs the command line `args`. A zombie has no command line, and the pid of
+// an exited process may be reused by another command.
+func isProcessRunning(pid int, args []string) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	cmdline, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	return err == nil && string(cmdline) == strings.Join(args, "\x00")+"\x00"
+}
+
+// returns the pid and the arguments of the minfs process serving the mountpoint, 0 if there's none.
+func findMinfsProcess(binary, mountPoint string) (int, []string) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0, nil
+	}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		cmdline, err := ioutil.ReadFile(filepath.Join("/proc", e.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		// ex: minfs\0-o\0cache=/tmp/.cache/vol\0https://play.minio.io:9000/testbucket\0/tmp/vol\0
+		args := strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+		if len(args) >= 2 && filepath.Base(args[0]) == filepath.Base(binary) && args[len(args)-1] == mountPoint {
+			return pid, args
+		}
+	}
+	return 0, nil
+}
+
+// returns the file the output of the minfs process goes to, empty if it goes to a pipe or a socket which
+// the process can't outlive.
+func minfsOutput(pid int) string {
+	var output string
+	for _, fd := range []string{"1", "2"} {
+		target, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "fd", fd))
+		if err != nil || !filepath.IsAbs(target) || (output != "" && target != output) {
+			return ""
+		}
+		output = target
+	}
+	return output
+}
+
+// returns the cache directory in the options of the minfs command line, empty if not set.
+func minfsCacheOption(args []string) string {
+	for i, arg := range args {
+		if arg != "-o" || i+1 >= len(args) {
+			continue
+		}
+		for _, opt := range strings.Split(args[i+1], ",") {
+			if strings.HasPrefix(opt, "cache=") {
+				return strings.TrimPrefix(opt, "cache=")
+			}
+		}
+	}
+	return ""
+}
+
+// returns a minfsProcess for the minfs process left by the previous instance of the plugin, whose command
+// line is `args`. The log file the process writes its output to is followed into the output of the volume.
+func adoptedProcess(pid int, args []string, tail *outputTail, output string) *minfsProcess {
+	exited := make(chan struct{})
+	followed := make(chan struct{})
+	if isMinfsLog(output) {
+		var offset int64
+		if fi, err := os.Stat(output); err == nil {
+			offset = fi.Size()
+		}
+		followed = tail.follow(output, offset, exited)
+	} else {
+		close(followed)
+	}
+	return &minfsProcess{
+		pid: pid,
+		wait: func() error {
+			for isProcessRunning(pid, args) {
+				time.Sleep(adoptedPollInterval)
+			}
+			close(exited)
+			<-followed
+			return nil
+		},
+		kill: func() error {
+			return syscall.Kill(pid, syscall.SIGKILL)
+		},
+	}
+}
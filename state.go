@@ -0,0 +1,531 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// version of the format of the state bundles.
+const stateBundleVersion = 1
+
+// valid names of the volumes of a bundle, as validated by docker when a volume is created.
+var volumeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// stateBundle - Definitions of all the volumes of the plugin, exported to move the volumes to another host
+// or to recover them after the loss of a host. The credentials of the volumes are encrypted with the
+// key of `--state-key-file`, the same key has to be used to import the bundle.
+type stateBundle struct {
+	Version  int           `json:"version"`
+	Exported time.Time     `json:"exported"`
+	Volumes  []volumeState `json:"volumes"`
+}
+
+// volumeState - definition of a volume in the state bundle.
+type volumeState struct {
+	Name string `json:"name"`
+	// alias of the driver the volume was created with, empty for the main driver.
+	Driver           string            `json:"driver,omitempty"`
+	Endpoint         string            `json:"endpoint"`
+	Bucket           string            `json:"bucket"`
+	Region           string            `json:"region,omitempty"`
+	ObjectLocking    bool              `json:"objectLocking,omitempty"`
+	Anonymous        bool              `json:"anonymous,omitempty"`
+	ServiceAccount   bool              `json:"serviceAccount,omitempty"`
+	Snapshot         string            `json:"snapshot,omitempty"`
+	Signature        string            `json:"signature,omitempty"`
+	Addressing       string            `json:"addressing,omitempty"`
+	Consistency      string            `json:"consistency,omitempty"`
+	WatchChanges     bool              `json:"watchChanges,omitempty"`
+	Propagation      string            `json:"propagation,omitempty"`
+	SELinuxLabel     string            `json:"selinuxLabel,omitempty"`
+	Owner            string            `json:"owner,omitempty"`
+	Mode             string            `json:"mode,omitempty"`
+	Umask            string            `json:"umask,omitempty"`
+	EncryptCache     bool              `json:"encryptCache,omitempty"`
+	Prefetch         []string          `json:"prefetch,omitempty"`
+	FlushOnUnmount   bool              `json:"flushOnUnmount,omitempty"`
+	IsolateByMountID bool              `json:"isolateByMountId,omitempty"`
+	MemoryLimit      string            `json:"memoryLimit,omitempty"`
+	CPUQuota         string            `json:"cpuQuota,omitempty"`
+	MountRoot        string            `json:"mountRoot,omitempty"`
+	CacheType        string            `json:"cacheType,omitempty"`
+	CacheSize        string            `json:"cacheSize,omitempty"`
+	RetainCache      bool              `json:"retainCache,omitempty"`
+	PurgeOnRemove    bool              `json:"purgeOnRemove,omitempty"`
+	ForcePurge       bool              `json:"forcePurge,omitempty"`
+	SSEKMSKeyID      string            `json:"sseKmsKeyId,omitempty"`
+	StorageClass     string            `json:"storageClass,omitempty"`
+	ExpiryDays       int               `json:"expiryDays,omitempty"`
+	ExpiryRules      string            `json:"expiryRules,omitempty"`
+	BucketPolicy     string            `json:"bucketPolicy,omitempty"`
+	MinfsLogLevel    string            `json:"minfsLogLevel,omitempty"`
+	Quota            int64             `json:"quota,omitempty"`
+	QuotaAction      string            `json:"quotaAction,omitempty"`
+	HostOverrides    map[string]string `json:"hostOverrides,omitempty"`
+	Buckets          string            `json:"buckets,omitempty"`
+	Proxy            string            `json:"proxy,omitempty"`
+	NoProxy          string            `json:"noProxy,omitempty"`
+	S3Trace          bool              `json:"s3Trace,omitempty"`
+	// times of the snapshots taken of the volume, see `takeSnapshot`.
+	Snapshots []string `json:"snapshots,omitempty"`
+	// volume and bucket the bucket of the volume is being cloned from, see `startClone`.
+	CloneFrom   string `json:"cloneFrom,omitempty"`
+	CloneBucket string `json:"cloneBucket,omitempty"`
+	// time at which the volume was created, the volume keeps it when it's imported.
+	CreatedAt time.Time `json:"createdAt"`
+	// files the credentials are read from, see `-o access-key-file`.
+	AccessKeyFile string `json:"accessKeyFile,omitempty"`
+	SecretKeyFile string `json:"secretKeyFile,omitempty"`
+	// credential provider and reference of the secret holding the credentials, see `-o vault-path`.
+	// The credentials of these volumes are not exported, they're fetched again after the import.
+	CredentialProvider string `json:"credentialProvider,omitempty"`
+	CredentialRef      string `json:"credentialRef,omitempty"`
+	// access and secret key, encrypted and base64 encoded.
+	Credentials string `json:"credentials,omitempty"`
+	// reference of the credentials kept in the credential store of the host instead, see `--credential-store`.
+	CredentialStoreRef string `json:"credentialStoreRef,omitempty"`
+	// credentials written to the credential store once the driver lock is released, see `storeBundleCredentials`.
+	storeCredentials *volumeCredentials
+}
+
+// volumeCredentials - plain text of the encrypted credentials of a volume.
+type volumeCredentials struct {
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+}
+
+// stateCipher - Encrypts the credentials of the exported volumes with AES-256-GCM.
+// The key is derived from the passphrase read from `--state-key-file`.
+type stateCipher struct {
+	aead cipher.AEAD
+}
+
+// return a new stateCipher for the passphrase.
+func newStateCipher(passphrase string) (*stateCipher, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("state key cannot be empty")
+	}
+	key := sha256.Sum256([]byte(passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &stateCipher{aead: aead}, nil
+}
+
+// reads the passphrase from the file and returns a stateCipher for it.
+func loadStateCipher(path string) (*stateCipher, error) {
+	passphrase, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newStateCipher(strings.TrimSpace(string(passphrase)))
+}
+
+// encrypts the credentials, the random nonce is prepended to the cipher text.
+func (c *stateCipher) seal(creds volumeCredentials) (string, error) {
+	plain, err := json.Marshal(creds)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// decrypts the credentials sealed by `seal`.
+func (c *stateCipher) open(sealed string) (volumeCredentials, error) {
+	var creds volumeCredentials
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return creds, err
+	}
+	if len(data) < c.aead.NonceSize() {
+		return creds, fmt.Errorf("encrypted credentials are truncated")
+	}
+	nonce := data[:c.aead.NonceSize()]
+	plain, err := c.aead.Open(nil, nonce, data[c.aead.NonceSize():], nil)
+	if err != nil {
+		return creds, fmt.Errorf("unable to decrypt the credentials, wrong state key?")
+	}
+	err = json.Unmarshal(plain, &creds)
+	return creds, err
+}
+
+// exports the definitions of all the volumes. The credentials of the bundle written to the state file
+// are kept in the credential store if there's one, `stateFile` is set: they're written by
+// `storeBundleCredentials`, which doesn't need the driver lock.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) exportState(stateFile bool) (stateBundle, error) {
+	bundle := stateBundle{
+		Version:  stateBundleVersion,
+		Exported: time.Now().UTC(),
+		Volumes:  []volumeState{},
+	}
+	useStore := stateFile && d.credentialStore != nil
+	if d.stateCipher == nil && !useStore {
+		return bundle, fmt.Errorf("--state-key-file is required to export the volumes")
+	}
+	for name, v := range d.mounts {
+		s := volumeState{
+			Name:               name,
+			Driver:             v.driver,
+			Endpoint:           v.config.endpoint,
+			Bucket:             v.config.bucket,
+			Region:             v.config.region,
+			ObjectLocking:      v.config.objectLocking,
+			Anonymous:          v.config.anonymous,
+			ServiceAccount:     v.config.serviceAccount,
+			Signature:          v.config.signature,
+			Addressing:         v.config.addressing,
+			Consistency:        v.config.consistency,
+			WatchChanges:       v.config.watchChanges,
+			Propagation:        v.config.propagation,
+			SELinuxLabel:       v.config.selinuxLabel,
+			Owner:              v.config.owner,
+			Mode:               v.config.mode,
+			Umask:              v.config.umask,
+			EncryptCache:       v.config.encryptCache,
+			Prefetch:           v.config.prefetch,
+			FlushOnUnmount:     v.config.flushOnUnmount,
+			IsolateByMountID:   v.config.isolateByMountID,
+			MemoryLimit:        v.config.memoryLimit,
+			CPUQuota:           v.config.cpuQuota,
+			MountRoot:          v.config.mountRoot,
+			CacheType:          v.config.cacheType,
+			CacheSize:          v.config.cacheSize,
+			RetainCache:        v.config.retainCache,
+			PurgeOnRemove:      v.config.purgeOnRemove,
+			ForcePurge:         v.config.forcePurge,
+			SSEKMSKeyID:        v.config.sseKMSKeyID,
+			StorageClass:       v.config.storageClass,
+			BucketPolicy:       v.config.bucketPolicy,
+			MinfsLogLevel:      v.config.minfsLogLevel,
+			Quota:              v.config.quota,
+			QuotaAction:        v.config.quotaAction,
+			HostOverrides:      v.config.hostOverrides,
+			Buckets:            formatUnionBuckets(v.config.union),
+			Proxy:              v.config.proxy,
+			NoProxy:            v.config.noProxy,
+			S3Trace:            v.config.traceFile != "",
+			CreatedAt:          v.createdAt,
+			AccessKeyFile:      v.config.accessKeyFile,
+			SecretKeyFile:      v.config.secretKeyFile,
+			CredentialProvider: v.config.credentialProvider,
+			CredentialRef:      v.config.credentialRef,
+		}
+		if len(v.config.endpoints) > 0 {
+			s.Endpoint = strings.Join(v.config.endpoints, ",")
+		}
+		if !v.config.snapshot.IsZero() {
+			s.Snapshot = v.config.snapshot.Format(time.RFC3339)
+		}
+		for _, t := range v.snapshots {
+			s.Snapshots = append(s.Snapshots, t.Format(time.RFC3339))
+		}
+		if v.clone != nil {
+			s.CloneFrom, s.CloneBucket = v.clone.volume, v.clone.config.bucket
+		}
+		s.ExpiryDays, s.ExpiryRules = formatExpiryRules(v.config.expiry)
+		if v.missingCredentials != "" {
+			// the reference is kept until the credentials are set again.
+			s.CredentialStoreRef = v.missingCredentials
+		} else if !v.config.anonymous && v.config.credentialProvider == "" {
+			creds := volumeCredentials{AccessKey: v.config.accessKey, SecretKey: v.config.secretKey}
+			if useStore {
+				s.CredentialStoreRef = credentialStoreRef(d.credentialStore, name)
+				s.storeCredentials = &creds
+			} else {
+				sealed, err := d.stateCipher.seal(creds)
+				if err != nil {
+					return bundle, err
+				}
+				s.Credentials = sealed
+			}
+		}
+		bundle.Volumes = append(bundle.Volumes, s)
+	}
+	sort.Slice(bundle.Volumes, func(i, j int) bool { return bundle.Volumes[i].Name < bundle.Volumes[j].Name })
+	return bundle, nil
+}
+
+// importResult - outcome of the import of a state bundle.
+type importResult struct {
+	// volumes added to the plugin.
+	Imported []string `json:"imported"`
+	// volumes skipped since a volume of the same name already exists.
+	Skipped []string `json:"skipped"`
+}
+
+// registers the volumes of the bundle, existing volumes are left untouched.
+// The buckets are not verified, so that the volumes can be imported while the servers are unreachable,
+// they are verified on the first mount. The copies of the buckets of the clones in progress are started
+// again. The volumes whose credentials are missing from the credential
+// store are imported without them, see `stateVolumes`.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) importState(bundle stateBundle) (importResult, error) {
+	res := importResult{Imported: []string{}, Skipped: []string{}}
+	// validate the whole bundle before importing any volume.
+	mounts, err := d.stateVolumes(bundle)
+	if err != nil {
+		return res, err
+	}
+	for _, v := range mounts {
+		if _, ok := d.mounts[v.name]; ok {
+			res.Skipped = append(res.Skipped, v.name)
+			continue
+		}
+		v.cacheDir = d.cachePath(v)
+		d.mounts[v.name] = v
+		if v.clone != nil {
+			d.startClone(v)
+		}
+		res.Imported = append(res.Imported, v.name)
+	}
+	d.countVolumes()
+	d.markDirty()
+	logrus.WithFields(logrus.Fields{
+		"imported": len(res.Imported),
+		"skipped":  len(res.Skipped),
+	}).Info("State bundle imported.")
+	return res, nil
+}
+
+// validates the volumes of the bundle and returns them, nothing is registered or created.
+// A volume whose credentials are missing from the credential store (the kernel keyring doesn't survive a
+// reboot) is returned without them rather than failing the whole bundle, its mounts fail until the
+// credentials are set again with `-o rotate-credentials=true`.
+// Has to be called with the driver lock held.
+func (d *minfsDriver) stateVolumes(bundle stateBundle) ([]*mountInfo, error) {
+	if bundle.Version != stateBundleVersion {
+		return nil, fmt.Errorf("unsupported state bundle version %d", bundle.Version)
+	}
+	mounts := make([]*mountInfo, 0, len(bundle.Volumes))
+	for _, s := range bundle.Volumes {
+		endpoints := splitEndpoints(s.Endpoint)
+		if s.Name == "" || len(endpoints) == 0 || s.Bucket == "" {
+			return nil, fmt.Errorf("volume %q of the bundle is missing its name, endpoint or bucket", s.Name)
+		}
+		if !volumeNameRegexp.MatchString(s.Name) {
+			return nil, fmt.Errorf("invalid volume name %q in the bundle", s.Name)
+		}
+		var missingCredentials string
+		config := serverConfig{
+			endpoint:           endpoints[0],
+			bucket:             s.Bucket,
+			region:             s.Region,
+			objectLocking:      s.ObjectLocking,
+			anonymous:          s.Anonymous,
+			serviceAccount:     s.ServiceAccount,
+			signature:          s.Signature,
+			addressing:         s.Addressing,
+			consistency:        s.Consistency,
+			watchChanges:       s.WatchChanges,
+			propagation:        s.Propagation,
+			selinuxLabel:       s.SELinuxLabel,
+			owner:              s.Owner,
+			mode:               s.Mode,
+			umask:              s.Umask,
+			encryptCache:       s.EncryptCache,
+			prefetch:           s.Prefetch,
+			flushOnUnmount:     s.FlushOnUnmount,
+			isolateByMountID:   s.IsolateByMountID,
+			memoryLimit:        s.MemoryLimit,
+			cpuQuota:           s.CPUQuota,
+			mountRoot:          s.MountRoot,
+			cacheType:          s.CacheType,
+			cacheSize:          s.CacheSize,
+			retainCache:        s.RetainCache,
+			purgeOnRemove:      s.PurgeOnRemove,
+			forcePurge:         s.ForcePurge,
+			sseKMSKeyID:        s.SSEKMSKeyID,
+			storageClass:       s.StorageClass,
+			bucketPolicy:       s.BucketPolicy,
+			minfsLogLevel:      s.MinfsLogLevel,
+			quota:              s.Quota,
+			quotaAction:        s.QuotaAction,
+			hostOverrides:      s.HostOverrides,
+			proxy:              s.Proxy,
+			noProxy:            s.NoProxy,
+			accessKeyFile:      s.AccessKeyFile,
+			secretKeyFile:      s.SecretKeyFile,
+			credentialProvider: s.CredentialProvider,
+			credentialRef:      s.CredentialRef,
+		}
+		if len(endpoints) > 1 {
+			config.endpoints = endpoints
+		}
+		if s.ExpiryDays != 0 || s.ExpiryRules != "" {
+			expiry := map[string]string{"expiry-rules": s.ExpiryRules}
+			if s.ExpiryDays != 0 {
+				expiry["expiry-days"] = strconv.Itoa(s.ExpiryDays)
+			}
+			rules, err := parseExpiryOptions(expiry)
+			if err != nil {
+				return nil, fmt.Errorf("volume %s: %v", s.Name, err)
+			}
+			config.expiry = rules
+		}
+		if s.Buckets != "" {
+			union, err := parseUnionBuckets(s.Buckets, !d.legacyBucketNames)
+			if err != nil {
+				return nil, fmt.Errorf("volume %s: %v", s.Name, err)
+			}
+			config.union = union
+		}
+		if config.region == "" {
+			config.region = defaultLocation
+		}
+		if s.S3Trace {
+			config.traceFile = d.tracePath(s.Name, config)
+		}
+		if s.Snapshot != "" {
+			t, err := time.Parse(time.RFC3339, s.Snapshot)
+			if err != nil {
+				return nil, fmt.Errorf("volume %s: invalid snapshot %q", s.Name, s.Snapshot)
+			}
+			config.snapshot = t
+		}
+		// the source volume may have been removed since, only its bucket is copied.
+		var clone *cloneSource
+		if s.CloneFrom != "" {
+			if err := validateBucketName(s.CloneBucket, !d.legacyBucketNames); err != nil {
+				return nil, fmt.Errorf("volume %s: invalid clone bucket: %v", s.Name, err)
+			}
+			clone = &cloneSource{volume: s.CloneFrom, config: serverConfig{bucket: s.CloneBucket}}
+		}
+		// the bundle may be hand edited or written by an older plugin, its configs are validated as by Create.
+		if err := d.validateConfig(s.Name, config, clone != nil); err != nil {
+			return nil, fmt.Errorf("volume %s: %v", s.Name, err)
+		}
+		var snapshots []time.Time
+		for _, snapshot := range s.Snapshots {
+			t, err := time.Parse(time.RFC3339, snapshot)
+			if err != nil {
+				return nil, fmt.Errorf("volume %s: invalid snapshot %q", s.Name, snapshot)
+			}
+			snapshots = append(snapshots, t)
+		}
+		if s.CredentialProvider != "" {
+			if _, ok := d.providers[s.CredentialProvider]; !ok {
+				return nil, fmt.Errorf("volume %s: credential provider %s is not configured", s.Name, s.CredentialProvider)
+			}
+		} else if s.CredentialStoreRef != "" {
+			creds, err := d.loadStoredCredentials(s.CredentialStoreRef)
+			if err != nil {
+				logrus.WithField("volume", s.Name).Warnf("Volume restored without its credentials, set them with -o rotate-credentials=true. <ERROR> %v", err)
+				missingCredentials = s.CredentialStoreRef
+			}
+			config.accessKey, config.secretKey = creds.AccessKey, creds.SecretKey
+		} else if !s.Anonymous {
+			if d.stateCipher == nil {
+				return nil, fmt.Errorf("--state-key-file is required to import the credentials of the volumes")
+			}
+			creds, err := d.stateCipher.open(s.Credentials)
+			if err != nil {
+				return nil, fmt.Errorf("volume %s: %v", s.Name, err)
+			}
+			config.accessKey, config.secretKey = creds.AccessKey, creds.SecretKey
+		}
+		createdAt := s.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now().UTC()
+		}
+		// the mountpoint is removed with the volume, it has to be a directory of its own under the mount root.
+		root := filepath.Clean(d.volumeMountRoot(config))
+		mountPoint := filepath.Join(root, s.Name)
+		if filepath.Dir(mountPoint) != root || filepath.Base(mountPoint) != s.Name {
+			return nil, fmt.Errorf("volume %s: mountpoint %s is not directly under the mount root %s", s.Name, mountPoint, root)
+		}
+		mounts = append(mounts, &mountInfo{
+			ops:                new(sync.Mutex),
+			name:               s.Name,
+			config:             config,
+			mountPoint:         mountPoint,
+			output:             newOutputTail(s.Name, d.outputLines),
+			createdAt:          createdAt,
+			driver:             s.Driver,
+			snapshots:          snapshots,
+			clone:              clone,
+			missingCredentials: missingCredentials,
+		})
+	}
+	return mounts, nil
+}
+
+// imports the state bundle stored in the file, used by `--import-state`.
+func (d *minfsDriver) importStateFile(path string) (importResult, error) {
+	bundle, err := readStateBundle(path)
+	if err != nil {
+		return importResult{}, err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	return d.importState(bundle)
+}
+
+// validates the volumes of the state bundle stored in the file without importing them, used by `--check-config`.
+func (d *minfsDriver) validateStateFile(path string) ([]*mountInfo, error) {
+	bundle, err := readStateBundle(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.stateVolumes(bundle)
+}
+
+// reads the state bundle stored in the file.
+func readStateBundle(path string) (stateBundle, error) {
+	var bundle stateBundle
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return bundle, err
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return bundle, fmt.Errorf("invalid state bundle %s: %v", path, err)
+	}
+	return bundle, nil
+}
@@ -0,0 +1,185 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// memoryStore - credential store keeping the credentials in memory.
+type memoryStore map[string]volumeCredentials
+
+func (memoryStore) name() string { return credentialStoreKeyring }
+
+func (s memoryStore) store(volume string, creds volumeCredentials) error {
+	s[volume] = creds
+	return nil
+}
+
+func (s memoryStore) load(volume string) (volumeCredentials, error) {
+	creds, ok := s[volume]
+	if !ok {
+		return creds, fmt.Errorf("key minfs:%s not found", volume)
+	}
+	return creds, nil
+}
+
+func (s memoryStore) remove(volume string) error {
+	delete(s, volume)
+	return nil
+}
+
+// The volumes whose credentials are missing from the credential store are restored without them, and keep
+// their reference until the credentials are set again.
+func TestStateVolumesMissingCredentials(t *testing.T) {
+	d := newTestDriver(t)
+	store := memoryStore{"kept": {AccessKey: "access", SecretKey: "secret"}}
+	d.credentialStore = store
+	bundle := stateBundle{Version: stateBundleVersion, Exported: time.Now()}
+	for _, name := range []string{"kept", "lost"} {
+		bundle.Volumes = append(bundle.Volumes, volumeState{
+			Name:               name,
+			Endpoint:           "http://127.0.0.1:1",
+			Bucket:             name + "-bucket",
+			CredentialStoreRef: credentialStoreRef(store, name),
+		})
+	}
+
+	d.Lock()
+	res, err := d.importState(bundle)
+	d.Unlock()
+	if err != nil {
+		t.Fatalf("expected the bundle to be imported, got %v", err)
+	}
+	if len(res.Imported) != 2 {
+		t.Fatalf("expected both volumes to be imported, got %v", res.Imported)
+	}
+	if v := d.mounts["kept"]; v.missingCredentials != "" || v.config.accessKey != "access" {
+		t.Errorf("expected the credentials of kept to be read from the store, got %+v", v.config)
+	}
+	if v := d.mounts["lost"]; v.missingCredentials != "keyring:lost" {
+		t.Errorf("expected lost to be restored without its credentials, got %q", v.missingCredentials)
+	}
+
+	d.Lock()
+	exported, err := d.exportState(true)
+	d.Unlock()
+	if err == nil {
+		err = d.storeBundleCredentials(exported)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range exported.Volumes {
+		if s.CredentialStoreRef != credentialStoreRef(store, s.Name) {
+			t.Errorf("%s: expected the reference of the credentials to be kept, got %q", s.Name, s.CredentialStoreRef)
+		}
+	}
+	if _, ok := store["lost"]; ok {
+		t.Error("expected the missing credentials not to be written to the store.")
+	}
+}
+
+// The snapshots taken of a volume are kept in the state bundle.
+func TestStateSnapshots(t *testing.T) {
+	d := newTestDriver(t)
+	cipher, err := newStateCipher("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.stateCipher = cipher
+	v := addTestVolume(d, "versioned", "http://127.0.0.1:1", "versioned-bucket", 0)
+	taken := time.Date(2017, 1, 30, 15, 4, 5, 0, time.UTC)
+	v.snapshots = []time.Time{taken}
+
+	d.Lock()
+	bundle, err := d.exportState(false)
+	d.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newTestDriver(t)
+	restored.stateCipher = cipher
+	restored.Lock()
+	_, err = restored.importState(bundle)
+	restored.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := restored.mounts["versioned"].snapshots; len(s) != 1 || !s[0].Equal(taken) {
+		t.Errorf("expected the snapshot %s to be restored, got %v", taken, s)
+	}
+}
+
+// The clones in progress are kept in the state bundle, and their copy is started again on import.
+func TestStateClone(t *testing.T) {
+	d := newTestDriver(t)
+	cipher, err := newStateCipher("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.stateCipher = cipher
+	v := addTestVolume(d, "copy", "http://127.0.0.1:1", "copy-bucket", 0)
+	v.clone = &cloneSource{volume: "golden", config: serverConfig{bucket: "golden-bucket"}}
+
+	d.Lock()
+	bundle, err := d.exportState(false)
+	d.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newTestDriver(t)
+	restored.stateCipher = cipher
+	restored.Lock()
+	_, err = restored.importState(bundle)
+	c := restored.mounts["copy"].clone
+	restored.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || c.volume != "golden" || c.config.bucket != "golden-bucket" || c.done == nil {
+		t.Fatalf("expected the clone from golden to be restored and started, got %+v", c)
+	}
+	// the endpoint is unreachable.
+	<-c.done
+	if c.err == nil {
+		t.Error("expected the copy of the bucket to fail.")
+	}
+}
+
+// The configs of the imported volumes are validated as by Create.
+func TestStateVolumesValidateConfig(t *testing.T) {
+	d := newTestDriver(t)
+	testCases := []volumeState{
+		{Consistency: consistencyCached, WatchChanges: true},
+		{ForcePurge: true},
+		{NoProxy: "localhost"},
+		{Buckets: "other-bucket,first-bucket"},
+	}
+	for i, s := range testCases {
+		s.Name, s.Endpoint, s.Bucket, s.Anonymous = "invalid", "http://127.0.0.1:1", "first-bucket", true
+		bundle := stateBundle{Version: stateBundleVersion, Volumes: []volumeState{s}}
+		d.Lock()
+		_, err := d.stateVolumes(bundle)
+		d.Unlock()
+		if err == nil {
+			t.Errorf("Test %d: expected the bundle to be rejected.", i+1)
+		}
+	}
+}
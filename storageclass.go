@@ -0,0 +1,54 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Storage classes - With `-o storage-class=<class>`, the objects written through the volume are stored
+// with the given storage class (ex: REDUCED_REDUNDANCY for large scratch volumes on tiered deployments):
+// minfs is passed the class with `storage_class`, and the objects written by the plugin itself (ex: the
+// write check) are sent with the storage class header. Custom classes of the server are accepted.
+
+// header of the storage class of the written objects.
+const storageClassHeader = "X-Amz-Storage-Class"
+
+// storage classes are upper case words separated by underscores (ex: STANDARD, REDUCED_REDUNDANCY).
+var storageClassRegexp = regexp.MustCompile(`^[A-Z0-9][A-Z0-9_]*$`)
+
+// validates the storage class option.
+func validateStorageClass(class string) error {
+	if !storageClassRegexp.MatchString(class) {
+		return fmt.Errorf("invalid value %q for storage-class option, must be a storage class of the server (ex: STANDARD, REDUCED_REDUNDANCY).", class)
+	}
+	return nil
+}
+
+// returns the headers of the objects written by the plugin to the bucket of the volume, with the server
+// side encryption (see `sseHeaders`) and the storage class of the volume.
+func writeHeaders(config serverConfig) map[string]string {
+	headers := sseHeaders(config)
+	if config.storageClass != "" {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[storageClassHeader] = config.storageClass
+	}
+	return headers
+}
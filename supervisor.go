@@ -0,0 +1,453 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// delay before the first restart of a crashed minfs process,
+	// doubled on every consecutive failure.
+	minfsRestartBackoff = time.Second
+	// upper bound on the delay between restarts.
+	minfsMaxRestartBackoff = time.Minute
+	// a minfs process which has been serving for longer than this is considered stable,
+	// the backoff is reset on its next crash.
+	minfsStableRuntime = time.Minute
+	// time given to minfs to exit after the unmount before it is killed.
+	minfsStopTimeout = 10 * time.Second
+)
+
+// minfsProcess - A running instance of minfs serving the mount of a single volume.
+// The process is started and watched by the driver, if it exits without
+// the driver asking for it, the volume is remounted with backoff.
+// minfs runs either as a child process of the plugin or in a helper container, see `--minfs-image`.
+type minfsProcess struct {
+	// pid of the minfs process, 0 when minfs runs in a helper container.
+	pid int
+	// id of the helper container running minfs, empty when minfs runs on the host.
+	container string
+	// blocks until minfs exits, returns the reason of the exit.
+	wait func() error
+	// forcibly stops minfs.
+	kill func() error
+	// time at which the process was started.
+	started time.Time
+	// set when the driver unmounts the volume, so that the exit of
+	// the process is not treated as a crash.
+	// protected by the driver lock.
+	stopping bool
+	// closed once the process has exited.
+	done chan struct{}
+	// reason of the exit of the process, set before `done` is closed.
+	exitErr error
+}
+
+// identifies the minfs process in the logs.
+func (p *minfsProcess) fields() logrus.Fields {
+	if p.container != "" {
+		return logrus.Fields{"container": p.container}
+	}
+	return logrus.Fields{"pid": p.pid}
+}
+
+// Cache consistency modes of the volumes, set with `-o consistency=<mode>`.
+const (
+	// caching is disabled so that the writes made on other hosts are seen promptly.
+	consistencyStrict = "strict"
+	// data and metadata are cached, for volumes with a single writer.
+	consistencyCached = "cached"
+)
+
+// Log levels of minfs, set per volume with `-o minfs-log-level=<level>`.
+const (
+	minfsLogLevelInfo = "info"
+	// minfs logs every FUSE operation, captured into the plugin log with the other output of minfs.
+	minfsLogLevelDebug = "debug"
+)
+
+// returns true if the log level is a valid minfs-log-level option, empty for the default.
+func isValidMinfsLogLevel(level string) bool {
+	return level == "" || level == minfsLogLevelInfo || level == minfsLogLevelDebug
+}
+
+// arguments passed to minfs for the mount of the volume.
+// ex: minfs -o direct_io,attr_timeout=0 https://play.minio.io:9000/testbucket /testbucket
+func (d *minfsDriver) minfsArgs(v *mountInfo) []string {
+	var args []string
+	opts := minfsOptions(v)
+	// the mount of a non root user is only accessible to the containers with allow_other.
+	if d.runAs != nil || rootlessMode {
+		opts = append(opts, "allow_other")
+	}
+	if len(opts) > 0 {
+		args = append(args, "-o", strings.Join(opts, ","))
+	}
+	return append(args, bucketURL(v.config), v.mountPoint)
+}
+
+// mount options passed to minfs for the volume.
+func minfsOptions(v *mountInfo) []string {
+	var opts []string
+	// minfs signs the requests for the region of the bucket.
+	if v.config.region != "" && v.config.region != defaultLocation {
+		opts = append(opts, "region="+v.config.region)
+	}
+	if v.config.signature != "" {
+		opts = append(opts, "signature="+v.config.signature)
+	}
+	if v.config.addressing == addressingVirtualHost {
+		opts = append(opts, "bucket_lookup=dns")
+	}
+	if label := selinuxLabel(v.config); label != "" {
+		opts = append(opts, fmt.Sprintf("context=%q", label))
+	}
+	if v.config.umask != "" {
+		opts = append(opts, "umask="+v.config.umask)
+	}
+	if v.cacheDir != "" {
+		opts = append(opts, "cache="+v.cacheDir)
+	}
+	if v.readOnly || v.quotaReadOnly() {
+		opts = append(opts, "ro")
+	}
+	if v.config.sseKMSKeyID != "" {
+		opts = append(opts, "sse_kms_key_id="+v.config.sseKMSKeyID)
+	}
+	if v.config.storageClass != "" {
+		opts = append(opts, "storage_class="+v.config.storageClass)
+	}
+	if v.config.minfsLogLevel == minfsLogLevelDebug {
+		opts = append(opts, "debug")
+	}
+	// minfs can't be asked to drop its cache, the changes made by other clients are seen by revalidating
+	// the attributes and entries on every access instead.
+	consistency := v.config.consistency
+	if v.config.watchChanges {
+		consistency = consistencyStrict
+	}
+	switch consistency {
+	case consistencyStrict:
+		// bypass the page cache and revalidate the attributes and entries on every access.
+		opts = append(opts, "direct_io", "attr_timeout=0", "entry_timeout=0")
+	case consistencyCached:
+		opts = append(opts, "kernel_cache", "attr_timeout=60", "entry_timeout=60")
+	}
+	return opts
+}
+
+// environment passed to minfs, the credentials are passed only to the minfs process.
+// No credentials are passed for anonymous volumes, minfs then accesses the bucket anonymously.
+func minfsEnv(v *mountInfo) []string {
+	env := proxyEnv(v.config)
+	if v.config.anonymous {
+		return env
+	}
+	return append(env,
+		"MINFS_ACCESS_KEY="+v.config.accessKey,
+		"MINFS_SECRET_KEY="+v.config.secretKey,
+	)
+}
+
+// starts minfs serving the bucket of the volume at its mountpoint and supervises it.
+// Returns once the bucket is mounted, see `waitMounted`.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) startMinfs(v *mountInfo) error {
+	p, err := d.launchMinfs(v)
+	if err != nil {
+		return err
+	}
+	d.supervise(v, p)
+	return nil
+}
+
+// starts minfs serving the volume and waits for its mount, the process isn't supervised yet, see `supervise`.
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while minfs mounts.
+func (d *minfsDriver) launchMinfs(v *mountInfo) (p *minfsProcess, err error) {
+	s := d.span().child("startMinfs")
+	s.set("mountpoint", v.mountPoint)
+	defer func() { s.finish(err) }()
+
+	if err = d.setupCache(v); err != nil {
+		return nil, fmt.Errorf("setting up the cache failed: %v", err)
+	}
+	if d.docker != nil {
+		p, err = d.startMinfsContainer(v)
+	} else {
+		p, err = d.startMinfsProcess(v)
+	}
+	if err != nil {
+		d.teardownCache(v)
+		return nil, err
+	}
+	p.started = time.Now()
+	p.watch()
+	err = d.unlocked(func(ctx context.Context) error {
+		return d.waitMounted(ctx, v, p)
+	})
+	// the time minfs took to mount the bucket.
+	observeExec("minfs", p.started)
+	if err != nil {
+		// the exit of minfs is not a crash to recover from, the mount is reported as failed.
+		select {
+		case <-p.done:
+			// the last line of output of minfs usually tells why it exited.
+			if lines := v.output.tail(); len(lines) > 0 {
+				err = fmt.Errorf("%v: %s", err, lines[len(lines)-1])
+			}
+		default:
+			p.kill()
+			lazyUnmount(v.mountPoint)
+		}
+		d.teardownCache(v)
+		return nil, err
+	}
+	return p, nil
+}
+
+// waits for the process to exit in the background, `done` is closed once it exited.
+func (p *minfsProcess) watch() {
+	p.done = make(chan struct{})
+	go func() {
+		p.exitErr = p.wait()
+		close(p.done)
+	}()
+}
+
+// makes the started minfs process the one serving the volume, it's restarted if it exits unexpectedly.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) supervise(v *mountInfo, p *minfsProcess) {
+	v.proc = p
+	go d.superviseMinfs(v, p)
+	if len(v.config.endpoints) > 1 {
+		go d.monitorEndpoint(v, p)
+	}
+}
+
+// starts minfs as a child process of the plugin.
+func (d *minfsDriver) startMinfsProcess(v *mountInfo) (*minfsProcess, error) {
+	cmd := exec.Command(d.minfsBinary, d.minfsArgs(v)...)
+	cmd.Env = append(os.Environ(), minfsEnv(v)...)
+	// trace the requests of minfs through a proxy of the plugin, see `startTraceProxy`.
+	proxy := d.startTraceProxy(v)
+	if proxy != nil {
+		cmd.Env = append(cmd.Env, proxy.env()...)
+	}
+	stopProxy := func() {
+		if proxy != nil {
+			proxy.close()
+		}
+	}
+	// drop the privileges of minfs, see `--run-as-user`.
+	if d.runAs != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: d.runAs.credential()}
+		if err := d.runAs.chown(v.mountPoint); err != nil {
+			stopProxy()
+			return nil, err
+		}
+	}
+	// the output of minfs is written to a log file followed into the plugin log, see `follow`.
+	logFile, err := d.createMinfsLog(v)
+	if err != nil {
+		stopProxy()
+		return nil, fmt.Errorf("creating the log file of minfs failed: %v", err)
+	}
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+
+	d.log().WithField("volume", v.name).Debug(cmd.Args)
+	err = cmd.Start()
+	logFile.Close()
+	if err != nil {
+		os.Remove(logFile.Name())
+		stopProxy()
+		return nil, err
+	}
+	exited := make(chan struct{})
+	followed := v.output.follow(logFile.Name(), 0, exited)
+	// bound the memory and CPU of minfs, see `limitResources`.
+	cgroup, err := d.limitResources(v, cmd.Process.Pid)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		close(exited)
+		<-followed
+		stopProxy()
+		return nil, err
+	}
+
+	return &minfsProcess{
+		pid: cmd.Process.Pid,
+		wait: func() error {
+			err := cmd.Wait()
+			close(exited)
+			<-followed
+			removeCgroup(cgroup)
+			stopProxy()
+			return err
+		},
+		kill: cmd.Process.Kill,
+	}, nil
+}
+
+// waits for the minfs process to exit and schedules a restart if the exit was unexpected.
+func (d *minfsDriver) superviseMinfs(v *mountInfo, p *minfsProcess) {
+	<-p.done
+	err := p.exitErr
+
+	v.ops.Lock()
+	defer v.ops.Unlock()
+	d.Lock()
+	defer d.Unlock()
+	// exit requested by the driver, nothing to do.
+	if p.stopping || v.proc != p {
+		return
+	}
+	v.proc = nil
+	v.lastExit = time.Now()
+	v.lastExitErr = "exited"
+	if err != nil {
+		v.lastExitErr = err.Error()
+	}
+	// the mount kept while the volume is idle is not restarted, the next Mount mounts the volume again.
+	if v.connections == 0 {
+		lazyUnmount(v.mountPoint)
+		d.teardownCache(v)
+		v.idleSince = time.Time{}
+		logrus.WithFields(p.fields()).WithField("volume", v.name).Warnf("minfs of the idle volume exited. <ERROR> %s", v.lastExitErr)
+		return
+	}
+	// reset the backoff if minfs has been serving fine for a while.
+	if v.lastExit.Sub(p.started) > minfsStableRuntime {
+		v.failures = 0
+	}
+	logrus.WithFields(p.fields()).WithFields(logrus.Fields{
+		"volume":     v.name,
+		"mountpoint": v.mountPoint,
+	}).Errorf("minfs exited unexpectedly. <ERROR> %s", v.lastExitErr)
+
+	go d.restartMinfs(v)
+}
+
+// remounts the volume after the backoff delay, retrying until it succeeds or
+// the volume is no longer in use.
+func (d *minfsDriver) restartMinfs(v *mountInfo) {
+	for {
+		v.ops.Lock()
+		d.Lock()
+		delay := restartBackoff(v.failures)
+		v.failures++
+		d.Unlock()
+		v.ops.Unlock()
+
+		time.Sleep(delay)
+
+		v.ops.Lock()
+		d.Lock()
+		// the volume has been removed, unmounted or remounted in the meantime.
+		if !d.isTracked(v) || v.connections == 0 || v.proc != nil {
+			d.Unlock()
+			v.ops.Unlock()
+			return
+		}
+		// clear the stale FUSE mount left behind by the crashed process.
+		if err := lazyUnmount(v.mountPoint); err != nil {
+			logrus.WithField("volume", v.name).Debugf("Lazy unmount of the stale mount failed. <ERROR> %v", err)
+		}
+		// switch to a reachable endpoint if the volume has several.
+		d.failover(v)
+		err := d.startMinfs(v)
+		if err == nil {
+			v.restarts++
+			driverMetrics.inc(metricMinfsRestarts, labels{"volume": v.name})
+			logrus.WithFields(v.proc.fields()).WithFields(logrus.Fields{
+				"volume":   v.name,
+				"restarts": v.restarts,
+			}).Info("minfs restarted.")
+			d.notify(eventRemounted, v, "minfs exited: "+v.lastExitErr)
+			if err := d.unlocked(func(context.Context) error { return applyOwnership(v) }); err != nil {
+				logrus.WithField("volume", v.name).Errorf("Setting the owner and mode of the mount failed. <ERROR> %v", err)
+			}
+			d.rebindShared(v)
+			d.Unlock()
+			v.ops.Unlock()
+			return
+		}
+		d.Unlock()
+		v.ops.Unlock()
+		logrus.WithField("volume", v.name).Errorf("Restarting minfs failed. <ERROR> %v", err)
+	}
+}
+
+// stops supervising the minfs process of the volume and unmounts it.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) stopMinfs(v *mountInfo) error {
+	p := v.proc
+	if p == nil {
+		// minfs is not running (crashed and waiting to be restarted),
+		// clear the stale mount if there's one.
+		lazyUnmount(v.mountPoint)
+		d.teardownCache(v)
+		return nil
+	}
+	p.stopping = true
+	if err := d.unmountVolume(v); err != nil {
+		p.stopping = false
+		return err
+	}
+	v.proc = nil
+	// minfs exits once the filesystem is unmounted.
+	d.unlocked(func(ctx context.Context) error {
+		select {
+		case <-p.done:
+		case <-time.After(minfsStopTimeout):
+			requestLog(ctx).WithFields(p.fields()).WithField("volume", v.name).Error("minfs did not exit after unmount, killing it.")
+			if err := p.kill(); err != nil {
+				requestLog(ctx).WithField("volume", v.name).Errorf("Killing minfs failed. <ERROR> %v", err)
+			}
+		}
+		return nil
+	})
+	d.teardownCache(v)
+	return nil
+}
+
+// returns the delay before the next restart attempt after `failures` consecutive failures.
+func restartBackoff(failures int) time.Duration {
+	delay := minfsRestartBackoff
+	for i := 0; i < failures && delay < minfsMaxRestartBackoff; i++ {
+		delay *= 2
+	}
+	if delay > minfsMaxRestartBackoff {
+		delay = minfsMaxRestartBackoff
+	}
+	return delay
+}
+
+// detaches the mount at `target`, used to clear FUSE mounts whose server has died.
+func lazyUnmount(target string) error {
+	name, args := unmountCommand(target, "-l")
+	return exec.Command(name, args...).Run()
+}
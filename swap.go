@@ -0,0 +1,175 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// directory under the mount root holding the mounts of minfs being staged.
+const stagingMountsDir = ".staging"
+
+// suffix of the cache directory of the minfs started by a staged remount, alternating with the
+// cache directory of the previous minfs which may still be serving the containers.
+const stagedCacheSuffix = ".next"
+
+// Staged remounts - When the credentials or the options of a mounted volume change (credential rotation,
+// quota reached, `POST /volumes/<volume>/remount`), the new minfs is first mounted under
+// `<mountroot>/.staging/`, checked by listing its root, and the mount is then moved over the mountpoint of
+// the volume once the previous mount is detached, so that the mountpoint is only missing between two
+// system calls. The previous minfs keeps serving the containers still holding its mount and exits once
+// they let it go: the containers don't see the new mount, docker bound the previous one into them, and keep
+// using the previous credentials, which have to stay valid until then. With `--staged-remount=false`, and for the volumes whose minfs runs in a helper
+// container, shares its mount or uses a managed or retained cache, the volume is unmounted and mounted
+// again: the staged minfs can't use the cache of the previous one, and a retained cache is kept.
+
+// remounts the mounted volume, with a staged remount when possible. `reason` is sent with the event.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) remountVolume(v *mountInfo, reason string) error {
+	var err error
+	if d.stagedRemount && v.proc != nil && v.proc.container == "" && v.cache == nil && !v.config.retainCache {
+		err = d.stagedRemountVolume(v)
+	} else if err = d.releaseVolume(v); err == nil {
+		err = d.mountVolume(v)
+	}
+	if err == nil {
+		d.notify(eventRemounted, v, reason)
+	}
+	return err
+}
+
+// mounts a new minfs serving the volume under the staging directory and moves it over the mountpoint.
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while the
+// new minfs mounts and the mounts are moved.
+func (d *minfsDriver) stagedRemountVolume(v *mountInfo) (err error) {
+	old, mountPoint, cacheDir := v.proc, v.mountPoint, v.cacheDir
+	staging := filepath.Join(d.volumeMountRoot(v.config), stagingMountsDir, v.name)
+	if err = createDir(staging); err != nil {
+		return err
+	}
+	defer os.Remove(staging)
+	// a mount can't be moved from under a shared mount, the staging directory is made a private mount.
+	if err = d.unlocked(func(ctx context.Context) error { return bindMount(ctx, staging, staging) }); err != nil {
+		return err
+	}
+	defer lazyUnmount(staging)
+	if err = d.unlocked(func(ctx context.Context) error { return makePrivate(ctx, staging) }); err != nil {
+		return err
+	}
+
+	// the new minfs is started for a copy of the volume mounted at the staging directory, the volume
+	// keeps being served by the previous minfs until the mount is moved.
+	staged := &mountInfo{
+		name:       v.name,
+		config:     v.config,
+		mountPoint: staging,
+		cacheDir:   stagedCacheDir(cacheDir),
+		output:     v.output,
+		readOnly:   v.readOnly,
+		quota:      v.quota,
+	}
+	if staged.proc, err = d.launchMinfs(staged); err == nil {
+		err = d.unlocked(func(context.Context) error {
+			if _, err := ioutil.ReadDir(staging); err != nil {
+				return fmt.Errorf("mount of the new minfs is not responding: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			d.stopMinfs(staged)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("staging the remount failed: %v", err)
+	}
+
+	// the previous minfs exits once the containers holding its mount let it go.
+	old.stopping = true
+	err = d.unlocked(func(ctx context.Context) error {
+		if err := lazyUnmount(mountPoint); err != nil {
+			return err
+		}
+		return moveMount(ctx, staging, mountPoint)
+	})
+	if err != nil {
+		// the volume is mounted again by the new minfs, the old one may be detached already.
+		d.stopMinfs(staged)
+		if !isFuseMounted(mountPoint) {
+			old.kill()
+			v.proc = nil
+			if err := d.startMinfs(v); err != nil {
+				return fmt.Errorf("moving the new mount failed, and mounting the volume again failed: %v", err)
+			}
+			return nil
+		}
+		old.stopping = false
+		return fmt.Errorf("moving the new mount failed: %v", err)
+	}
+	v.cacheDir = staged.cacheDir
+	d.supervise(v, staged.proc)
+	// the containers started before the remount keep the mount of the previous minfs, with its
+	// credentials and options, until they exit. It's reported in the status until then.
+	v.previous = append(v.previous, old)
+	go func() {
+		<-old.done
+		d.Lock()
+		for i, p := range v.previous {
+			if p == old {
+				v.previous = append(v.previous[:i], v.previous[i+1:]...)
+				break
+			}
+		}
+		d.Unlock()
+		if err := os.RemoveAll(cacheDir); err != nil {
+			logrus.WithField("volume", v.name).Errorf("Removing the cache directory of the previous minfs failed. <ERROR> %v", err)
+		}
+	}()
+	logrus.WithFields(old.fields()).WithField("volume", v.name).Warn("Volume remounted, the containers started before keep using the previous minfs and its credentials until they exit.")
+	return nil
+}
+
+// returns the cache directory of the minfs started by a staged remount.
+func stagedCacheDir(dir string) string {
+	if strings.HasSuffix(dir, stagedCacheSuffix) {
+		return strings.TrimSuffix(dir, stagedCacheSuffix)
+	}
+	return dir + stagedCacheSuffix
+}
+
+// makes the mount at `target` private.
+func makePrivate(ctx context.Context, target string) error {
+	if out, err := exec.CommandContext(ctx, "mount", "--make-private", target).CombinedOutput(); err != nil {
+		return fmt.Errorf("making %s private failed: %v %s", target, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// moves the mount at `source` to `target`.
+func moveMount(ctx context.Context, source, target string) error {
+	if out, err := exec.CommandContext(ctx, "mount", "--move", source, target).CombinedOutput(); err != nil {
+		return fmt.Errorf("moving the mount %s to %s failed: %v %s", source, target, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
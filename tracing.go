@@ -0,0 +1,264 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Tracing - With `--otlp-endpoint`, the Create, Mount, Unmount and Remove requests are traced as
+// OpenTelemetry spans, with child spans for the bucket checks and the start of minfs, so that slow
+// container starts can be traced back to slow mounts. The spans are exported in batches to an
+// OpenTelemetry collector with the OTLP/HTTP JSON encoding (ex: http://otel-collector:4318/v1/traces).
+
+const (
+	// service name of the exported spans.
+	tracingServiceName = "minfs-docker-volume"
+	// the spans are exported every interval, or as soon as a batch is full.
+	tracingExportInterval = 5 * time.Second
+	tracingBatchSize      = 128
+	// spans finished while the export queue is full are dropped.
+	tracingQueueSize = 4096
+)
+
+// OTLP span kinds and status codes.
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpStatusOk         = 1
+	otlpStatusError      = 2
+)
+
+// span - A traced operation, a nil span is a no-op so that tracing can be disabled.
+type span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	err      string
+}
+
+// spanExporter - Exports the finished spans to the OTLP endpoint.
+type spanExporter struct {
+	endpoint string
+	client   *http.Client
+	queue    chan *span
+}
+
+// exporter of the spans, nil if tracing is disabled.
+var tracer *spanExporter
+
+// returns `n` random bytes, hex encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// starts the root span of a request of docker, nil if tracing is disabled.
+func startSpan(name string) *span {
+	if tracer == nil {
+		return nil
+	}
+	return &span{
+		traceID: randomHex(16),
+		spanID:  randomHex(8),
+		name:    name,
+		kind:    otlpSpanKindServer,
+		start:   time.Now(),
+		attrs:   make(map[string]string),
+	}
+}
+
+// starts a child span of `s`.
+func (s *span) child(name string) *span {
+	if s == nil {
+		return nil
+	}
+	return &span{
+		traceID:  s.traceID,
+		spanID:   randomHex(8),
+		parentID: s.spanID,
+		name:     name,
+		kind:     otlpSpanKindInternal,
+		start:    time.Now(),
+		attrs:    make(map[string]string),
+	}
+}
+
+// sets an attribute of the span.
+func (s *span) set(key, value string) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = value
+}
+
+// ends the span and queues it for export, `err` is the error of the operation if it failed.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.finishWithError(err.Error())
+		return
+	}
+	s.finishWithError("")
+}
+
+// ends the span with the error message of a response to docker, empty on success.
+func (s *span) finishWithError(msg string) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = msg
+	select {
+	case tracer.queue <- s:
+	default:
+	}
+}
+
+// returns a new exporter of the spans to the OTLP/HTTP endpoint.
+func newSpanExporter(endpoint string) *spanExporter {
+	return &spanExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *span, tracingQueueSize),
+	}
+}
+
+// exports the queued spans in batches, until the plugin exits.
+func (e *spanExporter) run() {
+	ticker := time.NewTicker(tracingExportInterval)
+	defer ticker.Stop()
+	var batch []*span
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) < tracingBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := e.export(batch); err != nil {
+			logrus.Errorf("Exporting %d spans failed. <ERROR> %v", len(batch), err)
+		}
+		batch = nil
+	}
+}
+
+// OTLP JSON encoding of the spans, see https://github.com/open-telemetry/opentelemetry-proto.
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+// returns the OTLP attributes of the map.
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	var res []otlpAttribute
+	for k, v := range attrs {
+		a := otlpAttribute{Key: k}
+		a.Value.StringValue = v
+		res = append(res, a)
+	}
+	return res
+}
+
+// posts the spans to the OTLP endpoint.
+func (e *spanExporter) export(batch []*span) error {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		o := otlpSpan{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attrs),
+		}
+		o.Status.Code = otlpStatusOk
+		if s.err != "" {
+			o.Status.Code, o.Status.Message = otlpStatusError, s.err
+		}
+		spans = append(spans, o)
+	}
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{"service.name": tracingServiceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": tracingServiceName},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s responded %s", e.endpoint, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// directory under the mount root holding the mounts of the buckets of the union volumes.
+const unionMountsDir = ".union"
+
+// Union volumes - With `-o buckets=<bucket>[:ro|:rw],...`, the volume is the union of several buckets of the
+// endpoint: every bucket is mounted by a minfs process of its own under `<mountroot>/.union/<volume>/`,
+// read only with `:ro`, and the mounts are merged at the mountpoint of the volume by mergerfs
+// (`--mergerfs-binary`). The directories of the buckets are merged, a file present in several buckets is
+// read from the first of them, and the new files are created in the first writable bucket.
+// The mounts of the buckets are tracked as internal mountInfos supervised like the mount of a volume,
+// a crashed minfs is restarted at the same path where mergerfs finds it again.
+
+// unionBucket - Bucket of a union volume.
+type unionBucket struct {
+	bucket   string
+	readOnly bool
+}
+
+// parses the buckets option, `<bucket>[:ro|:rw],...`, the buckets are writable unless `:ro` is set.
+func parseUnionBuckets(option string, strict bool) ([]unionBucket, error) {
+	var buckets []unionBucket
+	seen := make(map[string]bool)
+	for _, spec := range strings.Split(option, ",") {
+		spec = strings.TrimSpace(spec)
+		b := unionBucket{bucket: spec}
+		if i := strings.LastIndex(spec, ":"); i >= 0 {
+			b.bucket = spec[:i]
+			switch spec[i+1:] {
+			case "ro":
+				b.readOnly = true
+			case "rw":
+			default:
+				return nil, fmt.Errorf("invalid bucket %q in buckets option, must be <bucket>[:ro|:rw].", spec)
+			}
+		}
+		if err := validateBucketName(b.bucket, strict); err != nil {
+			return nil, err
+		}
+		if seen[b.bucket] {
+			return nil, fmt.Errorf("bucket %s is repeated in buckets option.", b.bucket)
+		}
+		seen[b.bucket] = true
+		buckets = append(buckets, b)
+	}
+	if len(buckets) < 2 {
+		return nil, fmt.Errorf("buckets option requires at least two buckets, use the bucket option for a single bucket.")
+	}
+	return buckets, nil
+}
+
+// formats the buckets of a union volume as the buckets option.
+func formatUnionBuckets(buckets []unionBucket) string {
+	specs := make([]string, 0, len(buckets))
+	for _, b := range buckets {
+		mode := "rw"
+		if b.readOnly {
+			mode = "ro"
+		}
+		specs = append(specs, b.bucket+":"+mode)
+	}
+	return strings.Join(specs, ",")
+}
+
+// verifies the buckets of a union volume after the first one, which is verified as the bucket of the volume.
+// The missing buckets are created as per `--on-missing-bucket`, they can't be mounted empty.
+func (d *minfsDriver) ensureUnionBuckets(ctx context.Context, config serverConfig, dryRun bool) error {
+	if len(config.union) == 0 {
+		return nil
+	}
+	for _, b := range config.union[1:] {
+		c := config
+		c.bucket = b.bucket
+		if dryRun {
+			if err := d.checkBucket(ctx, c); err != nil {
+				return err
+			}
+			continue
+		}
+		exists, err := d.ensureBucket(ctx, c)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return newCodedError(errNotFound, "bucket %s of the union doesn't exist on %s", b.bucket, c.endpoint)
+		}
+	}
+	return nil
+}
+
+// mounts every bucket of the union volume with minfs and merges the mounts at the mountpoint with mergerfs.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) mountUnion(v *mountInfo) error {
+	dir := filepath.Join(d.volumeMountRoot(v.config), unionMountsDir, v.name)
+	var members []*mountInfo
+	var branches []string
+	for _, b := range v.config.union {
+		config := v.config
+		config.bucket, config.union = b.bucket, nil
+		// the owner and mode are set on the root of the union.
+		config.owner, config.mode = "", ""
+		m := &mountInfo{
+			// the buckets are mounted and restarted under the lock of the union volume.
+			ops: v.ops,
+			// volume names can't contain a slash, the name of a bucket mount is never the name of a volume.
+			name:        v.name + "/" + b.bucket,
+			config:      config,
+			mountPoint:  filepath.Join(dir, b.bucket),
+			output:      v.output,
+			readOnly:    b.readOnly,
+			connections: 1,
+		}
+		m.cacheDir = d.cachePath(m)
+		err := createDir(m.mountPoint)
+		if err == nil {
+			err = d.startMinfs(m)
+		}
+		if err != nil {
+			d.stopUnionMembers(members)
+			return fmt.Errorf("mounting bucket %s of the union failed: %v", b.bucket, err)
+		}
+		members = append(members, m)
+		mode := "RW"
+		if b.readOnly {
+			mode = "RO"
+		}
+		branches = append(branches, m.mountPoint+"="+mode)
+	}
+	if err := d.startMergerfs(v, branches); err != nil {
+		d.stopUnionMembers(members)
+		return err
+	}
+	v.union = members
+	d.log().WithFields(logrus.Fields{
+		"volume":  v.name,
+		"buckets": formatUnionBuckets(v.config.union),
+	}).Info("Union of the buckets mounted.")
+	return nil
+}
+
+// runs mergerfs merging the branches at the mountpoint of the volume, mergerfs returns once it's mounted.
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while
+// mergerfs runs.
+func (d *minfsDriver) startMergerfs(v *mountInfo, branches []string) error {
+	// new files are created in the first writable branch, the other users of the host
+	// (the users of the containers) can access the mount.
+	opts := []string{"category.create=ff", "allow_other", "fsname=minfs-" + v.name}
+	if v.readOnly {
+		opts = append(opts, "ro")
+	}
+	args := []string{"-o", strings.Join(opts, ","), strings.Join(branches, ":"), v.mountPoint}
+	d.log().WithField("volume", v.name).Debug(append([]string{d.mergerfsBinary}, args...))
+	var out []byte
+	start := time.Now()
+	err := d.unlocked(func(ctx context.Context) (err error) {
+		out, err = exec.CommandContext(ctx, d.mergerfsBinary, args...).CombinedOutput()
+		return err
+	})
+	observeExec("mergerfs", start)
+	if err != nil {
+		return fmt.Errorf("mergerfs failed: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// unmounts the union at the mountpoint of the volume and the mounts of its buckets.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) unmountUnion(v *mountInfo) error {
+	if err := d.unmountVolume(v); err != nil {
+		return err
+	}
+	d.stopUnionMembers(v.union)
+	v.union = nil
+	os.Remove(filepath.Join(d.volumeMountRoot(v.config), unionMountsDir, v.name))
+	return nil
+}
+
+// stops the minfs processes of the buckets of a union.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) stopUnionMembers(members []*mountInfo) {
+	for _, m := range members {
+		m.connections = 0
+		if err := d.stopMinfs(m); err != nil {
+			d.log().WithField("mountpoint", m.mountPoint).Errorf("Unmounting the bucket of the union failed. <ERROR> %v", err)
+			continue
+		}
+		os.Remove(m.mountPoint)
+	}
+}
+
+// returns true if the minfs processes of all the buckets of the union volume are running.
+func (v *mountInfo) unionHealthy() bool {
+	for _, m := range v.union {
+		if m.proc == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the status of the mounts of the buckets of the union volume, by bucket.
+func (v *mountInfo) unionStatus() map[string]interface{} {
+	status := make(map[string]interface{}, len(v.union))
+	for _, m := range v.union {
+		s := map[string]interface{}{"mounted": m.proc != nil, "restarts": m.restarts, "readOnly": m.readOnly}
+		if m.lastExitErr != "" {
+			s["lastExitError"] = m.lastExitErr
+		}
+		status[m.config.bucket] = s
+	}
+	return status
+}
@@ -0,0 +1,97 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// default of `--unmount-timeout`.
+const defaultUnmountTimeout = 10 * time.Second
+
+// Unmount escalation - The mountpoint is unmounted cleanly, if the unmount fails or doesn't complete
+// within `--unmount-timeout` (ex: files held open by a stuck process) the mount is detached with a lazy
+// unmount and, if that fails too, forcibly unmounted. The steps of the last unmount of the volume are
+// reported in its status for post-mortems.
+
+// runs `umount <flags> <target>`, the command is killed if it doesn't complete within `timeout`
+// or if the context is cancelled.
+func runUnmount(ctx context.Context, target string, timeout time.Duration, flags ...string) error {
+	defer observeExec("umount", time.Now())
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	name, args := unmountCommand(target, flags...)
+	cmd := exec.CommandContext(cmdCtx, name, args...)
+	logrus.Debug(cmd.Args)
+	out, err := cmd.CombinedOutput()
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("cancelled: %v", ctx.Err())
+	case cmdCtx.Err() != nil:
+		return fmt.Errorf("timed out after %s", timeout)
+	case err != nil:
+		return fmt.Errorf("%v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// unmounts the mountpoint of the volume, escalating to a lazy and a forced unmount.
+// Has to be called with the driver lock held, and the lock of the volume. The lock is released while
+// the mountpoint is unmounted.
+func (d *minfsDriver) unmountVolume(v *mountInfo) error {
+	steps := []struct {
+		name  string
+		flags []string
+	}{
+		{"umount", nil},
+		{"umount -l", []string{"-l"}},
+		{"umount -f", []string{"-f"}},
+	}
+	var done []string
+	started := time.Now()
+	err := d.unlocked(func(ctx context.Context) (err error) {
+		for _, step := range steps {
+			err = runUnmount(ctx, v.mountPoint, d.unmountTimeout, step.flags...)
+			if err == nil {
+				done = append(done, step.name+": ok")
+				break
+			}
+			done = append(done, fmt.Sprintf("%s: %v", step.name, err))
+			requestLog(ctx).WithFields(logrus.Fields{
+				"volume":     v.name,
+				"mountpoint": v.mountPoint,
+				"step":       step.name,
+			}).Warnf("Unmount failed, escalating. <ERROR> %v", err)
+			// the request was cancelled, the next steps would be killed as well.
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return err
+	})
+	v.unmountSteps, v.lastUnmount = done, started
+	if err != nil {
+		return fmt.Errorf("unmounting %s failed: %s", v.mountPoint, strings.Join(v.unmountSteps, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Upload queue - minfs buffers the writes in files of its cache and uploads them once they're closed,
+// holding the cache files open until the upload completes. The files minfs holds open for writing are
+// the pending uploads of the volume: they're reported in the status and metrics of the volume, and with
+// `-o flush-on-unmount=true` the unmount waits for them to be uploaded.
+// The queue is read from /proc, it's not reported when minfs runs in a helper container. It's a heuristic:
+// only the regular files under the cache directory of the volume are counted, the metadata database of
+// minfs (`*.db`), which it keeps open for writing, and the files minfs may open elsewhere are not uploads.
+
+// extension of the metadata database minfs keeps in its cache directory.
+const minfsMetadataExt = ".db"
+
+// interval at which the upload queues of the mounted volumes are sampled for the metrics.
+const uploadQueueInterval = 10 * time.Second
+
+// returns the number of regular files under the cache directory the process holds open for writing,
+// but its metadata database.
+func pendingUploads(pid int, cacheDir string) (int, error) {
+	fdDir := fmt.Sprintf("/proc/%d/fd", pid)
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err != nil || !strings.HasPrefix(target, cacheDir+string(os.PathSeparator)) {
+			// closed in the meantime, not a file (ex: socket:[1234]) or not a cache file.
+			continue
+		}
+		if filepath.Ext(target) == minfsMetadataExt {
+			continue
+		}
+		if info, err := os.Stat(target); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		flags, err := fdFlags(pid, fd.Name())
+		if err != nil {
+			continue
+		}
+		if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// returns the open flags of the file descriptor of the process, from its fdinfo.
+func fdFlags(pid int, fd string) (int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/fdinfo/%s", pid, fd))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 && fields[0] == "flags:" {
+			flags, err := strconv.ParseInt(fields[1], 8, 64)
+			return int(flags), err
+		}
+	}
+	return 0, fmt.Errorf("no flags in fdinfo of %s", fd)
+}
+
+// returns the pending uploads of the volume, -1 if they're unknown (not mounted, or minfs runs in a container).
+// Has to be called with the driver lock held.
+func (v *mountInfo) pendingUploads() int {
+	p, cacheDir := v.proc, v.cacheDir
+	if v.shared != nil {
+		p, cacheDir = v.shared.proc, v.shared.cacheDir
+	}
+	if p == nil || p.pid == 0 || cacheDir == "" {
+		return -1
+	}
+	n, err := pendingUploads(p.pid, filepath.Clean(cacheDir))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// samples the upload queues of the mounted volumes into the metrics, until the plugin exits.
+func (d *minfsDriver) sampleUploadQueues() {
+	for range time.Tick(uploadQueueInterval) {
+		d.RLock()
+		for name, v := range d.mounts {
+			if n := v.pendingUploads(); n >= 0 {
+				driverMetrics.set(metricPendingUploads, labels{"volume": name}, float64(n))
+			}
+		}
+		d.RUnlock()
+	}
+}
+
+// waits until minfs uploaded the buffered writes of the volume, for at most `--flush-timeout`.
+// The driver lock is released while waiting so that the other volumes can be served.
+// Has to be called with the driver lock held, and the lock of the volume.
+func (d *minfsDriver) flushUploads(v *mountInfo) error {
+	deadline := time.Now().Add(d.flushTimeout)
+	for i := 0; ; i++ {
+		n := v.pendingUploads()
+		if n <= 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return newCodedError(errMountBusy, "%d uploads of volume %s are still pending after %v, the volume is kept mounted", n, v.name, d.flushTimeout)
+		}
+		if i == 0 {
+			d.log().WithField("volume", v.name).Infof("Waiting for %d pending uploads before unmounting.", n)
+		}
+		d.unlocked(func(context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		})
+	}
+}
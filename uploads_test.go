@@ -0,0 +1,47 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Only the files of the cache open for writing are pending uploads, not the metadata database.
+func TestPendingUploads(t *testing.T) {
+	cacheDir, other := t.TempDir(), t.TempDir()
+	open := func(path string, flag int) {
+		f, err := os.OpenFile(path, flag|os.O_CREATE, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+	}
+	open(filepath.Join(cacheDir, "upload"), os.O_WRONLY)
+	open(filepath.Join(cacheDir, "cached"), os.O_RDONLY)
+	open(filepath.Join(cacheDir, "meta"+minfsMetadataExt), os.O_RDWR)
+	open(filepath.Join(other, "log"), os.O_WRONLY)
+
+	n, err := pendingUploads(os.Getpid(), cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 pending upload, got %d", n)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Usage reports - With `--usage-report-interval`, the plugin logs a usage summary of every volume at the
+// given interval: the bytes stored in its bucket, the number of objects, and the bytes of the objects
+// written since the previous report, so that chargeback can be computed without instrumenting the
+// containers. With `--usage-webhook`, the report is also POSTed as JSON to the given URL.
+// The bytes read from the buckets are not reported, minfs doesn't expose them.
+
+// time given to the usage webhook to accept a report.
+const usageWebhookTimeout = 10 * time.Second
+
+// bucketUsageStats - Usage of a bucket, see `bucketStats`.
+type bucketUsageStats struct {
+	// total size and number of the objects of the bucket.
+	bytes   int64
+	objects int64
+	// total size of the objects written since the given time.
+	bytesWritten int64
+}
+
+// usageReport - Usage summary of the volumes, logged and sent to `--usage-webhook`.
+type usageReport struct {
+	Time time.Time `json:"time"`
+	// start of the period of the report, the time of the previous report.
+	Since   time.Time     `json:"since"`
+	Volumes []volumeUsage `json:"volumes"`
+}
+
+// volumeUsage - Usage of a volume in the report.
+type volumeUsage struct {
+	Name         string `json:"name"`
+	Endpoint     string `json:"endpoint"`
+	Bucket       string `json:"bucket"`
+	BytesStored  int64  `json:"bytesStored"`
+	Objects      int64  `json:"objects"`
+	BytesWritten int64  `json:"bytesWritten"`
+	// set when the usage of the bucket couldn't be computed.
+	Error string `json:"error,omitempty"`
+}
+
+// returns the usage of the bucket of the volume, the objects modified after `since` are counted as written.
+func bucketStats(config serverConfig, since time.Time) (bucketUsageStats, error) {
+	var stats bucketUsageStats
+	minioClient, err := newMinioClient(config)
+	if err != nil {
+		return stats, err
+	}
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for object := range minioClient.ListObjectsV2(config.bucket, "", true, doneCh) {
+		if object.Err != nil {
+			return stats, object.Err
+		}
+		stats.bytes += object.Size
+		stats.objects++
+		if object.LastModified.After(since) {
+			stats.bytesWritten += object.Size
+		}
+	}
+	return stats, nil
+}
+
+// reports the usage of the volumes every `interval`, until the plugin exits.
+func (d *minfsDriver) reportUsage(interval time.Duration, webhook string) {
+	since := time.Now()
+	client := &http.Client{Timeout: usageWebhookTimeout}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		report := d.usageReport(since, now)
+		since = now
+		for _, u := range report.Volumes {
+			fields := logrus.Fields{
+				"volume":       u.Name,
+				"bucket":       u.Bucket,
+				"bytesStored":  u.BytesStored,
+				"objects":      u.Objects,
+				"bytesWritten": u.BytesWritten,
+			}
+			if u.Error != "" {
+				logrus.WithFields(fields).Warnf("Computing the usage of the volume failed. <ERROR> %s", u.Error)
+				continue
+			}
+			logrus.WithFields(fields).Info("Volume usage.")
+		}
+		if webhook != "" {
+			if err := postUsageReport(client, webhook, report); err != nil {
+				logrus.Errorf("Sending the usage report failed. <ERROR> %v", err)
+			}
+		}
+	}
+}
+
+// returns the usage of the volumes for the period between `since` and `now`.
+func (d *minfsDriver) usageReport(since, now time.Time) usageReport {
+	// list the buckets without holding the lock, the volumes may be used in the meantime.
+	d.RLock()
+	configs := make(map[string]serverConfig, len(d.mounts))
+	for name, v := range d.mounts {
+		configs[name] = v.config
+	}
+	d.RUnlock()
+
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	report := usageReport{Time: now.UTC(), Since: since.UTC(), Volumes: []volumeUsage{}}
+	for _, name := range names {
+		config := configs[name]
+		u := volumeUsage{Name: name, Endpoint: config.endpoint, Bucket: config.bucket}
+		stats, err := bucketStats(config, since)
+		if err != nil {
+			u.Error = err.Error()
+		}
+		u.BytesStored, u.Objects, u.BytesWritten = stats.bytes, stats.objects, stats.bytesWritten
+		report.Volumes = append(report.Volumes, u)
+	}
+	return report
+}
+
+// POSTs the usage report to the webhook.
+func postUsageReport(client *http.Client, webhook string, report usageReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("usage webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -18,8 +18,11 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/go-plugins-helpers/volume"
@@ -31,9 +34,36 @@ func getHost(endpoint string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// the Minio client only parses IPv6 hosts with a port (ex: [2001:db8::1]:443).
+	if strings.HasPrefix(u.Host, "[") && u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		return net.JoinHostPort(u.Hostname(), port), nil
+	}
 	return u.Host, nil
 }
 
+// validates the endpoint of a volume, a http(s) URL whose host is a name, an IPv4 address or an IPv6
+// address in brackets (ex: https://[2001:db8::1]:9000).
+func validateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("invalid endpoint %q, must be http(s)://<host>[:<port>]", endpoint)
+	}
+	if strings.Contains(u.Hostname(), ":") && !strings.HasPrefix(u.Host, "[") {
+		return fmt.Errorf("invalid endpoint %q, IPv6 addresses must be in brackets (ex: https://[2001:db8::1]:9000)", endpoint)
+	}
+	return nil
+}
+
+// returns true if the host of the endpoint is an IP address rather than a name.
+func isIPEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	return err == nil && net.ParseIP(u.Hostname()) != nil
+}
+
 // determines if the url has HTTPS scheme.
 func isSSL(url string) (bool, error) {
 	scheme, err := getScheme(url)
@@ -73,7 +103,7 @@ func matchServerConfig(config serverConfig, r volume.Request) error {
 	// Compare the bucket name.
 	if r.Options["bucket"] == config.bucket {
 		return fmt.Errorf("Volume \"%s\" already exists and is pointing to Minio server \"%s\", and bucket \"%s\",Cannot create duplicate volume.",
-			r.Name, config.endpoint)
+			r.Name, config.endpoint, config.bucket)
 	}
 	// compare the access keys.
 	if r.Options["access-key"] == "" {
@@ -89,9 +119,16 @@ func matchServerConfig(config serverConfig, r volume.Request) error {
 }
 
 // Error repsonse to be sent to docker on failure of any operation.
-func errorResponse(err string) volume.Response {
-	logrus.Error(err)
-	return volume.Response{Err: err}
+// The message is prefixed with the error code (ex: "[not-found] volume foo not found").
+func errorResponse(code errorCode, err string) volume.Response {
+	msg := fmt.Sprintf("[%s] %s", code, err)
+	logrus.Error(msg)
+	return volume.Response{Err: msg}
+}
+
+// Error response for `err`, its error code is found with `errorCodeOf`.
+func errorResponseOf(err error) volume.Response {
+	return errorResponse(errorCodeOf(err), err.Error())
 }
 
 // create directory for the given path.
@@ -112,3 +149,40 @@ func createDir(path string) error {
 	}
 	return nil
 }
+
+// URL for the bucket (ex: https://play.minio.io:9000/mybucket).
+func bucketURL(config serverConfig) string {
+	if strings.HasSuffix(config.endpoint, "/") {
+		return config.endpoint + config.bucket
+	}
+	return config.endpoint + "/" + config.bucket
+}
+
+// URL of the object of the bucket, addressed as per the `addressing` option of the volume.
+// The URL of the bucket itself is returned if `object` is empty.
+func objectURL(config serverConfig, object string) (*url.URL, error) {
+	u, err := url.Parse(config.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if config.addressing == addressingVirtualHost {
+		u.Host = config.bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + object
+	} else {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + config.bucket + "/" + object
+	}
+	return u, nil
+}
+
+// parses the boolean option `name` of the create request, false if the option isn't set.
+func parseBoolOption(options map[string]string, name string) (bool, error) {
+	value, ok := options[name]
+	if !ok || value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for %s option, must be true or false.", value, name)
+	}
+	return b, nil
+}
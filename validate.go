@@ -0,0 +1,182 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// Volume configs - The config of a volume is validated by `validateConfig` once it's parsed from the options of
+// the create request, and when it's restored from a state bundle, so that a hand edited or older bundle can't
+// restore a volume Create would reject, which would only fail at Mount.
+
+// validates the config of the volume `name`, `cloned` is set if its bucket is cloned from another volume.
+// The errors are coded, errAuthFailed for the endpoints which are not allowed and errBadOption otherwise.
+func (d *minfsDriver) validateConfig(name string, config serverConfig, cloned bool) error {
+	endpoints := config.endpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{config.endpoint}
+	}
+	if len(config.hostOverrides) > 0 {
+		// minfs on the host resolves the endpoint with the resolver of the host.
+		if d.docker == nil {
+			return newCodedError(errBadOption, "host-override requires minfs to run in helper containers (--minfs-image).")
+		}
+		if err := validateHostOverrides(config.hostOverrides); err != nil {
+			return newCodedError(errBadOption, "%v", err)
+		}
+	}
+	// verify that the endpoints are in the allowed endpoints (`--allowed-endpoints`).
+	for _, endpoint := range endpoints {
+		if err := validateEndpoint(endpoint); err != nil {
+			return newCodedError(errBadOption, "%v", err)
+		}
+		if err := d.allowedEndpoints.verify(endpoint, config.hostOverrides); err != nil {
+			return newCodedError(errAuthFailed, "%v", err)
+		}
+	}
+	if err := validateBucketName(config.bucket, !d.legacyBucketNames); err != nil {
+		return newCodedError(errBadOption, "%v", err)
+	}
+	if len(config.union) > 0 && config.union[0].bucket != config.bucket {
+		return newCodedError(errBadOption, "bucket %s is not the first bucket of the union %s.", config.bucket, formatUnionBuckets(config.union))
+	}
+	for _, name := range []string{config.accessKeyFile, config.secretKeyFile} {
+		if name == "" {
+			continue
+		}
+		if err := validateCredentialFileName(name); err != nil {
+			return newCodedError(errBadOption, "%v", err)
+		}
+	}
+	if !isValidSignature(config.signature) {
+		return newCodedError(errBadOption, "invalid value %q for signature option, must be v2 or v4.", config.signature)
+	}
+	if config.addressing != "" && config.addressing != addressingPath && config.addressing != addressingVirtualHost {
+		return newCodedError(errBadOption, "invalid value %q for addressing option, must be path or virtual-host.", config.addressing)
+	}
+	// the bucket can't be prepended to an IP address.
+	if config.addressing == addressingVirtualHost {
+		for _, endpoint := range endpoints {
+			if isIPEndpoint(endpoint) {
+				return newCodedError(errBadOption, "virtual-host addressing requires a host name, endpoint %s is an IP address.", endpoint)
+			}
+		}
+	}
+	if config.consistency != "" && config.consistency != consistencyStrict && config.consistency != consistencyCached {
+		return newCodedError(errBadOption, "invalid value %q for consistency option, must be strict or cached.", config.consistency)
+	}
+	if config.watchChanges && config.consistency == consistencyCached {
+		return newCodedError(errBadOption, "watch-changes cannot be combined with consistency=cached, the changes are seen with the strict consistency.")
+	}
+	if !isValidPropagation(config.propagation) {
+		return newCodedError(errBadOption, "invalid value %q for propagation option, must be private, rshared or rslave.", config.propagation)
+	}
+	if !isValidSELinuxLabel(config.selinuxLabel) {
+		return newCodedError(errBadOption, "invalid value %q for selinux-label option, must be auto or a SELinux context.", config.selinuxLabel)
+	}
+	if err := validateOwnership(config); err != nil {
+		return newCodedError(errBadOption, "%v", err)
+	}
+	if config.umask != "" && !isValidUmask(config.umask) {
+		return newCodedError(errBadOption, "invalid value %q for umask option, must be an octal umask (ex: 0022).", config.umask)
+	}
+	if config.cacheType != "" && config.cacheType != cacheTmpfs {
+		return newCodedError(errBadOption, "invalid cache type %q, must be tmpfs.", config.cacheType)
+	}
+	if config.cacheSize != "" {
+		if _, err := parseMemoryLimit(config.cacheSize); err != nil {
+			return newCodedError(errBadOption, "invalid cache size %q, must be a size in bytes with an optional K, M or G suffix (ex: 2G).", config.cacheSize)
+		}
+	}
+	if config.encryptCache && config.cacheType != "" {
+		return newCodedError(errBadOption, "encrypt-cache and cache options cannot be combined, a tmpfs cache never hits the disk.")
+	}
+	if rootlessMode && (config.encryptCache || config.cacheType != "") {
+		return newCodedError(errBadOption, "encrypt-cache and cache options require the plugin to run as root.")
+	}
+	snapshot := !config.snapshot.IsZero()
+	if config.forcePurge && !config.purgeOnRemove {
+		return newCodedError(errBadOption, "force-purge requires purge-on-remove.")
+	}
+	if config.purgeOnRemove && (snapshot || config.anonymous) {
+		return newCodedError(errBadOption, "snapshot and anonymous volumes cannot purge their bucket on remove.")
+	}
+	if config.sseKMSKeyID != "" {
+		if err := validateSSEKMSKeyID(config.sseKMSKeyID); err != nil {
+			return newCodedError(errBadOption, "%v", err)
+		}
+	}
+	if config.storageClass != "" {
+		if err := validateStorageClass(config.storageClass); err != nil {
+			return newCodedError(errBadOption, "%v", err)
+		}
+	}
+	if len(config.expiry) > 0 && (snapshot || config.anonymous) {
+		return newCodedError(errBadOption, "expiry-days and expiry-rules cannot be combined with snapshot or anonymous, the plugin doesn't create their bucket.")
+	}
+	if _, ok := bucketPolicies[config.bucketPolicy]; config.bucketPolicy != "" && !ok {
+		return newCodedError(errBadOption, "invalid value %q for bucket-policy option.", config.bucketPolicy)
+	}
+	if config.bucketPolicy != "" && (snapshot || config.anonymous) {
+		return newCodedError(errBadOption, "bucket-policy cannot be combined with snapshot or anonymous, the plugin doesn't create their bucket.")
+	}
+	if !isValidMinfsLogLevel(config.minfsLogLevel) {
+		return newCodedError(errBadOption, "invalid value %q for minfs-log-level option, must be info or debug.", config.minfsLogLevel)
+	}
+	if config.quota < 0 || (config.quota > 0 && config.quotaAction != quotaActionReadOnly && config.quotaAction != quotaActionWarn) {
+		return newCodedError(errBadOption, "invalid quota %d with action %q.", config.quota, config.quotaAction)
+	}
+	if len(config.union) > 0 && (snapshot || cloned || config.purgeOnRemove || config.quota > 0) {
+		return newCodedError(errBadOption, "buckets option cannot be combined with snapshot, clone-from, purge-on-remove or quota.")
+	}
+	if config.proxy != "" {
+		if err := validateProxy(config.proxy); err != nil {
+			return newCodedError(errBadOption, "%v", err)
+		}
+	}
+	if config.noProxy != "" {
+		if config.proxy == "" {
+			return newCodedError(errBadOption, "no-proxy option requires the proxy option.")
+		}
+		if err := validateNoProxy(config.noProxy); err != nil {
+			return newCodedError(errBadOption, "%v", err)
+		}
+	}
+	for _, prefix := range config.prefetch {
+		if strings.HasPrefix(path.Clean(prefix), "..") {
+			return newCodedError(errBadOption, "invalid prefetch prefix %q, the prefixes must be within the bucket.", prefix)
+		}
+	}
+	if config.isolateByMountID && snapshot {
+		return newCodedError(errBadOption, "isolate-by-mount-id cannot be combined with snapshot, snapshot volumes are read only.")
+	}
+	// snapshots are read from the versions of the existing bucket.
+	if snapshot && (config.anonymous || cloned || config.objectLocking) {
+		return newCodedError(errBadOption, "snapshot option cannot be combined with anonymous, clone-from or object-locking.")
+	}
+	if err := validateResourceLimits(config.memoryLimit, config.cpuQuota); err != nil {
+		return newCodedError(errBadOption, "%v", err)
+	}
+	if config.mountRoot != "" {
+		if err := d.allowedMountRoots.verify(config.mountRoot, name); err != nil {
+			return newCodedError(errBadOption, "%v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultProvider - Fetches the credentials of the volumes from HashiCorp Vault (`-o vault-path=<path>`).
+// The secret at the path holds the `access_key` and `secret_key` fields, KV version 1 and 2 secrets
+// are supported (ex: `secret/data/minio/team-a` for KV version 2).
+// The plugin authenticates with a token (`--vault-token-file`) or with AppRole
+// (`--vault-role-id` and `--vault-secret-id-file`), the AppRole token is renewed by logging in again
+// once it expires.
+type vaultProvider struct {
+	address string
+	client  *http.Client
+	// static token, empty if AppRole is used.
+	token string
+	// AppRole credentials.
+	roleID   string
+	secretID string
+
+	// token obtained with AppRole and its expiry.
+	sync.Mutex
+	loginToken  string
+	loginExpire time.Time
+}
+
+// returns a new Vault provider, authenticating with the token read from `tokenFile` or with AppRole.
+func newVaultProvider(address, tokenFile, roleID, secretIDFile string) (*vaultProvider, error) {
+	p := &vaultProvider{
+		address: strings.TrimSuffix(address, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		roleID:  roleID,
+	}
+	switch {
+	case tokenFile != "":
+		token, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		p.token = strings.TrimSpace(string(token))
+	case roleID != "" && secretIDFile != "":
+		secretID, err := ioutil.ReadFile(secretIDFile)
+		if err != nil {
+			return nil, err
+		}
+		p.secretID = strings.TrimSpace(string(secretID))
+	default:
+		return nil, fmt.Errorf("--vault-token-file, or --vault-role-id and --vault-secret-id-file are required")
+	}
+	return p, nil
+}
+
+// vaultResponse - response of the Vault API.
+type vaultResponse struct {
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// sends a request to the Vault API.
+func (p *vaultProvider) do(method, path, token string, body interface{}) (*vaultResponse, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, p.address+"/v1/"+strings.TrimPrefix(path, "/"), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res vaultResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("invalid response from vault: %s", resp.Status)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("vault responded %s: %s", resp.Status, strings.Join(res.Errors, ", "))
+	}
+	return &res, nil
+}
+
+// returns the token of the plugin, logging in with AppRole if the token expired.
+func (p *vaultProvider) authToken() (string, error) {
+	if p.token != "" {
+		return p.token, nil
+	}
+	p.Lock()
+	defer p.Unlock()
+
+	if p.loginToken != "" && time.Now().Before(p.loginExpire) {
+		return p.loginToken, nil
+	}
+	res, err := p.do("POST", "auth/approle/login", "", map[string]string{"role_id": p.roleID, "secret_id": p.secretID})
+	if err != nil {
+		return "", err
+	}
+	if res.Auth == nil || res.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault AppRole login returned no token")
+	}
+	p.loginToken = res.Auth.ClientToken
+	// log in again a bit before the token expires.
+	p.loginExpire = time.Now().Add(time.Duration(res.Auth.LeaseDuration) * time.Second * 9 / 10)
+	return p.loginToken, nil
+}
+
+// fetch - reads the credentials from the secret at `path`.
+func (p *vaultProvider) fetch(path string) (string, string, time.Duration, error) {
+	token, err := p.authToken()
+	if err != nil {
+		return "", "", 0, err
+	}
+	res, err := p.do("GET", path, token, nil)
+	if err != nil {
+		return "", "", 0, err
+	}
+	data := res.Data
+	// the fields of KV version 2 secrets are nested under `data`.
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	accessKey, _ := data["access_key"].(string)
+	secretKey, _ := data["secret_key"].(string)
+	return accessKey, secretKey, time.Duration(res.LeaseDuration) * time.Second, nil
+}
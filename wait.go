@@ -0,0 +1,72 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Waiting for the endpoint - With `-o wait-for-endpoint=<duration>`, Create retries the checks of the endpoint
+// and bucket of the volume until they succeed or the duration elapsed, so that the volumes of a compose stack
+// can be created while its MinIO service is starting. The driver lock is released between the attempts, the
+// other requests are served in the meantime. The errors of the options are not retried, and the wait ends
+// with the request, see `--request-timeout`.
+
+// interval between the attempts of Create to check the endpoint.
+const endpointRetryInterval = 2 * time.Second
+
+// parses the `wait-for-endpoint` option, 0 if not set.
+func parseWaitForEndpoint(options map[string]string) (time.Duration, error) {
+	value := options["wait-for-endpoint"]
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("invalid value %q for wait-for-endpoint option, must be a duration (ex: 60s).", value)
+	}
+	return wait, nil
+}
+
+// runs the check of the endpoint of the volume, retrying it until it succeeds or `wait` elapsed.
+// Has to be called with the driver lock held, the lock is released while the check runs and between the attempts.
+func (d *minfsDriver) waitForEndpoint(name string, wait time.Duration, check func(ctx context.Context) error) error {
+	deadline := time.Now().Add(wait)
+	for attempt := 1; ; attempt++ {
+		err := d.unlocked(check)
+		if err == nil || errorCodeOf(err) == errBadOption || time.Now().Add(endpointRetryInterval).After(deadline) {
+			return err
+		}
+		d.log().WithFields(logrus.Fields{
+			"volume":  name,
+			"attempt": attempt,
+		}).Infof("Endpoint of the volume is not ready, retrying. <ERROR> %v", err)
+		if d.unlocked(func(ctx context.Context) error {
+			select {
+			case <-time.After(endpointRetryInterval):
+			case <-ctx.Done():
+			}
+			return ctx.Err()
+		}) != nil {
+			return err
+		}
+	}
+}
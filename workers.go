@@ -0,0 +1,140 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// Volume workers - The Mount, Unmount and Remove requests of a volume are processed one at a time, in
+// the order they're received, by a worker goroutine of the volume. The handlers only queue the request
+// and wait for its response without holding the driver lock, so a request waiting on a stuck volume
+// (ex: queued for a mount slot, or flushing its uploads) doesn't hold up the requests of the other
+// volumes. The worker exits once its queue is empty and is started again by the next request.
+
+// Locking - The driver lock protects the table of the volumes and their state, it's held only to read and
+// update them. Every operation of a volume (the requests of docker, the restarts of minfs, the remounts,
+// the admin actions...) holds the lock of the volume for its whole duration and releases the driver lock
+// while it runs commands (minfs, mount, umount...) or requests the servers, see `unlocked`, so that a slow
+// server or a hung mount only holds up the operations of its own volume. The lock of a volume is taken
+// before the lock of the shared mount it's bound to, itself taken before the driver lock. The fields of a
+// volume are updated with both its lock and the driver lock held, but its health, containers and active
+// endpoint, updated in the background with the driver lock only.
+
+// volumeOp - A request queued to the worker of a volume.
+type volumeOp struct {
+	run  func() volume.Response
+	done chan volume.Response
+}
+
+// volumeWorkers - The workers of the volumes with queued requests, keyed by volume name.
+type volumeWorkers struct {
+	sync.Mutex
+	queues map[string][]volumeOp
+}
+
+// returns a new, empty set of workers.
+func newVolumeWorkers() *volumeWorkers {
+	return &volumeWorkers{queues: make(map[string][]volumeOp)}
+}
+
+// queues the request to the worker of the volume, starting it if it's not running,
+// and returns the response once the request is processed.
+// Must not be called with the driver lock held.
+func (d *minfsDriver) dispatch(name string, run func() volume.Response) volume.Response {
+	op := volumeOp{run: run, done: make(chan volume.Response, 1)}
+	w := d.workers
+	w.Lock()
+	queue, running := w.queues[name]
+	w.queues[name] = append(queue, op)
+	w.Unlock()
+	if !running {
+		go w.work(name)
+	}
+	return <-op.done
+}
+
+// processes the queued requests of the volume until its queue is empty.
+func (w *volumeWorkers) work(name string) {
+	for {
+		w.Lock()
+		queue := w.queues[name]
+		if len(queue) == 0 {
+			delete(w.queues, name)
+			w.Unlock()
+			return
+		}
+		op := queue[0]
+		w.queues[name] = queue[1:]
+		w.Unlock()
+
+		op.done <- op.run()
+	}
+}
+
+// returns the volume with its lock held, nil if there's no such volume.
+// Must not be called with the driver lock held.
+func (d *minfsDriver) lockVolume(name string) *mountInfo {
+	for {
+		d.RLock()
+		v, exists := d.mounts[name]
+		d.RUnlock()
+		if !exists {
+			return nil
+		}
+		v.ops.Lock()
+		d.RLock()
+		current := d.mounts[name]
+		d.RUnlock()
+		if current == v {
+			return v
+		}
+		// the volume was removed, or replaced, while waiting for its lock.
+		v.ops.Unlock()
+	}
+}
+
+// runs fn with the driver lock released, fn gets the context of the request. The request is
+// detached from the driver while the lock is released. fn can read the fields of the volumes
+// it holds the lock of, not the rest of the driver state.
+// Has to be called with the driver lock held, and the lock of the volume fn works on.
+func (d *minfsDriver) unlocked(fn func(ctx context.Context) error) error {
+	ctx := d.context()
+	req := d.req
+	d.req = nil
+	d.Unlock()
+	defer func() {
+		d.Lock()
+		d.req = req
+	}()
+	return fn(ctx)
+}
+
+// returns the number of requests queued to the workers, by volume.
+func (w *volumeWorkers) pending() map[string]int {
+	w.Lock()
+	defer w.Unlock()
+
+	pending := make(map[string]int, len(w.queues))
+	for name, queue := range w.queues {
+		pending[name] = len(queue)
+	}
+	return pending
+}
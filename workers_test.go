@@ -0,0 +1,205 @@
+/*
+* Minio Cloud Storage, (C) 2017 Minio, Inc.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// time given to a request which isn't expected to block to complete.
+const testRequestTimeout = 5 * time.Second
+
+// returns a driver whose volumes are mounted under a temporary directory, and whose docker socket
+// doesn't exist.
+func newTestDriver(t *testing.T) *minfsDriver {
+	root := t.TempDir()
+	return newMinfsDriver(pluginConfig{
+		mountRoot:       root,
+		minfsBinary:     "minfs",
+		onMissingBucket: missingBucketFail,
+		dockerSocket:    filepath.Join(root, "docker.sock"),
+		mountTimeout:    defaultMountTimeout,
+		unmountTimeout:  defaultUnmountTimeout,
+	})
+}
+
+// registers a volume of the bucket `bucket` of the endpoint, used by `connections` containers.
+func addTestVolume(d *minfsDriver, name, endpoint, bucket string, connections int) *mountInfo {
+	v := &mountInfo{
+		ops:  new(sync.Mutex),
+		name: name,
+		config: serverConfig{
+			endpoint:  endpoint,
+			bucket:    bucket,
+			accessKey: "access",
+			secretKey: "secret",
+			region:    defaultLocation,
+		},
+		mountPoint:  filepath.Join(d.mountRoot, name),
+		output:      newOutputTail(name, d.outputLines),
+		connections: connections,
+		createdAt:   time.Now(),
+	}
+	d.Lock()
+	d.mounts[name] = v
+	d.Unlock()
+	return v
+}
+
+// returns a server whose requests block until `release` is closed, and which then doesn't know any bucket.
+// `requested` is closed on the first request.
+func newBlockingServer() (srv *httptest.Server, requested, release chan struct{}) {
+	requested, release = make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(requested) })
+		<-release
+		http.NotFound(w, r)
+	}))
+	return srv, requested, release
+}
+
+// runs the request in the background, returns the channel its response is sent to.
+func goRequest(run func() volume.Response) chan volume.Response {
+	done := make(chan volume.Response, 1)
+	go func() { done <- run() }()
+	return done
+}
+
+// waits for the response of a request which isn't expected to block.
+func awaitResponse(t *testing.T, what string, done chan volume.Response) volume.Response {
+	t.Helper()
+	select {
+	case res := <-done:
+		return res
+	case <-time.After(testRequestTimeout):
+		t.Fatalf("%s didn't complete within %s, blocked behind the request of another volume.", what, testRequestTimeout)
+		return volume.Response{}
+	}
+}
+
+// The requests of a volume complete while the Mount of another volume waits for its server.
+func TestDispatchDoesNotBlockOtherVolumes(t *testing.T) {
+	d := newTestDriver(t)
+	srv, requested, release := newBlockingServer()
+	defer srv.Close()
+	defer close(release)
+	addTestVolume(d, "stuck", srv.URL, "stuck-bucket", 0)
+	b := addTestVolume(d, "other", "http://127.0.0.1:1", "other-bucket", 2)
+
+	mounted := goRequest(func() volume.Response { return d.Mount(volume.MountRequest{Name: "stuck", ID: "m1"}) })
+	select {
+	case <-requested:
+	case <-time.After(testRequestTimeout):
+		t.Fatal("Mount of the volume didn't request the server.")
+	}
+
+	res := awaitResponse(t, "Unmount", goRequest(func() volume.Response {
+		return d.Unmount(volume.UnmountRequest{Name: "other", ID: "m2"})
+	}))
+	if res.Err != "" {
+		t.Fatalf("Unmount failed: %s", res.Err)
+	}
+	res = awaitResponse(t, "Get", goRequest(func() volume.Response { return d.Get(volume.Request{Name: "other"}) }))
+	if res.Err != "" || res.Volume == nil {
+		t.Fatalf("Get failed: %s", res.Err)
+	}
+	res = awaitResponse(t, "Path", goRequest(func() volume.Response { return d.Path(volume.Request{Name: "stuck"}) }))
+	if res.Mountpoint == "" {
+		t.Fatalf("Path of the volume being mounted failed: %s", res.Err)
+	}
+	res = awaitResponse(t, "List", goRequest(func() volume.Response { return d.List(volume.Request{}) }))
+	if len(res.Volumes) != 2 {
+		t.Fatalf("expected 2 volumes, got %d", len(res.Volumes))
+	}
+	d.RLock()
+	connections := b.connections
+	d.RUnlock()
+	if connections != 1 {
+		t.Fatalf("expected 1 connection left, got %d", connections)
+	}
+
+	select {
+	case res := <-mounted:
+		t.Fatalf("Mount completed while its server was blocked: %+v", res)
+	default:
+	}
+}
+
+// The operations of a volume wait for the operation holding its lock, even while the driver lock is released.
+func TestLockVolumeSerializesOperations(t *testing.T) {
+	d := newTestDriver(t)
+	srv, requested, release := newBlockingServer()
+	defer srv.Close()
+	addTestVolume(d, "stuck", srv.URL, "stuck-bucket", 0)
+
+	mounted := goRequest(func() volume.Response { return d.Mount(volume.MountRequest{Name: "stuck", ID: "m1"}) })
+	<-requested
+
+	locked := make(chan *mountInfo, 1)
+	go func() { locked <- d.lockVolume("stuck") }()
+	select {
+	case <-locked:
+		t.Fatal("lock of the volume taken while its Mount is in progress.")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	res := awaitResponse(t, "Mount", mounted)
+	if res.Err == "" {
+		t.Fatalf("expected Mount to fail on the missing bucket, got %+v", res)
+	}
+	select {
+	case v := <-locked:
+		if v == nil {
+			t.Fatal("volume not found once its Mount completed.")
+		}
+		v.ops.Unlock()
+	case <-time.After(testRequestTimeout):
+		t.Fatal("lock of the volume not released once its Mount completed.")
+	}
+}
+
+// A volume removed while waiting for its lock is not returned.
+func TestLockVolumeRemoved(t *testing.T) {
+	d := newTestDriver(t)
+	v := addTestVolume(d, "gone", "http://127.0.0.1:1", "gone-bucket", 0)
+	v.ops.Lock()
+
+	locked := make(chan *mountInfo, 1)
+	go func() { locked <- d.lockVolume("gone") }()
+	d.Lock()
+	delete(d.mounts, "gone")
+	d.Unlock()
+	v.ops.Unlock()
+
+	select {
+	case v := <-locked:
+		if v != nil {
+			t.Fatal("lock of the removed volume returned.")
+		}
+	case <-time.After(testRequestTimeout):
+		t.Fatal("lockVolume didn't return once the volume was removed.")
+	}
+}